@@ -0,0 +1,9 @@
+// Command cm_sync syncs helm charts between chart repositories, see
+// example.com/cm_sync/v2/pkg/chartsync for the library it wraps.
+package main
+
+import "example.com/cm_sync/v2/pkg/chartsync"
+
+func main() {
+	chartsync.Main()
+}
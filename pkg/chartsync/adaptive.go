@@ -0,0 +1,124 @@
+package chartsync
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// adaptiveLimit is the number of charts transferred in parallel with
+// -max-j, grown by one per round of transfers while the destination keeps
+// up and halved when it answers 429 or 5xx, fails with network errors or
+// gets more than twice as slow per byte as it was at its best. Its methods
+// do nothing on a nil adaptiveLimit.
+type adaptiveLimit struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  float64
+	max    int
+	active int
+	peak   int
+	// perByte is the smoothed transfer time per byte, best the lowest it
+	// has been.
+	perByte, best float64
+	// cut is when the limit was last halved, transfers started before it
+	// don't halve it again.
+	cut time.Time
+}
+
+// newAdaptiveLimit starts at start charts in parallel, up to max, it is
+// nil when max doesn't go beyond start.
+func newAdaptiveLimit(start, max int) *adaptiveLimit {
+	if max <= start {
+		return nil
+	}
+	l := &adaptiveLimit{limit: float64(start), max: max, peak: start}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// acquire waits until fewer charts than the limit are being transferred.
+func (l *adaptiveLimit) acquire() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for l.active >= int(l.limit) {
+		l.cond.Wait()
+	}
+	l.active++
+}
+
+func (l *adaptiveLimit) release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	l.cond.Broadcast()
+}
+
+// observe adjusts the limit to a transfer started at started, that moved
+// size bytes or failed with err.
+func (l *adaptiveLimit) observe(started time.Time, size int64, err error) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case err != nil && overloaded(err):
+		l.backOff(started, "the destination is overloaded")
+	case err != nil:
+	case size > 0:
+		sample := time.Since(started).Seconds() / float64(size)
+		if l.perByte == 0 {
+			l.perByte = sample
+		} else {
+			l.perByte = 0.8*l.perByte + 0.2*sample
+		}
+		if l.best == 0 || l.perByte < l.best {
+			l.best = l.perByte
+		}
+		if l.perByte > 2*l.best {
+			l.backOff(started, "transfers are slowing down")
+			return
+		}
+		fallthrough
+	default:
+		l.limit = min(l.limit+1/l.limit, float64(l.max))
+		l.peak = max(l.peak, int(l.limit))
+		l.cond.Broadcast()
+	}
+}
+
+func (l *adaptiveLimit) backOff(started time.Time, reason string) {
+	if started.Before(l.cut) || l.limit < 2 {
+		return
+	}
+	l.limit = float64(int(l.limit) / 2)
+	l.cut = time.Now()
+	// The slowdown is measured against the new limit from here on.
+	l.best = l.perByte
+	logf("Lowering to %d charts in parallel, %s\n", int(l.limit), reason)
+}
+
+// levels are the highest and the current number of charts in parallel.
+func (l *adaptiveLimit) levels() (peak, current int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.peak, int(l.limit)
+}
+
+// overloaded is true for errors of a server that should get fewer
+// requests: rate limits, server errors and timeouts.
+func overloaded(err error) bool {
+	var status statusError
+	if errors.As(err, &status) {
+		return status.code == http.StatusTooManyRequests || status.code >= 500
+	}
+	return ErrorKind(err) == "network"
+}
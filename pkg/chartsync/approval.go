@@ -0,0 +1,185 @@
+package chartsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// approvalWebhook is a webhook deciding on a request, it answers with the
+// approval or with 202 and posts it to the callback url of the request
+// later.
+type approvalWebhook struct {
+	url          string
+	callbackAddr string
+	// callbackURL is how the webhook reaches callbackAddr, http://HOSTNAME:PORT
+	// when empty.
+	callbackURL string
+	timeout     time.Duration
+}
+
+// ask posts the request that request builds for its callback url to the
+// webhook and waits for the decision on what. The callback url has the
+// random id in it, only the webhook knows it. The timeout covers the post
+// as well, a webhook that doesn't answer can't hold the run.
+func (w approvalWebhook) ask(ctx context.Context, id, what string, request func(callbackURL string) any) (approval, error) {
+	timedOut := fmt.Errorf("no decision within %s", w.timeout)
+	ctx, cancel := context.WithTimeoutCause(ctx, w.timeout, timedOut)
+	defer cancel()
+	ln, err := net.Listen("tcp", w.callbackAddr)
+	if err != nil {
+		return approval{}, fmt.Errorf("listening for the callback: %w", err)
+	}
+	defer ln.Close()
+	base := strings.TrimSuffix(w.callbackURL, "/")
+	if base == "" {
+		host, _ := os.Hostname()
+		base = "http://" + net.JoinHostPort(host, fmt.Sprint(ln.Addr().(*net.TCPAddr).Port))
+	}
+	callbackURL := base + "/approvals/" + id
+	decisions := make(chan approval, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var a approval
+		if r.Method != http.MethodPost || r.URL.Path != "/approvals/"+id {
+			http.NotFound(rw, r)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+		select {
+		case decisions <- a:
+		default:
+		}
+		rw.WriteHeader(http.StatusNoContent)
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	body, _ := json.Marshal(request(callbackURL))
+	r, err := http.NewRequestWithContext(ctx, "POST", w.url, bytes.NewReader(body))
+	if err != nil {
+		return approval{}, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+	resp, err := httpClient.Do(r)
+	if err != nil {
+		if context.Cause(ctx) == timedOut {
+			return approval{}, timedOut
+		}
+		return approval{}, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var a approval
+		if err := json.NewDecoder(resp.Body).Decode(&a); err != nil {
+			if context.Cause(ctx) == timedOut {
+				return approval{}, timedOut
+			}
+			return approval{}, fmt.Errorf("decoding the decision of %s: %w", w.url, err)
+		}
+		return a, nil
+	case http.StatusAccepted:
+	default:
+		return approval{}, fmt.Errorf("approval webhook %s: %w", w.url, unexpectedStatus(resp.StatusCode))
+	}
+
+	logln("Waiting for the approval of", what, "on", callbackURL)
+	select {
+	case a := <-decisions:
+		return a, nil
+	case <-ctx.Done():
+		return approval{}, context.Cause(ctx)
+	}
+}
+
+// changeSet is what the approval webhook of a sync is sent for the queue
+// of a job before it is uploaded.
+type changeSet struct {
+	ID          string          `json:"id"`
+	Source      string          `json:"source"`
+	Destination string          `json:"destination"`
+	RequestedBy string          `json:"requested_by"`
+	Versions    []changeVersion `json:"versions"`
+	// CallbackURL is where a webhook answering 202 posts its decision.
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+type changeVersion struct {
+	Chart   string `json:"chart"`
+	Version string `json:"version"`
+	Digest  string `json:"digest,omitempty"`
+}
+
+// changeGate has the approval webhook of a sync decide on each batch of
+// versions. Its methods approve everything on a nil changeGate.
+type changeGate struct {
+	webhook approvalWebhook
+	// allow is the decision when the webhook fails or doesn't decide in
+	// time.
+	allow bool
+}
+
+// review asks the webhook about the queue of job, it records the versions
+// as denied in summary and returns false when the change isn't approved.
+func (g *changeGate) review(ctx context.Context, job plannedJob, summary *runSummary) bool {
+	if g == nil || len(job.plan.queue) == 0 {
+		return true
+	}
+	cs := changeSet{
+		ID:          uuid.NewString(),
+		Source:      redact(fmt.Sprint(job.source)),
+		Destination: redact(fmt.Sprint(job.destination)),
+		RequestedBy: currentUser(),
+	}
+	for _, item := range job.plan.queue {
+		cs.Versions = append(cs.Versions, changeVersion{item.Chart, item.Version, indexDigest(job.plan.sourceData, item.Chart, item.Version)})
+	}
+	what := fmt.Sprintf("%d versions to %s", len(cs.Versions), cs.Destination)
+	a, err := g.webhook.ask(ctx, cs.ID, what, func(callbackURL string) any {
+		cs.CallbackURL = callbackURL
+		return cs
+	})
+	switch {
+	case ctx.Err() != nil:
+		return false
+	case err != nil && g.allow:
+		logf("Failed to get the approval of %s %v, syncing them by default\n", what, err)
+		return true
+	case err != nil:
+		a.Reason = redact(err.Error())
+		logf("Failed to get the approval of %s %v, denying them by default\n", what, err)
+	case a.Approved:
+		return true
+	default:
+		logf("Sync of %s was denied", what)
+		if a.Approver != "" {
+			logf(" by %s", a.Approver)
+		}
+		if a.Reason != "" {
+			logf(": %s", a.Reason)
+		}
+		logln()
+	}
+	reason := "change not approved"
+	if a.Approver != "" {
+		reason += " by " + a.Approver
+	}
+	if a.Reason != "" {
+		reason += ": " + a.Reason
+	}
+	for _, item := range job.plan.queue {
+		summary.deny(item, fmt.Sprint(job.destination), reason)
+	}
+	return false
+}
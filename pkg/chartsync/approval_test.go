@@ -0,0 +1,33 @@
+package chartsync
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApprovalWebhookTimeout(t *testing.T) {
+	// The webhook never answers the post.
+	done := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(done)
+
+	w := approvalWebhook{url: srv.URL, callbackAddr: "127.0.0.1:0", timeout: 100 * time.Millisecond}
+	start := time.Now()
+	_, err := w.ask(context.Background(), "id", "web-1.0.0", func(string) any { return struct{}{} })
+	if err == nil || !strings.Contains(err.Error(), "no decision within") {
+		t.Errorf("ask = %v, want no decision within the timeout", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("ask returned after %s", d)
+	}
+}
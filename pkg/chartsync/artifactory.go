@@ -0,0 +1,145 @@
+package chartsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+)
+
+// artifactoryRepo is a helm repository in Artifactory, server is the
+// Artifactory base url (https://host/artifactory) and the tenant the
+// repository key.
+type artifactoryRepo struct {
+	repo
+
+	mu    sync.Mutex
+	index ChartData
+}
+
+// newArtifactoryRepo splits the repository key off the url when no tenant
+// is given, e.g. https://host/artifactory/helm-local.
+func newArtifactoryRepo(server, key string, auth credentials, client *http.Client) *artifactoryRepo {
+	server = strings.TrimSuffix(server, "/")
+	if u, err := url.Parse(server); err == nil && key == "" {
+		if dir, last := path.Split(u.Path); last != "" && last != "artifactory" {
+			key = last
+			u.Path = strings.TrimSuffix(dir, "/")
+			server = u.String()
+		}
+	}
+	r := &artifactoryRepo{repo: repo{server: server, tenant: key, auth: auth, client: client}}
+	if auth.APIKey != "" {
+		r.headers = http.Header{"X-JFrog-Art-Api": {auth.APIKey}}
+	}
+	return r
+}
+
+func (a *artifactoryRepo) url() string {
+	return a.server + "/api/helm/" + a.tenant
+}
+
+func (a *artifactoryRepo) String() string {
+	return a.server + "/" + a.tenant
+}
+
+func (a *artifactoryRepo) artifactURL(chart, version string) string {
+	return a.server + "/" + a.tenant + "/" + escapePath(chart+"-"+version+".tgz")
+}
+
+func (a *artifactoryRepo) hasChart(ctx context.Context, chart, version string) (bool, error) {
+	return headExists(ctx, a.repo, a.artifactURL(chart, version))
+}
+
+func (a *artifactoryRepo) ping(ctx context.Context) error {
+	resp, err := a.get(ctx, a.server+"/api/system/ping")
+	if err != nil {
+		return fmt.Errorf("error making request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return unexpectedStatus(resp.StatusCode)
+	}
+	return nil
+}
+
+func (a *artifactoryRepo) indexVersion() string {
+	return indexes.digest(a.url() + "/index.yaml")
+}
+
+func (a *artifactoryRepo) listCharts(ctx context.Context) (ChartData, error) {
+	if a.tenant == "" {
+		return nil, fmt.Errorf("artifactory needs a repository key, pass it with -tenants or in the url path")
+	}
+	data, err := fetchIndex(ctx, a.repo, a.url())
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	a.index = data
+	a.mu.Unlock()
+	return data, nil
+}
+
+// fetchChart downloads from the url in the index, charts that aren't
+// indexed yet are expected at the root of the repository.
+func (a *artifactoryRepo) fetchChart(ctx context.Context, chart, version string) ([]byte, error) {
+	a.mu.Lock()
+	u, err := indexChartURL(a.index, a.url(), chart, version)
+	a.mu.Unlock()
+	if err != nil {
+		u = a.artifactURL(chart, version)
+	}
+	return downloadChart(ctx, a.repo, u)
+}
+
+func (a *artifactoryRepo) openChart(ctx context.Context, chart, version string) (io.ReadCloser, int64, error) {
+	a.mu.Lock()
+	u, err := indexChartURL(a.index, a.url(), chart, version)
+	a.mu.Unlock()
+	if err != nil {
+		u = a.artifactURL(chart, version)
+	}
+	return openURL(ctx, a.repo, u)
+}
+
+func (a *artifactoryRepo) fetchProvenance(ctx context.Context, chart, version string) ([]byte, error) {
+	a.mu.Lock()
+	u, err := indexChartURL(a.index, a.url(), chart, version)
+	a.mu.Unlock()
+	if err != nil {
+		u = a.artifactURL(chart, version)
+	}
+	return downloadProvenance(ctx, a.repo, u)
+}
+
+// pushChart deploys the tarball to the repository, Artifactory reindexes
+// helm repositories on its own.
+func (a *artifactoryRepo) pushChart(ctx context.Context, chart, version string, data []byte) error {
+	return a.pushChartStream(ctx, chart, version, bytes.NewReader(data), int64(len(data)))
+}
+
+func (a *artifactoryRepo) pushChartStream(ctx context.Context, chart, version string, body io.Reader, size int64) error {
+	req, err := a.newRequest(ctx, "PUT", a.artifactURL(chart, version), body)
+	if err != nil {
+		return err
+	}
+	if size > 0 {
+		req.ContentLength = size
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	resp, err := a.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return unexpectedStatus(resp.StatusCode)
+	}
+	return nil
+}
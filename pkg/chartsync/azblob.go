@@ -0,0 +1,196 @@
+package chartsync
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+const azblobAPIVersion = "2021-08-06"
+
+// azblobStore keeps the repository below prefix in an Azure Blob Storage
+// container, the layout chartmuseum's microsoft storage uses. The account
+// comes from ?account= or AZURE_STORAGE_ACCOUNT, requests are signed with
+// the SAS in AZURE_STORAGE_SAS_TOKEN or else an Azure AD token.
+type azblobStore struct {
+	endpoint  string
+	container string
+	prefix    string
+	sas       url.Values
+	cred      *azidentity.DefaultAzureCredential
+	client    *http.Client
+}
+
+func newAzblobStore(ref, tenant string, auth credentials, client *http.Client) (*azblobStore, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if tenant != "" {
+		prefix = strings.Trim(prefix+"/"+tenant, "/")
+	}
+	s := &azblobStore{container: u.Host, prefix: prefix, client: client}
+
+	account := u.Query().Get("account")
+	if account == "" {
+		account = os.Getenv("AZURE_STORAGE_ACCOUNT")
+	}
+	s.endpoint = strings.TrimSuffix(u.Query().Get("endpoint"), "/")
+	if s.endpoint == "" {
+		if account == "" {
+			return nil, fmt.Errorf("no storage account, set ?account= or AZURE_STORAGE_ACCOUNT")
+		}
+		s.endpoint = "https://" + account + ".blob.core.windows.net"
+	}
+
+	sas := auth.Token
+	if sas == "" {
+		sas = os.Getenv("AZURE_STORAGE_SAS_TOKEN")
+	}
+	if sas != "" {
+		if s.sas, err = url.ParseQuery(strings.TrimPrefix(sas, "?")); err != nil {
+			return nil, fmt.Errorf("invalid SAS token: %w", err)
+		}
+	} else if s.cred, err = newAzureCredential(client); err != nil {
+		return nil, fmt.Errorf("error loading azure credentials: %w", err)
+	}
+	return s, nil
+}
+
+func (s *azblobStore) String() string {
+	return "azblob://" + s.container + "/" + s.prefix
+}
+
+func (s *azblobStore) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+func (s *azblobStore) do(ctx context.Context, method, blob string, query url.Values, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	if query == nil {
+		query = url.Values{}
+	}
+	for k, v := range s.sas {
+		query[k] = v
+	}
+	u := s.endpoint + "/" + s.container
+	if blob != "" {
+		var segments []string
+		for _, s := range strings.Split(blob, "/") {
+			segments = append(segments, escapePath(s))
+		}
+		u += "/" + strings.Join(segments, "/")
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u+"?"+query.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("x-ms-version", azblobAPIVersion)
+	if s.cred != nil {
+		token, err := s.cred.GetToken(ctx, policy.TokenRequestOptions{
+			Scopes: []string{"https://storage.azure.com/.default"},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error getting azure ad token: %w", err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, errNotExist
+	}
+	if resp.StatusCode/100 != 2 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %w", method, req.URL.Path, unexpectedStatus(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+func (s *azblobStore) ping(ctx context.Context) error {
+	resp, err := s.do(ctx, "GET", "", url.Values{"restype": {"container"}}, nil, 0, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *azblobStore) list(ctx context.Context) ([]string, error) {
+	prefix := s.key("")
+	var names []string
+	marker := ""
+	for {
+		q := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}, "delimiter": {"/"}}
+		if marker != "" {
+			q.Set("marker", marker)
+		}
+		resp, err := s.do(ctx, "GET", "", q, nil, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Blobs struct {
+				Blob []struct {
+					Name string `xml:"Name"`
+				} `xml:"Blob"`
+			} `xml:"Blobs"`
+			NextMarker string `xml:"NextMarker"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range page.Blobs.Blob {
+			names = append(names, strings.TrimPrefix(b.Name, prefix))
+		}
+		if marker = page.NextMarker; marker == "" {
+			return names, nil
+		}
+	}
+}
+
+func (s *azblobStore) read(ctx context.Context, name string) ([]byte, error) {
+	resp, err := s.do(ctx, "GET", s.key(name), nil, nil, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (s *azblobStore) write(ctx context.Context, name string, r io.Reader, size int64) error {
+	contentType := "application/gzip"
+	if strings.HasSuffix(name, ".yaml") {
+		contentType = "application/x-yaml"
+	}
+	resp, err := s.do(ctx, "PUT", s.key(name), nil, r, size, http.Header{
+		"X-Ms-Blob-Type": {"BlockBlob"},
+		"Content-Type":   {contentType},
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,103 @@
+package chartsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+)
+
+// acrUsername is the fixed user name ACR expects alongside a refresh token.
+const acrUsername = "00000000-0000-0000-0000-000000000000"
+
+func isACRHost(host string) bool {
+	for _, suffix := range []string{".azurecr.io", ".azurecr.cn", ".azurecr.us"} {
+		if strings.HasSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// acrAuth exchanges an Azure AD token from the default credential chain
+// (environment service principal, workload identity, managed identity or
+// az cli) for an ACR refresh token, redoing the exchange when it expires.
+type acrAuth struct {
+	host   string
+	client *http.Client
+
+	mu      sync.Mutex
+	cred    *azidentity.DefaultAzureCredential
+	creds   credentials
+	expires time.Time
+}
+
+func newACRAuth(host string, client *http.Client) *acrAuth {
+	return &acrAuth{host: host, client: client}
+}
+
+func (a *acrAuth) credentials(ctx context.Context) (credentials, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if time.Until(a.expires) > 5*time.Minute {
+		return a.creds, nil
+	}
+
+	if a.cred == nil {
+		cred, err := newAzureCredential(a.client)
+		if err != nil {
+			return credentials{}, fmt.Errorf("error loading azure credentials: %w", err)
+		}
+		a.cred = cred
+	}
+	aad, err := a.cred.GetToken(ctx, policy.TokenRequestOptions{
+		Scopes: []string{"https://management.azure.com/.default"},
+	})
+	if err != nil {
+		return credentials{}, fmt.Errorf("error getting azure ad token: %w", err)
+	}
+
+	form := url.Values{
+		"grant_type":   {"access_token"},
+		"service":      {a.host},
+		"access_token": {aad.Token},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", "https://"+a.host+"/oauth2/exchange", strings.NewReader(form.Encode()))
+	if err != nil {
+		return credentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return credentials{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return credentials{}, fmt.Errorf("acr token exchange: %w", unexpectedStatus(resp.StatusCode))
+	}
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return credentials{}, err
+	}
+
+	a.creds = credentials{Username: acrUsername, Password: body.RefreshToken}
+	a.expires = aad.ExpiresOn
+	return a.creds, nil
+}
+
+// newAzureCredential loads the default azure credentials, token requests go
+// through client like every other request.
+func newAzureCredential(client *http.Client) (*azidentity.DefaultAzureCredential, error) {
+	return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+		ClientOptions: policy.ClientOptions{Transport: client},
+	})
+}
@@ -0,0 +1,270 @@
+package chartsync
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// benchChart is the name of the synthetic charts bench uploads.
+const benchChart = "cm-sync-bench"
+
+// timings are the durations of repeated operations.
+type timings []time.Duration
+
+func (t timings) String() string {
+	if len(t) == 0 {
+		return "-"
+	}
+	s := append(timings(nil), t...)
+	sort.Slice(s, func(i, j int) bool { return s[i] < s[j] })
+	var total time.Duration
+	for _, d := range s {
+		total += d
+	}
+	return fmt.Sprintf("min %s, avg %s, max %s", roundDuration(s[0]), roundDuration(total/time.Duration(len(s))), roundDuration(s[len(s)-1]))
+}
+
+// roundDuration rounds to milliseconds, or microseconds below one.
+func roundDuration(d time.Duration) time.Duration {
+	if d < time.Millisecond {
+		return d.Round(time.Microsecond)
+	}
+	return d.Round(time.Millisecond)
+}
+
+// transferBench is the outcome of transferring charts in parallel.
+type transferBench struct {
+	count   int
+	failed  int
+	bytes   int64
+	elapsed time.Duration
+	each    timings
+}
+
+func (b transferBench) String() string {
+	rate := int64(0)
+	if b.elapsed > 0 {
+		rate = int64(float64(b.bytes) / b.elapsed.Seconds())
+	}
+	s := fmt.Sprintf("%d charts, %s in %s, %s/s, per chart %s", b.count, formatSize(b.bytes), roundDuration(b.elapsed), formatSize(rate), b.each)
+	if b.failed > 0 {
+		s += fmt.Sprintf(", %d failed", b.failed)
+	}
+	return s
+}
+
+// benchTransfers runs transfer for every item, workers at a time.
+func benchTransfers(items []syncItem, workers int, transfer func(syncItem) (int64, error)) transferBench {
+	var mu sync.Mutex
+	var b transferBench
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(workers, 1))
+	start := time.Now()
+	for _, item := range items {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			t := time.Now()
+			n, err := transfer(item)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				logf("Failed to transfer %s-%s %v\n", item.Chart, item.Version, err)
+				b.failed++
+				return
+			}
+			b.count++
+			b.bytes += n
+			b.each = append(b.each, time.Since(t))
+		}()
+	}
+	wg.Wait()
+	b.elapsed = time.Since(start)
+	return b
+}
+
+// sampleItems picks n versions spread evenly over all of them.
+func sampleItems(items []syncItem, n int) []syncItem {
+	if n <= 0 || n >= len(items) {
+		return items
+	}
+	sample := make([]syncItem, n)
+	for i := range sample {
+		sample[i] = items[i*len(items)/n]
+	}
+	return sample
+}
+
+// benchChartData is a chart of about size bytes, its content is random so
+// compression doesn't make it smaller.
+func benchChartData(name, version string, size int64) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	meta := fmt.Sprintf("apiVersion: v2\nname: %s\nversion: %s\ndescription: synthetic chart uploaded by cm_sync\n", name, version)
+	files := []struct {
+		name string
+		data io.Reader
+		size int64
+	}{
+		{"Chart.yaml", bytes.NewBufferString(meta), int64(len(meta))},
+		{"files/random.bin", io.LimitReader(rand.Reader, size), size},
+	}
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name + "/" + f.name, Mode: 0644, Size: f.size, ModTime: time.Now()}); err != nil {
+			return nil, err
+		}
+		if _, err := io.Copy(tw, f.data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// runBench measures index fetches, the diff and chart download and upload
+// throughput, to size a migration before running it.
+func runBench(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	cf := newCommandFlags(fs, true, true)
+	tenant := fs.String("tenant", "", "org/repo path on a multitenant chartmuseum")
+	rounds := fs.Int("rounds", 3, "number of times each index is fetched")
+	sample := fs.Int("sample", 10, "number of source versions downloaded, spread over all of them, 0 downloads all")
+	uploads := fs.Int("upload", 0, "number of synthetic charts uploaded to the destination, 0 skips the upload")
+	sizeFlag := fs.String("size", "1M", "size of the synthetic charts")
+	keep := fs.Bool("keep", false, "leave the synthetic charts on the destination, needed where deleting isn't supported")
+	concurrency := fs.Int("j", 4, "number of charts transferred in parallel")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cm_sync bench [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	cfg := cf.config(fs)
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	size, err := parseSize(*sizeFlag)
+	if err != nil {
+		logln("Error parsing -size:", err)
+		os.Exit(1)
+	}
+	t := normalizeTenant(*tenant)
+
+	src, sourceData := openSource(ctx, cfg, t)
+	var dst chartDestination
+	var destData ChartData
+	if set["d"] || *cf.configFile != "" || *uploads > 0 {
+		dst, destData = openDestination(ctx, cfg, t)
+	}
+	if *uploads > 0 && !*keep {
+		if _, _, err := deleteURL(dst, benchChart, "0.0.0"); err != nil {
+			logln("Error:", err, "\n pass -keep to leave the synthetic charts there")
+			os.Exit(1)
+		}
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer tw.Flush()
+	fetches := func(r interface {
+		listCharts(context.Context) (ChartData, error)
+	}) (timings, ChartData) {
+		var ts timings
+		var data ChartData
+		for range max(*rounds, 1) {
+			start := time.Now()
+			d, err := r.listCharts(ctx)
+			if err != nil {
+				logln("Failed to fetch charts of", r, err)
+				continue
+			}
+			ts = append(ts, time.Since(start))
+			data = d
+		}
+		return ts, data
+	}
+	versions := func(data ChartData) int {
+		n := 0
+		for _, v := range data {
+			n += len(v)
+		}
+		return n
+	}
+
+	ts, _ := fetches(src)
+	fmt.Fprintf(tw, "Source index\t %d charts, %d versions, %s\n", len(sourceData), versions(sourceData), ts)
+	if dst != nil {
+		ts, _ := fetches(dst)
+		fmt.Fprintf(tw, "Destination index\t %d charts, %d versions, %s\n", len(destData), versions(destData), ts)
+		start := time.Now()
+		diff := compareCharts(applyPolicy(sourceData, cfg.syncOptions), destData)
+		elapsed := time.Since(start)
+		fmt.Fprintf(tw, "Diff\t %d versions missing, %s\n", len(sortedItems(diff)), roundDuration(elapsed))
+	}
+
+	items := sampleItems(sortedItems(compareCharts(sourceData, nil)), *sample)
+	download := benchTransfers(items, *concurrency, func(item syncItem) (int64, error) {
+		if s, ok := src.(chartStreamer); ok {
+			body, _, err := s.openChart(ctx, item.Chart, item.Version)
+			if err != nil {
+				return 0, err
+			}
+			defer body.Close()
+			return io.Copy(io.Discard, body)
+		}
+		data, err := src.fetchChart(ctx, item.Chart, item.Version)
+		return int64(len(data)), err
+	})
+	fmt.Fprintf(tw, "Download\t %s\n", download)
+
+	if *uploads <= 0 || ctx.Err() != nil {
+		return
+	}
+	stamp := time.Now().Unix()
+	var charts []syncItem
+	for i := range *uploads {
+		charts = append(charts, syncItem{Chart: benchChart, Version: fmt.Sprintf("0.0.%d-bench.%d", i, stamp)})
+	}
+	data := make(map[syncItem][]byte, len(charts))
+	for _, item := range charts {
+		if data[item], err = benchChartData(benchChart, item.Version, size); err != nil {
+			logln("Error generating synthetic chart:", err)
+			os.Exit(1)
+		}
+	}
+	upload := benchTransfers(charts, *concurrency, func(item syncItem) (int64, error) {
+		return int64(len(data[item])), dst.pushChart(ctx, item.Chart, item.Version, data[item])
+	})
+	fmt.Fprintf(tw, "Upload\t %s\n", upload)
+
+	if *keep {
+		if f, ok := dst.(interface{ flush(context.Context) error }); ok {
+			if err := f.flush(ctx); err != nil {
+				logf("Failed to write index of %s %v\n", dst, err)
+			}
+		}
+		fmt.Fprintf(tw, "Left on destination\t %d synthetic %s charts\n", len(charts), benchChart)
+		return
+	}
+	for _, item := range charts {
+		if err := deleteChart(context.WithoutCancel(ctx), dst, item.Chart, item.Version); err != nil {
+			logf("Failed to delete %s-%s %v\n", item.Chart, item.Version, err)
+		}
+	}
+}
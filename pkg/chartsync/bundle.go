@@ -0,0 +1,724 @@
+package chartsync
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/klauspost/compress/zstd"
+	"github.com/schollz/progressbar/v3"
+)
+
+// bundleManifest describes the charts in an air-gap bundle, it is stored
+// as manifest.json next to the charts/ directory of the archive.
+type bundleManifest struct {
+	Version int           `json:"version"`
+	Created time.Time     `json:"created"`
+	Source  string        `json:"source"`
+	Base    string        `json:"base,omitempty"`
+	Charts  []bundleChart `json:"charts"`
+	// Files holds the sha256 of every other file in the bundle.
+	Files map[string]string `json:"files,omitempty"`
+
+	signed bool
+	// signers are the fingerprints of the key the manifest is signed with
+	// and of its primary key.
+	signers []string
+	corrupt map[string]bool
+}
+
+type bundleChart struct {
+	Name       string       `json:"name"`
+	Version    string       `json:"version"`
+	File       string       `json:"file"`
+	Size       int64        `json:"size"`
+	Digest     string       `json:"digest"`
+	Provenance string       `json:"provenance,omitempty"`
+	Metadata   ChartVersion `json:"metadata"`
+}
+
+type bundleOptions struct {
+	base       string
+	recipients *bundleRecipients
+	identities []age.Identity
+	signKey    string
+}
+
+type bundleWriter struct {
+	f    *os.File
+	enc  io.WriteCloser
+	zw   *zstd.Encoder
+	tw   *tar.Writer
+	now  time.Time
+	sums map[string]string
+}
+
+// createBundle writes a bundle to path, encrypted when there are
+// recipients.
+func createBundle(path string, recipients *bundleRecipients) (*bundleWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	b := &bundleWriter{f: f, now: time.Now(), sums: make(map[string]string)}
+	var w io.Writer = f
+	if recipients != nil {
+		if b.enc, err = recipients.encrypt(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		w = b.enc
+	}
+	if b.zw, err = zstd.NewWriter(w); err != nil {
+		f.Close()
+		return nil, err
+	}
+	b.tw = tar.NewWriter(b.zw)
+	return b, nil
+}
+
+func (b *bundleWriter) add(name string, data []byte) error {
+	return b.addFrom(name, bytes.NewReader(data), int64(len(data)))
+}
+
+func (b *bundleWriter) addFrom(name string, r io.Reader, size int64) error {
+	hdr := &tar.Header{Name: name, Mode: 0o644, Size: size, ModTime: b.now, Typeflag: tar.TypeReg}
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(b.tw, io.TeeReader(r, h)); err != nil {
+		return err
+	}
+	b.sums[name] = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+// finish adds the manifest, its signature when signing with a gpg key and
+// checksums.txt in sha256sum format for checking an unpacked bundle.
+func (b *bundleWriter) finish(manifest bundleManifest, signKey string) error {
+	manifest.Files = make(map[string]string, len(b.sums))
+	for name, sum := range b.sums {
+		manifest.Files[name] = sum
+	}
+	m, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := b.add("manifest.json", m); err != nil {
+		return err
+	}
+	if signKey != "" {
+		sig, err := gpgSign(m, signKey)
+		if err != nil {
+			return fmt.Errorf("error signing manifest: %w", err)
+		}
+		if err := b.add("manifest.json.asc", sig); err != nil {
+			return err
+		}
+	}
+	names := make([]string, 0, len(b.sums))
+	for name := range b.sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sums strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&sums, "%s  %s\n", b.sums[name], name)
+	}
+	return b.add("checksums.txt", []byte(sums.String()))
+}
+
+func (b *bundleWriter) close() error {
+	err := b.tw.Close()
+	if zerr := b.zw.Close(); err == nil {
+		err = zerr
+	}
+	if b.enc != nil {
+		if eerr := b.enc.Close(); err == nil {
+			err = eerr
+		}
+	}
+	if ferr := b.f.Close(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+// walkBundle calls fn for every file in the bundle at path, encrypted
+// bundles are decrypted on the fly.
+func walkBundle(path string, ids []age.Identity, fn func(name string, r io.Reader) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	plain, err := decryptBundle(f, ids)
+	if err != nil {
+		return err
+	}
+	defer plain.Close()
+	zr, err := zstd.NewReader(plain)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// readBundleManifest returns the manifest of a bundle together with the
+// sha256 of every file in it. A signed manifest must verify and every file
+// must be listed in it, files whose checksum doesn't match the manifest or
+// checksums.txt are marked corrupt.
+func readBundleManifest(path string, ids []age.Identity) (*bundleManifest, map[string]string, error) {
+	var raw, sig, sums []byte
+	digests := make(map[string]string)
+	err := walkBundle(path, ids, func(name string, r io.Reader) error {
+		var err error
+		switch name {
+		case "manifest.json":
+			raw, err = io.ReadAll(r)
+			return err
+		case "manifest.json.asc":
+			sig, err = io.ReadAll(r)
+			return err
+		case "checksums.txt":
+			sums, err = io.ReadAll(r)
+			return err
+		}
+		h := sha256.New()
+		if _, err := io.Copy(h, r); err != nil {
+			return err
+		}
+		digests[name] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if raw == nil {
+		return nil, nil, errors.New("bundle has no manifest.json")
+	}
+	manifest := &bundleManifest{corrupt: make(map[string]bool)}
+	if err := json.Unmarshal(raw, manifest); err != nil {
+		return nil, nil, fmt.Errorf("error decoding manifest.json: %w", err)
+	}
+	if manifest.Version != 1 {
+		return nil, nil, fmt.Errorf("unsupported bundle version %d", manifest.Version)
+	}
+	if sig != nil {
+		if manifest.signers, err = gpgVerify(raw, sig); err != nil {
+			return nil, nil, fmt.Errorf("manifest signature: %w", err)
+		}
+		manifest.signed = true
+	}
+	if manifest.Files != nil {
+		for name, sum := range digests {
+			if want, ok := manifest.Files[name]; !ok {
+				return nil, nil, fmt.Errorf("%s is not listed in the manifest", name)
+			} else if want != sum {
+				logf("Checksum mismatch for %s, manifest %s bundle %s\n", name, want, sum)
+				manifest.corrupt[name] = true
+			}
+		}
+		for name := range manifest.Files {
+			if _, ok := digests[name]; !ok {
+				return nil, nil, fmt.Errorf("%s is listed in the manifest but missing from the bundle", name)
+			}
+		}
+	}
+	if sums != nil {
+		for _, line := range strings.Split(strings.TrimSpace(string(sums)), "\n") {
+			sum, name, ok := strings.Cut(line, "  ")
+			if !ok {
+				return nil, nil, fmt.Errorf("invalid checksums.txt line %q", line)
+			}
+			got, known := digests[name]
+			switch name {
+			case "manifest.json":
+				got, known = chartDigest(raw), true
+			case "manifest.json.asc":
+				got, known = chartDigest(sig), sig != nil
+			}
+			if !known {
+				return nil, nil, fmt.Errorf("%s is listed in checksums.txt but missing from the bundle", name)
+			}
+			if got != sum {
+				if name == "manifest.json" || name == "manifest.json.asc" {
+					return nil, nil, corruptf("checksum mismatch for %s in checksums.txt", name)
+				}
+				logf("Checksum mismatch for %s in checksums.txt\n", name)
+				manifest.corrupt[name] = true
+			}
+		}
+	}
+	for _, c := range manifest.Charts {
+		if c.Name == "" || c.Version == "" || c.File == "" || c.Digest == "" {
+			return nil, nil, fmt.Errorf("incomplete manifest entry for %s-%s", c.Name, c.Version)
+		}
+		if _, ok := digests[c.File]; !ok {
+			return nil, nil, fmt.Errorf("%s is listed in the manifest but missing from the bundle", c.File)
+		}
+	}
+	return manifest, digests, nil
+}
+
+// importBundle uploads the charts of a bundle that dst doesn't have yet,
+// counting them in summary. The whole bundle is checked first, a chart
+// whose digest doesn't match the manifest fails the import before anything
+// is uploaded. With trusted signers the manifest must be signed by one of
+// them.
+func importBundle(ctx context.Context, path string, dst chartDestination, ids []age.Identity, trusted []string, summary *runSummary) error {
+	manifest, digests, err := readBundleManifest(path, ids)
+	if err != nil {
+		return fmt.Errorf("invalid bundle: %w", err)
+	}
+	if len(trusted) > 0 && !manifest.signed {
+		return errors.New("invalid bundle: manifest is not signed")
+	}
+	if len(trusted) > 0 && !trustedSigner(manifest.signers, trusted) {
+		return fmt.Errorf("invalid bundle: manifest is signed by %s, not a -trusted-signer", strings.Join(manifest.signers, ", "))
+	}
+	existing, err := dst.listCharts(ctx)
+	if err != nil {
+		return fmt.Errorf("error fetching charts: %w", err)
+	}
+
+	bundled := make(ChartData)
+	files := make(map[string]bundleChart)
+	var tampered []string
+	for _, c := range manifest.Charts {
+		if manifest.corrupt[c.File] {
+			tampered = append(tampered, c.Name+"-"+c.Version)
+			continue
+		}
+		if digests[c.File] != c.Digest {
+			logf("Checksum mismatch for %s-%s, manifest %s bundle %s\n", c.Name, c.Version, c.Digest, digests[c.File])
+			tampered = append(tampered, c.Name+"-"+c.Version)
+			continue
+		}
+		bundled[c.Name] = append(bundled[c.Name], ChartVersion{Name: c.Name, Version: c.Version})
+		files[c.Name+"-"+c.Version] = c
+	}
+	if len(tampered) > 0 {
+		return fmt.Errorf("invalid bundle: %w", corruptf("%d charts don't match the manifest: %s", len(tampered), strings.Join(tampered, ", ")))
+	}
+	summary.examine(len(bundled))
+	missing := make(map[string]bundleChart)
+	for chart, versions := range compareCharts(bundled, existing) {
+		for _, version := range versions {
+			c := files[chart+"-"+version]
+			missing[c.File] = c
+		}
+	}
+
+	bar := progressbar.Default(int64(len(missing)), "Importing Charts")
+	err = walkBundle(path, ids, func(name string, r io.Reader) error {
+		c, ok := missing[name]
+		if !ok {
+			return nil
+		}
+		item := syncItem{Chart: c.Name, Version: c.Version}
+		if maxChartSize > 0 && c.Size > maxChartSize {
+			logf("Skipping %s-%s %v (%d bytes)\n", c.Name, c.Version, errChartTooLarge, c.Size)
+			summary.skip(item)
+			return nil
+		}
+		sp, err := newSpool(r)
+		if err != nil {
+			return err
+		}
+		defer sp.close()
+		if digest, err := sp.digest(); err != nil || digest != c.Digest {
+			return corruptf("%s-%s changed while reading the bundle", c.Name, c.Version)
+		}
+		if s, ok := dst.(chartStreamPusher); ok {
+			err = s.pushChartStream(ctx, c.Name, c.Version, sp.reader(), sp.size)
+		} else {
+			var data []byte
+			if data, err = sp.bytes(); err == nil {
+				err = dst.pushChart(ctx, c.Name, c.Version, data)
+			}
+		}
+		if err != nil {
+			err = destError(err)
+			logf("Failed to import %s-%s to %s %v\n", c.Name, c.Version, dst, withCode(err))
+			summary.fail(item, err)
+			return nil
+		}
+		summary.sync(item, sp.size)
+		bar.Describe(c.Name + "-" + c.Version)
+		bar.Add(1)
+		return nil
+	})
+	if f, ok := dst.(interface{ flush(context.Context) error }); ok {
+		if ferr := f.flush(ctx); ferr != nil {
+			logf("Failed to write index of %s %v\n", dst, ferr)
+		}
+	}
+	return err
+}
+
+// loadBaseManifest reads the manifest a differential export is based on,
+// either a manifest.json or a previous bundle. It returns the digest of
+// every chart version in it.
+func loadBaseManifest(path string, ids []age.Identity) (map[string]string, error) {
+	var manifest *bundleManifest
+	var err error
+	if strings.HasSuffix(path, ".json") {
+		var data []byte
+		if data, err = os.ReadFile(path); err == nil {
+			manifest = &bundleManifest{}
+			err = json.Unmarshal(data, manifest)
+		}
+	} else {
+		manifest, _, err = readBundleManifest(path, ids)
+	}
+	if err != nil {
+		return nil, err
+	}
+	digests := make(map[string]string)
+	for _, c := range manifest.Charts {
+		digests[c.Name+"-"+c.Version] = c.Digest
+	}
+	return digests, nil
+}
+
+// exportQueue lists the chart versions selected by opts, sorted by name
+// and version.
+func exportQueue(ctx context.Context, src chartSource, opts syncOptions) ([]ChartVersion, error) {
+	data, err := src.listCharts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching charts: %w", err)
+	}
+	var queue []ChartVersion
+	for _, versions := range applyPolicy(data, opts) {
+		queue = append(queue, versions...)
+	}
+	sort.Slice(queue, func(i, j int) bool {
+		if queue[i].Name != queue[j].Name {
+			return queue[i].Name < queue[j].Name
+		}
+		return newerVersion(queue[i].Version, queue[j].Version)
+	})
+	return queue, nil
+}
+
+// exportManifest writes the manifest of src without downloading any chart,
+// digests come from the index. Run against a destination it records the
+// state a differential export can be based on.
+func exportManifest(ctx context.Context, src chartSource, opts syncOptions, out string) error {
+	queue, err := exportQueue(ctx, src, opts)
+	if err != nil {
+		return err
+	}
+	manifest := bundleManifest{Version: 1, Created: time.Now().UTC(), Source: src.String()}
+	for _, cv := range queue {
+		manifest.Charts = append(manifest.Charts, bundleChart{Name: cv.Name, Version: cv.Version, Digest: cv.Digest, Metadata: cv})
+	}
+	m, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(out, m, 0o644)
+}
+
+// exportBundle packs the charts selected by opts into a bundle at out.
+// With a base manifest only versions that are new or whose digest changed
+// are packed. The manifest goes last, it is only complete once every chart
+// was fetched: when one can't be, the others are still tried to list them
+// all and the unfinished bundle is removed.
+func exportBundle(ctx context.Context, src chartSource, opts syncOptions, out string, bopts bundleOptions) error {
+	var base map[string]string
+	if bopts.base != "" {
+		var err error
+		if base, err = loadBaseManifest(bopts.base, bopts.identities); err != nil {
+			return fmt.Errorf("error reading base manifest: %w", err)
+		}
+	}
+	queue, err := exportQueue(ctx, src, opts)
+	if err != nil {
+		return err
+	}
+	if base != nil {
+		var changed []ChartVersion
+		for _, cv := range queue {
+			if digest, ok := base[cv.Name+"-"+cv.Version]; !ok || cv.Digest == "" || digest != cv.Digest {
+				changed = append(changed, cv)
+			}
+		}
+		queue = changed
+	}
+
+	b, err := createBundle(out, bopts.recipients)
+	if err != nil {
+		return err
+	}
+	manifest := bundleManifest{Version: 1, Created: b.now.UTC(), Source: src.String(), Base: bopts.base}
+	bar := progressbar.Default(int64(len(queue)), "Exporting Charts")
+	var failed []string
+	for _, cv := range queue {
+		sp, err := fetchSpool(ctx, src, cv.Name, cv.Version, cv.Digest)
+		if err != nil {
+			logf("Failed to fetch %s-%s from %s %v\n", cv.Name, cv.Version, src, withCode(sourceError(err)))
+			failed = append(failed, cv.Name+"-"+cv.Version)
+			bar.Add(1)
+			continue
+		}
+		digest, err := sp.digest()
+		if err != nil {
+			sp.close()
+			b.close()
+			return err
+		}
+		if d, ok := base[cv.Name+"-"+cv.Version]; ok && d == digest {
+			sp.close()
+			bar.Add(1)
+			continue
+		}
+		entry := bundleChart{
+			Name:     cv.Name,
+			Version:  cv.Version,
+			File:     "charts/" + cv.Name + "-" + cv.Version + ".tgz",
+			Size:     sp.size,
+			Digest:   digest,
+			Metadata: cv,
+		}
+		err = b.addFrom(entry.File, sp.reader(), sp.size)
+		sp.close()
+		if err != nil {
+			b.close()
+			return err
+		}
+		if p, ok := src.(interface {
+			fetchProvenance(ctx context.Context, chart, version string) ([]byte, error)
+		}); ok {
+			prov, err := p.fetchProvenance(ctx, cv.Name, cv.Version)
+			if err != nil {
+				logf("Failed to fetch provenance of %s-%s %v\n", cv.Name, cv.Version, err)
+			} else if prov != nil {
+				entry.Provenance = entry.File + ".prov"
+				if err := b.add(entry.Provenance, prov); err != nil {
+					b.close()
+					return err
+				}
+			}
+		}
+		manifest.Charts = append(manifest.Charts, entry)
+		bar.Describe(cv.Name + "-" + cv.Version)
+		bar.Add(1)
+	}
+
+	if len(failed) > 0 {
+		b.close()
+		os.Remove(out)
+		return fmt.Errorf("%d versions couldn't be fetched, no bundle was written: %s", len(failed), strings.Join(failed, ", "))
+	}
+	if err := b.finish(manifest, bopts.signKey); err != nil {
+		b.close()
+		return err
+	}
+	return b.close()
+}
+
+func runExport(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	cf := addSourceFlags(fs)
+	out := fs.String("o", "bundle.tar.zst", "bundle file to write")
+	basePath := fs.String("base", "", "manifest.json or bundle of a previous export, only new and changed versions are exported")
+	manifestOnly := fs.Bool("manifest-only", false, "only write the manifest of the source to -o, e.g. of the destination for a later -base")
+	recipients := fs.String("encrypt-recipient", "", "comma separated age (age1..., ssh-...) or gpg recipients to encrypt the bundle to")
+	identities := fs.String("identity", "", "comma separated age identity or ssh key files to open an encrypted -base bundle")
+	signKey := fs.String("sign-key", "", "gpg key id or email to sign the bundle manifest with")
+	maxChartSizeFlag := fs.String("max-chart-size", "", "refuse charts larger than this (e.g. 100M), unlimited if empty")
+	maxMemory := fs.String("max-memory", "", "largest chart held in memory (e.g. 64M), bigger charts are spooled to -spool-dir")
+	spoolDirFlag := fs.String("spool-dir", "", "directory for spooled charts, the system temp dir if empty")
+	chartCacheFlag := fs.String("chart-cache", "", "directory keeping downloaded charts by digest, they are fetched from the source once for all destinations and runs")
+	chartCacheSize := fs.String("chart-cache-size", "", "size the -chart-cache is kept under (e.g. 10G), dropping the least recently used charts, unlimited if empty")
+	maxBandwidth := fs.String("max-bandwidth", "", "transfer rate limit, e.g. 10MB/s for all traffic or down=8MB/s,up=2MB/s per direction, unlimited if empty")
+	fs.Parse(args)
+	cfg := cf.config(fs)
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if set["max-memory"] {
+		cfg.MaxMemory = *maxMemory
+	}
+	if set["spool-dir"] {
+		cfg.SpoolDir = *spoolDirFlag
+	}
+	if err := setSpool(cfg.MaxMemory, cfg.SpoolDir); err != nil {
+		logln("Error parsing -max-memory:", err)
+		os.Exit(1)
+	}
+	if set["chart-cache"] || set["chart-cache-size"] {
+		if set["chart-cache"] {
+			cfg.ChartCache = *chartCacheFlag
+		}
+		if set["chart-cache-size"] {
+			cfg.ChartCacheSize = *chartCacheSize
+		}
+		if err := setChartCache(cfg.ChartCache, cfg.ChartCacheSize); err != nil {
+			logln("Error opening -chart-cache:", err)
+			os.Exit(1)
+		}
+	}
+	if set["max-chart-size"] {
+		cfg.MaxChartSize = *maxChartSizeFlag
+	}
+	if err := setMaxChartSize(cfg.MaxChartSize); err != nil {
+		logln("Error parsing -max-chart-size:", err)
+		os.Exit(1)
+	}
+	if set["max-bandwidth"] {
+		cfg.MaxBandwidth = *maxBandwidth
+	}
+	if err := setBandwidth(cfg.MaxBandwidth); err != nil {
+		logln("Error parsing -max-bandwidth:", err)
+		os.Exit(1)
+	}
+	if cfg.SourceType == "" && cfg.SourceIndex == "" && isHTTP(cfg.Source) {
+		cfg.SourceType = detectServerType(ctx, cfg.Source, cfg.SourceAuth, cfg.client)
+	}
+
+	src, err := cfg.newSource("", cfg.syncOptions)
+	if err == nil {
+		err = src.ping(ctx)
+	}
+	if err != nil {
+		logln("Error checking source:", cfg.Source, "\n", withCode(sourceError(err)))
+		os.Exit(1)
+	}
+	if *manifestOnly {
+		err = exportManifest(ctx, src, cfg.syncOptions, *out)
+	} else {
+		bopts := bundleOptions{base: *basePath, signKey: *signKey}
+		if bopts.identities, err = loadIdentities(splitList(*identities)); err == nil && *recipients != "" {
+			bopts.recipients, err = parseRecipients(splitList(*recipients))
+		}
+		if err == nil {
+			err = exportBundle(ctx, src, cfg.syncOptions, *out, bopts)
+		}
+	}
+	if err != nil {
+		logln("Error writing bundle:", *out, "\n", err)
+		os.Exit(1)
+	}
+}
+
+func runImport(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	cf := addDestinationFlags(fs)
+	in := fs.String("i", "bundle.tar.zst", "bundle file written by cm_sync export")
+	indexURL := fs.String("index-url", "", "url a file or bucket destination is served from, used for absolute chart urls in index.yaml")
+	identities := fs.String("identity", "", "comma separated age identity or ssh key files for age encrypted bundles, gpg uses its keyring")
+	requireSignature := fs.Bool("require-signature", false, "refuse bundles without a manifest signed by a -trusted-signer")
+	trustedSigners := fs.String("trusted-signer", "", "comma separated gpg fingerprints of the keys bundle manifests may be signed with, implies -require-signature")
+	summaryJSON := fs.String("summary-json", "", "file to write the import summary with the failed versions and their error codes to as json")
+	maxChartSizeFlag := fs.String("max-chart-size", "", "refuse charts larger than this (e.g. 100M), unlimited if empty")
+	maxMemory := fs.String("max-memory", "", "largest chart held in memory (e.g. 64M), bigger charts are spooled to -spool-dir")
+	spoolDirFlag := fs.String("spool-dir", "", "directory for spooled charts, the system temp dir if empty")
+	maxBandwidth := fs.String("max-bandwidth", "", "transfer rate limit, e.g. 10MB/s for all traffic or down=8MB/s,up=2MB/s per direction, unlimited if empty")
+	fs.Parse(args)
+	cfg := cf.config(fs)
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if set["index-url"] {
+		cfg.IndexURL = *indexURL
+	}
+	if set["max-memory"] {
+		cfg.MaxMemory = *maxMemory
+	}
+	if set["spool-dir"] {
+		cfg.SpoolDir = *spoolDirFlag
+	}
+	if err := setSpool(cfg.MaxMemory, cfg.SpoolDir); err != nil {
+		logln("Error parsing -max-memory:", err)
+		os.Exit(1)
+	}
+	if set["max-chart-size"] {
+		cfg.MaxChartSize = *maxChartSizeFlag
+	}
+	if err := setMaxChartSize(cfg.MaxChartSize); err != nil {
+		logln("Error parsing -max-chart-size:", err)
+		os.Exit(1)
+	}
+	if set["max-bandwidth"] {
+		cfg.MaxBandwidth = *maxBandwidth
+	}
+	if err := setBandwidth(cfg.MaxBandwidth); err != nil {
+		logln("Error parsing -max-bandwidth:", err)
+		os.Exit(1)
+	}
+	if set["trusted-signer"] {
+		cfg.TrustedSigners = splitList(*trustedSigners)
+	}
+	for _, fpr := range cfg.TrustedSigners {
+		if !isFingerprint(fpr) {
+			logf("Error parsing -trusted-signer: %q isn't a full gpg fingerprint\n", fpr)
+			os.Exit(1)
+		}
+	}
+	if *requireSignature && len(cfg.TrustedSigners) == 0 {
+		logln("Error: -require-signature needs the -trusted-signer fingerprints, a signature of any key in the keyring proves nothing")
+		os.Exit(1)
+	}
+	if cfg.DestinationType == "" && cfg.DestinationIndex == "" && isHTTP(cfg.Destination) {
+		cfg.DestinationType = detectServerType(ctx, cfg.Destination, cfg.DestinationAuth, cfg.client)
+	}
+
+	dst, err := cfg.newDestination("", cfg.syncOptions)
+	if err == nil {
+		err = dst.ping(ctx)
+	}
+	if err != nil {
+		logln("Error checking destination:", cfg.Destination, "\n", withCode(destError(err)))
+		os.Exit(1)
+	}
+	summary := newRunSummary()
+	ids, err := loadIdentities(splitList(*identities))
+	if err == nil {
+		err = importBundle(ctx, *in, dst, ids, cfg.TrustedSigners, summary)
+	}
+	if err != nil {
+		logln("Error importing bundle:", *in, "\n", withCode(err))
+		os.Exit(1)
+	}
+	summary.print(os.Stdout)
+	if *summaryJSON != "" {
+		if err := summary.writeJSON(*summaryJSON); err != nil {
+			logln("Failed to write summary", *summaryJSON, err)
+		}
+	}
+	if len(summary.failures) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,354 @@
+package chartsync
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memRepo is a chart repository in memory, fetching or pushing one of the
+// versions in fail returns an error.
+type memRepo struct {
+	name string
+	fail map[string]bool
+
+	mu     sync.Mutex
+	charts map[string][]byte
+	index  ChartData
+}
+
+func newMemRepo(name string) *memRepo {
+	return &memRepo{name: name, charts: make(map[string][]byte), index: make(ChartData)}
+}
+
+func (r *memRepo) add(chart, version string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.charts[chart+"-"+version] = data
+	r.index[chart] = append(r.index[chart], ChartVersion{Name: chart, Version: version, Digest: chartDigest(data)})
+}
+
+func (r *memRepo) has(chart, version string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.charts[chart+"-"+version]
+	return ok
+}
+
+func (r *memRepo) ping(ctx context.Context) error { return nil }
+
+func (r *memRepo) listCharts(ctx context.Context) (ChartData, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data := make(ChartData)
+	for chart, versions := range r.index {
+		data[chart] = append([]ChartVersion(nil), versions...)
+	}
+	return data, nil
+}
+
+func (r *memRepo) fetchChart(ctx context.Context, chart, version string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, ok := r.charts[chart+"-"+version]
+	if !ok || r.fail[chart+"-"+version] {
+		return nil, fmt.Errorf("error fetching %s-%s", chart, version)
+	}
+	return data, nil
+}
+
+func (r *memRepo) pushChart(ctx context.Context, chart, version string, data []byte) error {
+	if r.fail[chart+"-"+version] {
+		return fmt.Errorf("error pushing %s-%s", chart, version)
+	}
+	r.add(chart, version, data)
+	return nil
+}
+
+func (r *memRepo) String() string { return r.name }
+
+type bundleFile struct {
+	name string
+	data []byte
+}
+
+// writeTestBundle writes files into an unencrypted bundle as they are,
+// without adding a manifest or checksums.
+func writeTestBundle(t *testing.T, files ...bundleFile) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "bundle.tar.zst")
+	b, err := createBundle(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		if err := b.add(f.name, f.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadBundleManifest(t *testing.T) {
+	chart := []byte("chart web-1.0.0")
+	const file = "charts/web-1.0.0.tgz"
+	manifest := func(files map[string]string) []byte {
+		m, err := json.Marshal(bundleManifest{
+			Version: 1,
+			Charts:  []bundleChart{{Name: "web", Version: "1.0.0", File: file, Size: int64(len(chart)), Digest: chartDigest(chart)}},
+			Files:   files,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	good := manifest(map[string]string{file: chartDigest(chart)})
+	sums := func(m []byte) []byte {
+		return []byte(fmt.Sprintf("%s  %s\n%s  manifest.json\n", chartDigest(chart), file, chartDigest(m)))
+	}
+
+	tests := []struct {
+		name    string
+		files   []bundleFile
+		err     string
+		corrupt bool
+	}{
+		{
+			name:  "valid",
+			files: []bundleFile{{file, chart}, {"manifest.json", good}, {"checksums.txt", sums(good)}},
+		},
+		{
+			name:    "chart doesn't match the manifest",
+			files:   []bundleFile{{file, []byte("tampered")}, {"manifest.json", good}},
+			corrupt: true,
+		},
+		{
+			name:    "chart doesn't match checksums.txt",
+			files:   []bundleFile{{file, chart}, {"manifest.json", manifest(nil)}, {"checksums.txt", []byte("0000  " + file + "\n")}},
+			corrupt: true,
+		},
+		{
+			name:  "file not in the manifest",
+			files: []bundleFile{{file, chart}, {"charts/extra-1.0.0.tgz", chart}, {"manifest.json", good}},
+			err:   "charts/extra-1.0.0.tgz is not listed in the manifest",
+		},
+		{
+			name:  "chart missing from the bundle",
+			files: []bundleFile{{"manifest.json", manifest(nil)}},
+			err:   file + " is listed in the manifest but missing from the bundle",
+		},
+		{
+			name:  "manifest doesn't match checksums.txt",
+			files: []bundleFile{{file, chart}, {"manifest.json", good}, {"checksums.txt", sums(manifest(nil))}},
+			err:   "checksum mismatch for manifest.json in checksums.txt",
+		},
+		{
+			name:  "invalid checksums.txt",
+			files: []bundleFile{{file, chart}, {"manifest.json", good}, {"checksums.txt", []byte("garbage\n")}},
+			err:   "invalid checksums.txt line",
+		},
+		{
+			name:  "bad signature",
+			files: []bundleFile{{file, chart}, {"manifest.json", good}, {"manifest.json.asc", []byte("not a signature")}},
+			err:   "manifest signature",
+		},
+		{
+			name:  "no manifest",
+			files: []bundleFile{{file, chart}},
+			err:   "bundle has no manifest.json",
+		},
+		{
+			name:  "unsupported version",
+			files: []bundleFile{{"manifest.json", []byte(`{"version": 2}`)}},
+			err:   "unsupported bundle version 2",
+		},
+	}
+	t.Setenv("GNUPGHOME", t.TempDir())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, digests, err := readBundleManifest(writeTestBundle(t, tt.files...), nil)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if m.corrupt[file] != tt.corrupt {
+				t.Errorf("corrupt = %v, want %v", m.corrupt[file], tt.corrupt)
+			}
+			if _, ok := digests[file]; !ok {
+				t.Errorf("no digest for %s", file)
+			}
+		})
+	}
+}
+
+func TestReadBundleManifestCorruptKind(t *testing.T) {
+	chart := []byte("chart web-1.0.0")
+	m := []byte(`{"version": 1}`)
+	path := writeTestBundle(t, bundleFile{"charts/web-1.0.0.tgz", chart}, bundleFile{"manifest.json", m},
+		bundleFile{"checksums.txt", []byte(chartDigest([]byte("other")) + "  manifest.json\n")})
+	if _, _, err := readBundleManifest(path, nil); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("tampered manifest = %v, want a corrupt error", err)
+	}
+}
+
+func TestReadBundleManifestSigned(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	t.Setenv("GNUPGHOME", t.TempDir())
+	const uid = "cm_sync test <test@example.com>"
+	if out, err := exec.Command("gpg", "--batch", "--passphrase", "", "--quick-gen-key", uid, "ed25519", "sign", "never").CombinedOutput(); err != nil {
+		t.Fatalf("error creating a gpg key: %v\n%s", err, out)
+	}
+	out, err := exec.Command("gpg", "--batch", "--with-colons", "--list-keys", uid).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fpr string
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Split(line, ":"); fields[0] == "fpr" && fpr == "" {
+			fpr = fields[9]
+		}
+	}
+
+	m := []byte(`{"version": 1}`)
+	sig, err := gpgSign(m, uid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, _, err := readBundleManifest(writeTestBundle(t, bundleFile{"manifest.json", m}, bundleFile{"manifest.json.asc", sig}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !manifest.signed || !trustedSigner(manifest.signers, []string{fpr}) {
+		t.Errorf("signers = %v, want %s", manifest.signers, fpr)
+	}
+	if trustedSigner(manifest.signers, []string{strings.Repeat("A", 40)}) {
+		t.Error("manifest is trusted for a different signer")
+	}
+
+	signed := writeTestBundle(t, bundleFile{"manifest.json", m}, bundleFile{"manifest.json.asc", sig})
+	unsigned := writeTestBundle(t, bundleFile{"manifest.json", m})
+	for _, tt := range []struct {
+		name    string
+		bundle  string
+		trusted []string
+		ok      bool
+	}{
+		{"signed by a trusted signer", signed, []string{fpr}, true},
+		{"signed by another signer", signed, []string{strings.Repeat("A", 40)}, false},
+		{"unsigned", unsigned, []string{fpr}, false},
+		{"no trusted signers", unsigned, nil, true},
+	} {
+		err := importBundle(context.Background(), tt.bundle, newMemRepo("destination"), nil, tt.trusted, newRunSummary())
+		if (err == nil) != tt.ok {
+			t.Errorf("import %s = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+
+	tampered := []byte(`{"version": 1, "source": "https://evil.example.com"}`)
+	if _, _, err := readBundleManifest(writeTestBundle(t, bundleFile{"manifest.json", tampered}, bundleFile{"manifest.json.asc", sig}), nil); err == nil || !strings.Contains(err.Error(), "manifest signature") {
+		t.Errorf("tampered signed manifest = %v, want a signature error", err)
+	}
+}
+
+func TestExportBundle(t *testing.T) {
+	ctx := context.Background()
+	src := newMemRepo("source")
+	src.add("web", "1.0.0", []byte("chart web-1.0.0"))
+	src.add("web", "1.1.0", []byte("chart web-1.1.0"))
+	src.add("api", "2.0.0", []byte("chart api-2.0.0"))
+
+	out := filepath.Join(t.TempDir(), "bundle.tar.zst")
+	if err := exportBundle(ctx, src, syncOptions{}, out, bundleOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	manifest, digests, err := readBundleManifest(out, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Charts) != 3 {
+		t.Errorf("bundle has %d charts, want 3", len(manifest.Charts))
+	}
+	for _, c := range manifest.Charts {
+		if digests[c.File] != c.Digest {
+			t.Errorf("%s has digest %s, manifest %s", c.File, digests[c.File], c.Digest)
+		}
+	}
+
+	src.fail = map[string]bool{"web-1.1.0": true}
+	out = filepath.Join(t.TempDir(), "bundle.tar.zst")
+	if err := exportBundle(ctx, src, syncOptions{}, out, bundleOptions{}); err == nil || !strings.Contains(err.Error(), "web-1.1.0") {
+		t.Errorf("export with a version that can't be fetched = %v, want an error naming it", err)
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("a bundle was written without all versions: %v", err)
+	}
+}
+
+func TestImportBundle(t *testing.T) {
+	ctx := context.Background()
+	src := newMemRepo("source")
+	src.add("web", "1.0.0", []byte("chart web-1.0.0"))
+	src.add("web", "1.1.0", []byte("chart web-1.1.0"))
+	bundle := filepath.Join(t.TempDir(), "bundle.tar.zst")
+	if err := exportBundle(ctx, src, syncOptions{}, bundle, bundleOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := newMemRepo("destination")
+	dst.add("web", "1.0.0", []byte("chart web-1.0.0"))
+	summary := newRunSummary()
+	if err := importBundle(ctx, bundle, dst, nil, nil, summary); err != nil {
+		t.Fatal(err)
+	}
+	if !dst.has("web", "1.1.0") || summary.synced != 1 || len(summary.failures) != 0 {
+		t.Errorf("import synced %d and failed %d, want web-1.1.0 synced", summary.synced, len(summary.failures))
+	}
+
+	dst = newMemRepo("destination")
+	dst.fail = map[string]bool{"web-1.1.0": true}
+	summary = newRunSummary()
+	if err := importBundle(ctx, bundle, dst, nil, nil, summary); err != nil {
+		t.Fatal(err)
+	}
+	if len(summary.failures) != 1 || summary.synced != 1 {
+		t.Fatalf("import synced %d and failed %d, want 1 and 1", summary.synced, len(summary.failures))
+	}
+	if code := ErrorCode(summary.failures[0].err); code != "E_DEST_FAILED" {
+		t.Errorf("failed push has code %s, want E_DEST_FAILED", code)
+	}
+
+	const file = "charts/web-1.0.0.tgz"
+	m, err := json.Marshal(bundleManifest{
+		Version: 1,
+		Charts:  []bundleChart{{Name: "web", Version: "1.0.0", File: file, Digest: chartDigest([]byte("chart web-1.0.0"))}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := writeTestBundle(t, bundleFile{file, []byte("tampered")}, bundleFile{"manifest.json", m})
+	dst = newMemRepo("destination")
+	if err := importBundle(ctx, tampered, dst, nil, nil, newRunSummary()); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("import of a tampered chart = %v, want a corrupt error", err)
+	}
+	if dst.has("web", "1.0.0") {
+		t.Error("tampered chart was uploaded")
+	}
+}
@@ -0,0 +1,192 @@
+package chartsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chartCache keeps downloaded charts in a directory by their sha256, like
+// DIR/sha256/ab/abcd....tgz, so a version synced to several destinations
+// or needed again by later runs is downloaded from the source once. Charts
+// are cached under the digest of the index, or of the layer of an oci
+// registry, only when they have one, and checked again when they are read.
+// A nil chartCache caches nothing.
+type chartCache struct {
+	dir     string
+	maxSize int64
+
+	mu      sync.Mutex
+	size    int64
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	size int64
+	used time.Time
+}
+
+// charts is the cache of -chart-cache.
+var charts *chartCache
+
+// setChartCache applies -chart-cache and -chart-cache-size, the least
+// recently used charts are removed beyond that size.
+func setChartCache(dir, maxSize string) error {
+	charts = nil
+	if dir == "" {
+		return nil
+	}
+	c := &chartCache{dir: dir, entries: make(map[string]cacheEntry)}
+	if maxSize != "" {
+		var err error
+		if c.maxSize, err = parseSize(maxSize); err != nil {
+			return err
+		}
+	}
+	root := filepath.Join(dir, "sha256")
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return err
+	}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		// Files of an interrupted put are left over.
+		if strings.HasPrefix(d.Name(), ".tmp-") {
+			return os.Remove(path)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		c.entries[path] = cacheEntry{info.Size(), info.ModTime()}
+		c.size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.evict()
+	charts = c
+	return nil
+}
+
+func cacheable(digest string) bool {
+	_, err := hex.DecodeString(digest)
+	return len(digest) == sha256.Size*2 && err == nil
+}
+
+func (c *chartCache) path(digest string) string {
+	return filepath.Join(c.dir, "sha256", digest[:2], digest+".tgz")
+}
+
+// get opens the cached chart with digest, nil when it isn't cached.
+func (c *chartCache) get(digest string) *spool {
+	if c == nil || !cacheable(digest) {
+		return nil
+	}
+	path := c.path(digest)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	info, err := f.Stat()
+	if err != nil || maxChartSize > 0 && info.Size() > maxChartSize {
+		f.Close()
+		return nil
+	}
+	sp := &spool{f: f, size: info.Size(), cached: true}
+	if d, err := sp.digest(); err != nil || d != digest {
+		// A damaged chart is removed and downloaded again.
+		f.Close()
+		c.remove(path)
+		return nil
+	}
+	now := time.Now()
+	os.Chtimes(path, now, now)
+	c.mu.Lock()
+	c.entries[path] = cacheEntry{info.Size(), now}
+	c.mu.Unlock()
+	return sp
+}
+
+// put caches the chart in sp under digest, when that is its digest.
+func (c *chartCache) put(digest string, sp *spool) error {
+	if c == nil || !cacheable(digest) || sp.cached {
+		return nil
+	}
+	path := c.path(digest)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), sp.reader())
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && hex.EncodeToString(h.Sum(nil)) != digest {
+		err = corruptf("digest %s, the index has %s", shortDigest(hex.EncodeToString(h.Sum(nil))), shortDigest(digest))
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// A put of the same chart may have raced this one.
+	if e, ok := c.entries[path]; ok {
+		c.size -= e.size
+	}
+	c.entries[path] = cacheEntry{n, time.Now()}
+	c.size += n
+	c.evict()
+	return nil
+}
+
+func (c *chartCache) remove(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	os.Remove(path)
+	if e, ok := c.entries[path]; ok {
+		c.size -= e.size
+		delete(c.entries, path)
+	}
+}
+
+// evict removes the least recently used charts until the cache fits in
+// maxSize, the caller holds mu.
+func (c *chartCache) evict() {
+	if c.maxSize <= 0 || c.size <= c.maxSize {
+		return
+	}
+	paths := make([]string, 0, len(c.entries))
+	for path := range c.entries {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool { return c.entries[paths[i]].used.Before(c.entries[paths[j]].used) })
+	for _, path := range paths {
+		if c.size <= c.maxSize {
+			break
+		}
+		os.Remove(path)
+		c.size -= c.entries[path].size
+		delete(c.entries, path)
+	}
+}
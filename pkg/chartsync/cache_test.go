@@ -0,0 +1,136 @@
+package chartsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+// testChartCache sets -chart-cache to a temporary directory for the test.
+func testChartCache(t *testing.T, maxSize string) *chartCache {
+	t.Helper()
+	if err := setChartCache(t.TempDir(), maxSize); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { charts = nil })
+	return charts
+}
+
+func cachePut(t *testing.T, c *chartCache, data []byte) string {
+	t.Helper()
+	sp, err := newSpool(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.close()
+	digest := chartDigest(data)
+	if err := c.put(digest, sp); err != nil {
+		t.Fatal(err)
+	}
+	return digest
+}
+
+func cacheGet(c *chartCache, digest string) ([]byte, bool) {
+	sp := c.get(digest)
+	if sp == nil {
+		return nil, false
+	}
+	defer sp.close()
+	data, err := sp.bytes()
+	return data, err == nil
+}
+
+func TestChartCacheVerify(t *testing.T) {
+	c := testChartCache(t, "")
+	data := []byte("chart web-1.0.0")
+	digest := cachePut(t, c, data)
+
+	got, ok := cacheGet(c, digest)
+	if !ok || !bytes.Equal(got, data) {
+		t.Fatalf("get = %q, %v, want the chart put", got, ok)
+	}
+	// Cached spools keep their file when closed.
+	if _, ok := cacheGet(c, digest); !ok {
+		t.Fatal("chart is gone after it was read")
+	}
+
+	if err := os.WriteFile(c.path(digest), []byte("damaged"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cacheGet(c, digest); ok {
+		t.Error("get returned a chart whose content doesn't match its digest")
+	}
+	if _, err := os.Stat(c.path(digest)); !os.IsNotExist(err) {
+		t.Errorf("damaged chart is still cached: %v", err)
+	}
+
+	sp, err := newSpool(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.close()
+	other := chartDigest([]byte("another chart"))
+	if err := c.put(other, sp); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("put under the wrong digest = %v, want a corrupt error", err)
+	}
+	if _, ok := cacheGet(c, other); ok {
+		t.Error("chart was cached under the wrong digest")
+	}
+
+	for _, digest := range []string{"", "sha256:abc", "zz" + digest[2:]} {
+		if err := c.put(digest, sp); err != nil {
+			t.Errorf("put(%q) = %v, want it ignored", digest, err)
+		}
+		if c.get(digest) != nil {
+			t.Errorf("get(%q) returned a chart", digest)
+		}
+	}
+}
+
+func TestChartCacheEviction(t *testing.T) {
+	chart := func(b byte) []byte { return bytes.Repeat([]byte{b}, 100) }
+	c := testChartCache(t, "250")
+	a := cachePut(t, c, chart('a'))
+	b := cachePut(t, c, chart('b'))
+	// a is used again, b becomes the least recently used.
+	if _, ok := cacheGet(c, a); !ok {
+		t.Fatal("a is not cached")
+	}
+	d := cachePut(t, c, chart('c'))
+
+	for _, tt := range []struct {
+		name   string
+		digest string
+		want   bool
+	}{{"a", a, true}, {"b", b, false}, {"c", d, true}} {
+		if _, ok := cacheGet(c, tt.digest); ok != tt.want {
+			t.Errorf("%s cached = %v, want %v", tt.name, ok, tt.want)
+		}
+	}
+	if c.size > c.maxSize {
+		t.Errorf("cache size %d is over %d", c.size, c.maxSize)
+	}
+
+	// Charts cached by an earlier run count towards the size.
+	if err := setChartCache(c.dir, "150"); err != nil {
+		t.Fatal(err)
+	}
+	if charts.size != 100 || len(charts.entries) != 1 {
+		t.Errorf("reopened cache has %d charts of %d bytes, want 1 of 100", len(charts.entries), charts.size)
+	}
+}
+
+func TestFetchSpoolCorrupt(t *testing.T) {
+	testChartCache(t, "")
+	src := newMemRepo("source")
+	src.add("web", "1.0.0", []byte("chart web-1.0.0"))
+	digest := chartDigest([]byte("the chart the index lists"))
+	if sp, err := fetchSpool(context.Background(), src, "web", "1.0.0", digest); !errors.Is(err, ErrCorrupt) {
+		if sp != nil {
+			sp.close()
+		}
+		t.Errorf("fetchSpool of a chart not matching its digest = %v, want a corrupt error", err)
+	}
+}
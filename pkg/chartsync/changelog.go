@@ -0,0 +1,250 @@
+package chartsync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// changelog writes what changed on each destination since the last run,
+// the versions of every destination are kept in changelog.json of
+// -index-cache for the next run to compare with.
+type changelog struct {
+	mu     sync.Mutex
+	path   string
+	out    string
+	format string
+	// versions are the versions of each destination at the end of the
+	// last run, by chart.
+	versions map[string]map[string][]string
+	// planned are the versions each destination had when this run planned
+	// its transfers, synced those it transferred.
+	planned map[string]map[string][]string
+	synced  map[string][]Version
+}
+
+// chartChange is how one chart changed on a destination, From and To are
+// its newest versions before and after.
+type chartChange struct {
+	Chart   string   `json:"chart"`
+	From    string   `json:"from,omitempty"`
+	To      string   `json:"to,omitempty"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+type destinationChanges struct {
+	Destination string        `json:"destination"`
+	Changes     []chartChange `json:"changes"`
+}
+
+type changelogJSON struct {
+	Time         time.Time            `json:"time"`
+	RequestID    string               `json:"request_id,omitempty"`
+	Destinations []destinationChanges `json:"destinations"`
+}
+
+func loadChangelog(dir, out, format string) (*changelog, error) {
+	c := &changelog{path: filepath.Join(dir, "changelog.json"), out: out, format: format, versions: make(map[string]map[string][]string)}
+	c.reset()
+	data, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c.versions); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", c.path, err)
+	}
+	return c, nil
+}
+
+func (c *changelog) reset() {
+	c.planned = make(map[string]map[string][]string)
+	c.synced = make(map[string][]Version)
+}
+
+// plan records the versions the destinations of jobs have, the first plan
+// of a run counts for destinations planned in several name windows.
+func (c *changelog) plan(jobs []plannedJob) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, job := range jobs {
+		destination := fmt.Sprint(job.destination)
+		if _, ok := c.planned[destination]; ok {
+			continue
+		}
+		versions := make(map[string][]string)
+		for chart, list := range job.plan.destData {
+			for _, v := range list {
+				versions[chart] = append(versions[chart], v.Version)
+			}
+		}
+		c.planned[destination] = versions
+	}
+}
+
+// reporter collects the versions synced to destination.
+func (c *changelog) reporter(destination string) Reporter {
+	return changelogReporter{c, destination}
+}
+
+type changelogReporter struct {
+	log         *changelog
+	destination string
+}
+
+func (r changelogReporter) Synced(v Version, _ int64) {
+	r.log.mu.Lock()
+	defer r.log.mu.Unlock()
+	r.log.synced[r.destination] = append(r.log.synced[r.destination], v)
+}
+
+func (r changelogReporter) Skipped(Version) {}
+
+func (r changelogReporter) Failed(Version, error) {}
+
+// write writes the changes of the run of summary to the changelog file
+// and keeps the versions of the destinations for the next run.
+func (c *changelog) write(summary *runSummary) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	defer c.reset()
+	out := changelogJSON{Time: summary.start.UTC(), RequestID: summary.requestID, Destinations: []destinationChanges{}}
+	destinations := make([]string, 0, len(c.planned))
+	for d := range c.planned {
+		destinations = append(destinations, d)
+	}
+	sort.Strings(destinations)
+	for _, d := range destinations {
+		after := make(map[string][]string)
+		for chart, versions := range c.planned[d] {
+			after[chart] = append([]string(nil), versions...)
+		}
+		for _, v := range c.synced[d] {
+			if !slices.Contains(after[v.Chart], v.Version) {
+				after[v.Chart] = append(after[v.Chart], v.Version)
+			}
+		}
+		for _, versions := range after {
+			sort.Slice(versions, func(i, j int) bool { return newerVersion(versions[i], versions[j]) })
+		}
+		// The first run has nothing to compare with but what the
+		// destination had before it.
+		before, ok := c.versions[d]
+		if !ok {
+			before = c.planned[d]
+		}
+		out.Destinations = append(out.Destinations, destinationChanges{redact(d), chartChanges(before, after)})
+		c.versions[d] = after
+	}
+
+	data, err := json.MarshalIndent(c.versions, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(c.path, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	f, err := os.Create(c.out)
+	if err != nil {
+		return err
+	}
+	if c.format == "json" {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(out)
+	} else {
+		err = printChangelog(f, out)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// chartChanges compares the versions of each chart before and after,
+// charts that didn't change are left out.
+func chartChanges(before, after map[string][]string) []chartChange {
+	charts := make(map[string]bool)
+	for chart := range before {
+		charts[chart] = true
+	}
+	for chart := range after {
+		charts[chart] = true
+	}
+	changes := []chartChange{}
+	for chart := range charts {
+		c := chartChange{Chart: chart, From: newestOf(before[chart]), To: newestOf(after[chart])}
+		for _, v := range after[chart] {
+			if !slices.Contains(before[chart], v) {
+				c.Added = append(c.Added, v)
+			}
+		}
+		for _, v := range before[chart] {
+			if !slices.Contains(after[chart], v) {
+				c.Removed = append(c.Removed, v)
+			}
+		}
+		if len(c.Added) == 0 && len(c.Removed) == 0 {
+			continue
+		}
+		sort.Slice(c.Added, func(i, j int) bool { return newerVersion(c.Added[j], c.Added[i]) })
+		sort.Slice(c.Removed, func(i, j int) bool { return newerVersion(c.Removed[j], c.Removed[i]) })
+		changes = append(changes, c)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Chart < changes[j].Chart })
+	return changes
+}
+
+func newestOf(versions []string) string {
+	newest := ""
+	for _, v := range versions {
+		if newest == "" || newerVersion(v, newest) {
+			newest = v
+		}
+	}
+	return newest
+}
+
+// printChangelog writes the changes as markdown, a line like
+// "nginx: 1.2.3 → 1.2.5 added" per chart.
+func printChangelog(w io.Writer, log changelogJSON) error {
+	fmt.Fprintf(w, "## Chart changes %s\n", log.Time.Format("2006-01-02 15:04 MST"))
+	for _, d := range log.Destinations {
+		fmt.Fprintf(w, "\n### %s\n\n", d.Destination)
+		if len(d.Changes) == 0 {
+			fmt.Fprintln(w, "No changes.")
+			continue
+		}
+		for _, c := range d.Changes {
+			var parts []string
+			switch {
+			case len(c.Added) == 1 && c.From != "" && c.To == c.Added[0] && !slices.Contains(c.Removed, c.From):
+				parts = append(parts, c.From+" → "+c.To+" added")
+			case len(c.Added) > 0 && c.From == "":
+				parts = append(parts, strings.Join(c.Added, ", ")+" added (new chart)")
+			case len(c.Added) > 0:
+				parts = append(parts, strings.Join(c.Added, ", ")+" added")
+			}
+			if len(c.Removed) > 0 {
+				parts = append(parts, strings.Join(c.Removed, ", ")+" removed")
+			}
+			fmt.Fprintf(w, "- **%s**: %s\n", c.Chart, strings.Join(parts, "; "))
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
@@ -0,0 +1,191 @@
+package chartsync
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ChartVersion is a chart version as listed in a helm index, the chart's
+// Chart.yaml metadata plus where and when it was published.
+type ChartVersion struct {
+	Name         string            `json:"name" yaml:"name"`
+	Version      string            `json:"version" yaml:"version"`
+	APIVersion   string            `json:"apiVersion,omitempty" yaml:"apiVersion,omitempty"`
+	AppVersion   string            `json:"appVersion,omitempty" yaml:"appVersion,omitempty"`
+	KubeVersion  string            `json:"kubeVersion,omitempty" yaml:"kubeVersion,omitempty"`
+	Description  string            `json:"description,omitempty" yaml:"description,omitempty"`
+	Type         string            `json:"type,omitempty" yaml:"type,omitempty"`
+	Home         string            `json:"home,omitempty" yaml:"home,omitempty"`
+	Icon         string            `json:"icon,omitempty" yaml:"icon,omitempty"`
+	Keywords     []string          `json:"keywords,omitempty" yaml:"keywords,omitempty"`
+	Sources      []string          `json:"sources,omitempty" yaml:"sources,omitempty"`
+	Maintainers  []Maintainer      `json:"maintainers,omitempty" yaml:"maintainers,omitempty"`
+	Annotations  map[string]string `json:"annotations,omitempty" yaml:"annotations,omitempty"`
+	Dependencies []ChartDependency `json:"dependencies,omitempty" yaml:"dependencies,omitempty"`
+	Deprecated   bool              `json:"deprecated,omitempty" yaml:"deprecated,omitempty"`
+	Digest       string            `json:"digest,omitempty" yaml:"digest,omitempty"`
+	Created      time.Time         `json:"created,omitempty" yaml:"created,omitempty"`
+	URLs         []string          `json:"urls,omitempty" yaml:"urls,omitempty"`
+}
+
+type Maintainer struct {
+	Name  string `json:"name,omitempty" yaml:"name,omitempty"`
+	Email string `json:"email,omitempty" yaml:"email,omitempty"`
+	URL   string `json:"url,omitempty" yaml:"url,omitempty"`
+}
+
+type ChartDependency struct {
+	Name       string   `json:"name" yaml:"name"`
+	Version    string   `json:"version,omitempty" yaml:"version,omitempty"`
+	Repository string   `json:"repository,omitempty" yaml:"repository,omitempty"`
+	Condition  string   `json:"condition,omitempty" yaml:"condition,omitempty"`
+	Tags       []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Alias      string   `json:"alias,omitempty" yaml:"alias,omitempty"`
+}
+
+// chartFiles returns the named files from the top directory of a packaged
+// chart, missing files are left out.
+func chartFiles(r io.Reader, names ...string) (map[string][]byte, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		parts := strings.Split(hdr.Name, "/")
+		if len(parts) != 2 {
+			continue
+		}
+		for _, name := range names {
+			if parts[1] == name {
+				if files[name], err = io.ReadAll(tr); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+}
+
+func chartDigest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// chartMetadata builds the index entry of a packaged chart from its
+// Chart.yaml, the urls are left to the caller.
+func chartMetadata(data []byte) (ChartVersion, error) {
+	return readChartMetadata(bytes.NewReader(data))
+}
+
+// readChartMetadata is chartMetadata for a chart read from r, r is read to
+// the end for the digest.
+func readChartMetadata(r io.Reader) (ChartVersion, error) {
+	h := sha256.New()
+	tee := io.TeeReader(r, h)
+	files, err := chartFiles(tee, "Chart.yaml")
+	if err != nil {
+		return ChartVersion{}, err
+	}
+	if _, err := io.Copy(io.Discard, tee); err != nil {
+		return ChartVersion{}, err
+	}
+	if files["Chart.yaml"] == nil {
+		return ChartVersion{}, fmt.Errorf("no Chart.yaml in chart archive")
+	}
+	var cv ChartVersion
+	if err := yaml.Unmarshal(files["Chart.yaml"], &cv); err != nil {
+		return ChartVersion{}, fmt.Errorf("parsing Chart.yaml: %w", err)
+	}
+	if cv.Name == "" || cv.Version == "" {
+		return ChartVersion{}, fmt.Errorf("Chart.yaml has no name or version")
+	}
+	if cv.APIVersion == "" {
+		cv.APIVersion = "v1"
+	}
+	cv.URLs = nil
+	cv.Digest = hex.EncodeToString(h.Sum(nil))
+	cv.Created = time.Now().UTC()
+	return cv, nil
+}
+
+// decodeChartData decodes a /api/charts list one version at a time, so
+// large lists aren't held as a document and as decoded charts at once.
+// Charts outside w are skipped.
+func decodeChartData(r io.Reader, w nameWindow) (ChartData, error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+	data := make(ChartData)
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		name, _ := t.(string)
+		if !w.contains(name) {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if t, err = dec.Token(); err != nil {
+			return nil, err
+		}
+		if t == nil {
+			data[name] = nil
+			continue
+		}
+		if t != json.Delim('[') {
+			return nil, fmt.Errorf("versions of %s aren't a list", name)
+		}
+		var versions []ChartVersion
+		for dec.More() {
+			var v ChartVersion
+			if err := dec.Decode(&v); err != nil {
+				return nil, fmt.Errorf("versions of %s: %w", name, err)
+			}
+			versions = append(versions, v)
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return nil, err
+		}
+		data[name] = versions
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != delim {
+		return fmt.Errorf("invalid chart list: expected %v, got %v", delim, t)
+	}
+	return nil
+}
@@ -0,0 +1,312 @@
+package chartsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Auth are the credentials a Client sends, basic auth, an artifactory api
+// key or a bearer token, and any extra headers.
+type Auth struct {
+	// Type sigv4 signs requests with the AWS credentials instead.
+	Type     string
+	Username string
+	Password string
+	APIKey   string
+	Token    string
+	// Headers are sent with every request to the server.
+	Headers map[string]string
+	// CertFile and KeyFile are a client certificate for mTLS, CAFile the
+	// CAs the server certificate is checked against.
+	CertFile string
+	KeyFile  string
+	CAFile   string
+	// AWSProfile and GoogleCredentials replace the default cloud
+	// credentials of buckets and registries.
+	AWSProfile        string
+	GoogleCredentials string
+	// AWSRegion and AWSService are what sigv4 requests are signed for.
+	AWSRegion  string
+	AWSService string
+	// OIDCTokenURL is the token endpoint bearer tokens are requested from
+	// with the client id and secret, refreshed as they expire.
+	OIDCTokenURL     string
+	OIDCClientID     string
+	OIDCClientSecret string
+	OIDCScopes       []string
+}
+
+// ClientOptions configure how a Client reaches its repository.
+type ClientOptions struct {
+	// Type is the server type of http repositories, chartmuseum, harbor,
+	// artifactory, nexus, gitlab or static, detected if empty.
+	Type string
+	// Tenant is the org/repo path on multitenant servers, or the path
+	// below an oci namespace or bucket prefix.
+	Tenant    string
+	Auth      Auth
+	PlainHTTP bool
+	// IndexURL is the url a file or bucket repository is served from.
+	IndexURL string
+	// HTTPClient sends the requests of the client, including the aws,
+	// azure and google token requests, for custom auth, recording or test
+	// doubles. The request and bandwidth limits still apply on top of its
+	// transport, the default one is used when it has none.
+	HTTPClient *http.Client
+}
+
+// Client is a chart repository, any url cm_sync can sync from or to.
+type Client struct {
+	cfg    *config
+	tenant string
+
+	// Set for clients of a Repository rather than a url.
+	builtin *builtinRepository
+	plugged *pluggedRepository
+}
+
+// NewClient returns the client of a repository url, nothing is requested
+// until it is used.
+func NewClient(ref string, opts ClientOptions) *Client {
+	auth := credentials(opts.Auth)
+	addSecrets(auth)
+	return &Client{
+		cfg: &config{
+			Source:          ref,
+			Destination:     ref,
+			SourceType:      opts.Type,
+			DestinationType: opts.Type,
+			PlainHTTP:       opts.PlainHTTP,
+			IndexURL:        opts.IndexURL,
+			syncOptions:     syncOptions{SourceAuth: auth, DestinationAuth: auth},
+			client:          limitClient(opts.HTTPClient),
+		},
+		tenant: normalizeTenant(opts.Tenant),
+	}
+}
+
+func (c *Client) String() string {
+	return c.cfg.Source
+}
+
+// detect asks the server for its type once, for both directions.
+func (c *Client) detect(ctx context.Context) {
+	if c.cfg.SourceType == "" && isHTTP(c.cfg.Source) {
+		c.cfg.SourceType = detectServerType(ctx, c.cfg.Source, c.cfg.SourceAuth, c.cfg.client)
+		c.cfg.DestinationType = c.cfg.SourceType
+	}
+}
+
+func (c *Client) source(ctx context.Context, opts Options) (chartSource, error) {
+	switch {
+	case c.builtin != nil:
+		return c.builtin.src, nil
+	case c.plugged != nil:
+		return c.plugged, nil
+	}
+	c.detect(ctx)
+	o := opts.syncOptions()
+	o.SourceAuth = c.cfg.SourceAuth
+	return c.cfg.newSource(c.tenant, o)
+}
+
+func (c *Client) destination(ctx context.Context, opts Options) (chartDestination, error) {
+	switch {
+	case c.builtin != nil && c.builtin.dst == nil:
+		return nil, fmt.Errorf("%s can only be a source", c)
+	case c.builtin != nil:
+		return c.builtin.dst, nil
+	case c.plugged != nil:
+		return c.plugged, nil
+	}
+	c.detect(ctx)
+	o := opts.syncOptions()
+	o.DestinationAuth = c.cfg.DestinationAuth
+	return c.cfg.newDestination(c.tenant, o)
+}
+
+// Ping checks that the repository can be reached.
+func (c *Client) Ping(ctx context.Context) error {
+	src, err := c.source(ctx, Options{})
+	if err != nil {
+		return err
+	}
+	return src.ping(ctx)
+}
+
+// Charts lists every chart version of the repository.
+func (c *Client) Charts(ctx context.Context) (ChartData, error) {
+	src, err := c.source(ctx, Options{})
+	if err != nil {
+		return nil, err
+	}
+	return src.listCharts(ctx)
+}
+
+// Options are the settings of a diff or sync.
+type Options struct {
+	// Include and Exclude are chart name globs, all charts are included
+	// when Include is empty.
+	Include []string
+	Exclude []string
+	// Retention only includes the newest N versions of each chart.
+	Retention int
+	// RequireAnnotations only includes the versions with these chart
+	// annotations, a key with an empty value only has to be set.
+	RequireAnnotations map[string]string
+	// Shard like 2/8 only includes the charts whose name hashes into the
+	// second of eight shards.
+	Shard string
+	// Concurrency is the number of charts transferred at once.
+	Concurrency int
+	// MaxConcurrency above Concurrency grows the charts transferred at
+	// once up to it while the destination keeps up, and halves them when
+	// it answers 429 or 5xx or slows down.
+	MaxConcurrency int
+	// Force transfers versions again whose digests differ.
+	Force bool
+	// Deps also syncs the dependencies of each chart.
+	Deps bool
+	// ChartTimeout gives up on single charts taking longer.
+	ChartTimeout time.Duration
+}
+
+// validate checks the Shard, a diff or sync with an invalid one fails
+// before anything is requested.
+func (o Options) validate() error {
+	_, _, err := parseShard(o.Shard)
+	return err
+}
+
+func (o Options) syncOptions() syncOptions {
+	return syncOptions{
+		Deps:               o.Deps,
+		Force:              o.Force,
+		Include:            o.Include,
+		Exclude:            o.Exclude,
+		Retention:          o.Retention,
+		RequireAnnotations: o.RequireAnnotations,
+		Shard:              o.Shard,
+		Concurrency:        o.Concurrency,
+		MaxConcurrency:     o.MaxConcurrency,
+		ChartTimeout:       o.ChartTimeout,
+	}
+}
+
+// Version is one version of a chart.
+type Version struct {
+	Chart   string
+	Version string
+}
+
+// Diff is how a destination differs from a source.
+type Diff struct {
+	// Missing are the versions a sync would transfer.
+	Missing []Version
+	// Extra are the versions only the destination has.
+	Extra []Version
+	// Changed are the versions both have with different digests.
+	Changed []Version
+}
+
+// Differ compares repositories with the include, exclude and retention
+// filters of Options applied to the source.
+type Differ struct {
+	Options Options
+}
+
+func (d Differ) Diff(ctx context.Context, src, dst *Client) (*Diff, error) {
+	if err := d.Options.validate(); err != nil {
+		return nil, err
+	}
+	sourceData, err := src.Charts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	destData, err := dst.Charts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	diff := diffCharts(sourceData, destData, d.Options.syncOptions())
+	return &diff, nil
+}
+
+// Progress is told when a Syncer starts and finishes each chart version,
+// size is -1 when it isn't known up front.
+type Progress interface {
+	Start(v Version, size int64)
+	Finish(v Version)
+}
+
+// Reporter is told the outcome of each chart version a Syncer handles.
+type Reporter interface {
+	Synced(v Version, size int64)
+	Skipped(v Version)
+	Failed(v Version, err error)
+}
+
+// Summary is what a sync did.
+type Summary struct {
+	Examined int
+	Synced   int
+	Skipped  int
+	Bytes    int64
+	Elapsed  time.Duration
+	// Errors are the versions that failed, in the order they did.
+	Errors []*Error
+}
+
+// Err joins the errors of the failed versions, nil when none failed.
+func (s *Summary) Err() error {
+	errs := make([]error, len(s.Errors))
+	for i, e := range s.Errors {
+		errs[i] = e
+	}
+	return errors.Join(errs...)
+}
+
+// Syncer transfers the chart versions a destination is missing. Progress
+// and Reporter are optional, without a Progress nothing is drawn.
+type Syncer struct {
+	Options  Options
+	Progress Progress
+	Reporter Reporter
+}
+
+type noProgress struct{}
+
+func (noProgress) Start(Version, int64) {}
+func (noProgress) Finish(Version)       {}
+
+// Sync diffs src against dst and transfers what is missing. Charts that
+// fail are reported in the summary, the error is for repositories that
+// couldn't be listed.
+func (s *Syncer) Sync(ctx context.Context, src, dst *Client) (*Summary, error) {
+	if err := s.Options.validate(); err != nil {
+		return nil, err
+	}
+	source, err := src.source(ctx, s.Options)
+	if err != nil {
+		return nil, err
+	}
+	dest, err := dst.destination(ctx, s.Options)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := planSync(ctx, source, dest, s.Options.syncOptions())
+	if err != nil {
+		return nil, err
+	}
+	plan.progress = s.Progress
+	if plan.progress == nil {
+		plan.progress = noProgress{}
+	}
+	summary := newRunSummary()
+	summary.reporter = s.Reporter
+	summary.examine(len(plan.sourceData))
+	plan.run(ctx, summary)
+	return summary.result(), nil
+}
@@ -0,0 +1,58 @@
+package chartsync
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// countingTransport counts the requests it passes on to http.DefaultTransport.
+type countingTransport struct {
+	n atomic.Int32
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.n.Add(1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestClientHTTPClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"web":[{"name":"web","version":"1.0.0"}]}`))
+	}))
+	defer srv.Close()
+
+	var a, b countingTransport
+	clientA := NewClient(srv.URL, ClientOptions{Type: "chartmuseum", HTTPClient: &http.Client{Transport: &a}})
+	clientB := NewClient(srv.URL, ClientOptions{Type: "chartmuseum", HTTPClient: &http.Client{Transport: &b}})
+	for _, c := range []*Client{clientA, clientA, clientB} {
+		data, err := c.Charts(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(data["web"]) != 1 {
+			t.Fatalf("Charts = %v, want web-1.0.0", data)
+		}
+	}
+	if a.n.Load() != 2 || b.n.Load() != 1 {
+		t.Errorf("requests through the clients = %d and %d, want 2 and 1", a.n.Load(), b.n.Load())
+	}
+}
+
+func TestInvalidShard(t *testing.T) {
+	var requests countingTransport
+	c := NewClient("https://charts.example.com", ClientOptions{Type: "chartmuseum", HTTPClient: &http.Client{Transport: &requests}})
+	opts := Options{Shard: "3/2"}
+	if _, err := (Differ{Options: opts}).Diff(context.Background(), c, c); err == nil {
+		t.Error("Diff with shard 3/2 succeeded")
+	}
+	if _, err := (&Syncer{Options: opts}).Sync(context.Background(), c, c); err == nil {
+		t.Error("Sync with shard 3/2 succeeded")
+	}
+	if n := requests.n.Load(); n != 0 {
+		t.Errorf("%d requests were sent", n)
+	}
+}
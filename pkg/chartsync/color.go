@@ -0,0 +1,105 @@
+package chartsync
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+// ANSI colors of the statuses in log lines and the summary.
+const (
+	green  = "\x1b[32m"
+	yellow = "\x1b[33m"
+	red    = "\x1b[31m"
+	reset  = "\x1b[0m"
+)
+
+// stdoutColor and stderrColor tell if the output on stdout and stderr is
+// colored, by -color. auto colors terminals unless NO_COLOR is set, see
+// https://no-color.org.
+var (
+	stdoutColor, _ = useColor("auto", os.Stdout)
+	stderrColor, _ = useColor("auto", os.Stderr)
+)
+
+// statusColors are the colors of log lines by how they start.
+var statusColors = []struct{ prefix, color string }{
+	{"Error", red},
+	{"Failed", red},
+	{"Checksum mismatch", red},
+	{"Denied", red},
+	{"Would deny", red},
+	{"Skipping", yellow},
+	{"Quarantined", yellow},
+	{"Holding back", yellow},
+	{"Would hold", yellow},
+	{"Waiting", yellow},
+	{"Uploaded", green},
+	{"Successfully synced", green},
+	{"Copied", green},
+	{"Promoted", green},
+	{"Would sync", green},
+}
+
+// useColor tells if -color mode colors the output on f: auto, always or
+// never.
+func useColor(mode string, f *os.File) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb" && term.IsTerminal(int(f.Fd())), nil
+	}
+	return false, fmt.Errorf("-color is auto, always or never, not %q", mode)
+}
+
+// setColor applies -color, exiting on an unknown mode.
+func setColor(mode string) {
+	var err error
+	if stdoutColor, err = useColor(mode, os.Stdout); err != nil {
+		logln("Error:", err)
+		os.Exit(1)
+	}
+	stderrColor, _ = useColor(mode, os.Stderr)
+}
+
+// paint colors s for stdout, keeping a trailing newline outside the color.
+func paint(color, s string) string {
+	if !stdoutColor {
+		return s
+	}
+	return wrapColor(color, s)
+}
+
+func wrapColor(color, s string) string {
+	trimmed := strings.TrimRight(s, "\n")
+	if trimmed == "" {
+		return s
+	}
+	return color + trimmed + reset + s[len(trimmed):]
+}
+
+// paintCount colors a count of the summary, when there is any.
+func paintCount(color string, n int) string {
+	if n == 0 {
+		return fmt.Sprint(n)
+	}
+	return paint(color, fmt.Sprint(n))
+}
+
+// paintStatus colors a line printed on f by the status it starts with.
+func paintStatus(f *os.File, s string) string {
+	if f == os.Stderr && !stderrColor || f != os.Stderr && !stdoutColor {
+		return s
+	}
+	for _, c := range statusColors {
+		if strings.HasPrefix(s, c.prefix) {
+			return wrapColor(c.color, s)
+		}
+	}
+	return s
+}
@@ -0,0 +1,198 @@
+package chartsync
+
+import (
+	"context"
+	"flag"
+	"os"
+)
+
+// commandFlags are the flags shared by the subcommands: -config, the source
+// flags of the commands reading a source, like list, search, describe and
+// stats, and the destination flags of those changing a destination.
+type commandFlags struct {
+	configFile *string
+	awsRegion  *string
+	awsService *string
+	color      *string
+
+	source     *string
+	sourceType *string
+	sourceAuth *authFlags
+	include    *string
+	exclude    *string
+	retention  *int
+	annotated  *string
+	shard      *string
+	plainHTTP  *bool
+	sourceRPS  *float64
+	sourceIdx  *string
+
+	destination *string
+	destType    *string
+	destAuth    *authFlags
+	destRPS     *float64
+	destIdx     *string
+}
+
+func addSourceFlags(fs *flag.FlagSet) *commandFlags {
+	return newCommandFlags(fs, true, false)
+}
+
+func addDestinationFlags(fs *flag.FlagSet) *commandFlags {
+	return newCommandFlags(fs, false, true)
+}
+
+func newCommandFlags(fs *flag.FlagSet, source, destination bool) *commandFlags {
+	f := &commandFlags{configFile: fs.String("config", "", "yaml config file with the source and destination settings")}
+	f.awsRegion = fs.String("aws-region", "", "aws region sigv4 requests are signed for, from API Gateway hosts or the aws config if empty")
+	f.awsService = fs.String("aws-service", "execute-api", "aws service sigv4 requests are signed for")
+	f.color = fs.String("color", "auto", "color the statuses of log lines: auto when stdout is a terminal and NO_COLOR is unset, always or never")
+	if source {
+		f.source = fs.String("s", "http://localhost:8080", "source, any url cm_sync can sync from")
+		f.sourceType = fs.String("source-type", "", "source server type, detected if empty")
+		f.sourceAuth = addAuthFlags(fs, "source", "source")
+		f.include = fs.String("include", "", "comma separated chart name globs to include, all charts if empty")
+		f.exclude = fs.String("exclude", "", "comma separated chart name globs to skip")
+		f.retention = fs.Int("retention", 0, "only include the newest N versions of each chart, 0 includes all")
+		f.annotated = fs.String("require-annotations", "", "comma separated chart annotations, e.g. quality-gate=passed, a version needs to be included")
+		f.shard = fs.String("shard", "", "only include the charts whose name hashes into this shard, e.g. 2/8")
+		f.plainHTTP = fs.Bool("plain-http", false, "use http instead of https for oci registries")
+		f.sourceRPS = fs.Float64("source-rps", 0, "max requests per second sent to the source server, unlimited if 0")
+		f.sourceIdx = fs.String("source-index", "", "saved chart list (/api/charts json, index.yaml or list -o json) used instead of the source")
+	}
+	if destination {
+		f.destination = fs.String("d", "http://localhost:8080", "destination, any url cm_sync can sync to")
+		f.destType = fs.String("dest-type", "", "destination server type, detected if empty")
+		f.destAuth = addAuthFlags(fs, "dest", "destination")
+		f.destRPS = fs.Float64("dest-rps", 0, "max requests per second sent to the destination server, unlimited if 0")
+		f.destIdx = fs.String("dest-index", "", "saved chart list (/api/charts json, index.yaml or list -o json) used instead of the destination")
+	}
+	return f
+}
+
+// config loads -config and applies the flags set on fs over it, exiting
+// on errors.
+func (f *commandFlags) config(fs *flag.FlagSet) *config {
+	setColor(*f.color)
+	cfg := &config{}
+	if *f.configFile != "" {
+		var err error
+		if cfg, err = loadConfig(*f.configFile); err != nil {
+			logln("Error loading config:", *f.configFile, "\n", err)
+			os.Exit(1)
+		}
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	applyAWSFlags(cfg, set, *f.awsRegion, *f.awsService)
+	if f.source != nil {
+		if set["s"] || cfg.Source == "" {
+			cfg.Source = *f.source
+		}
+		if set["source-type"] {
+			cfg.SourceType = *f.sourceType
+		}
+		f.sourceAuth.apply(&cfg.SourceAuth, set)
+		if set["include"] {
+			cfg.Include = splitList(*f.include)
+		}
+		if set["exclude"] {
+			cfg.Exclude = splitList(*f.exclude)
+		}
+		if set["retention"] {
+			cfg.Retention = *f.retention
+		}
+		if set["require-annotations"] {
+			required, err := parseAnnotations(*f.annotated)
+			if err != nil {
+				logln("Error parsing -require-annotations:", err)
+				os.Exit(1)
+			}
+			cfg.RequireAnnotations = required
+		}
+		if set["shard"] {
+			cfg.Shard = *f.shard
+		}
+		if _, _, err := parseShard(cfg.Shard); err != nil {
+			logln("Error parsing -shard:", err)
+			os.Exit(1)
+		}
+		if set["source-rps"] {
+			cfg.SourceRPS = *f.sourceRPS
+		}
+		if set["source-index"] {
+			cfg.SourceIndex = *f.sourceIdx
+		}
+		for _, source := range splitList(cfg.Source) {
+			limitRequests(cfg.SourceRPS, source)
+		}
+		cfg.PlainHTTP = cfg.PlainHTTP || *f.plainHTTP
+		if err := setChartCache(cfg.ChartCache, cfg.ChartCacheSize); err != nil {
+			logln("Error opening chart_cache:", err)
+			os.Exit(1)
+		}
+	}
+	if f.destination != nil {
+		if set["d"] || cfg.Destination == "" {
+			cfg.Destination = *f.destination
+		}
+		if set["dest-type"] {
+			cfg.DestinationType = *f.destType
+		}
+		f.destAuth.apply(&cfg.DestinationAuth, set)
+		if set["dest-rps"] {
+			cfg.DestinationRPS = *f.destRPS
+		}
+		if set["dest-index"] {
+			cfg.DestinationIndex = *f.destIdx
+		}
+		limitRequests(cfg.DestinationRPS, cfg.Destination)
+	}
+	cfg.registerSecrets()
+	return cfg
+}
+
+// openSource connects to the source of cfg and fetches its charts with
+// the include, exclude and retention filters applied, exiting on errors.
+// The source type is detected on first use.
+func openSource(ctx context.Context, cfg *config, tenant string) (chartSource, ChartData) {
+	if cfg.SourceType == "" && cfg.SourceIndex == "" && isHTTP(cfg.Source) {
+		cfg.SourceType = detectServerType(ctx, cfg.Source, cfg.SourceAuth, cfg.client)
+	}
+	src, err := cfg.newSource(tenant, cfg.syncOptions)
+	if err == nil {
+		err = src.ping(ctx)
+	}
+	if err != nil {
+		logln("Error checking source:", cfg.Source, "\n", withCode(sourceError(err)))
+		os.Exit(1)
+	}
+	data, err := src.listCharts(ctx)
+	if err != nil {
+		logln("Error fetching charts of", src, "\n", withCode(sourceError(err)))
+		os.Exit(1)
+	}
+	return src, applyPolicy(data, cfg.syncOptions)
+}
+
+// openDestination connects to the destination of cfg and fetches its
+// charts, exiting on errors.
+func openDestination(ctx context.Context, cfg *config, tenant string) (chartDestination, ChartData) {
+	if cfg.DestinationType == "" && cfg.DestinationIndex == "" && isHTTP(cfg.Destination) {
+		cfg.DestinationType = detectServerType(ctx, cfg.Destination, cfg.DestinationAuth, cfg.client)
+	}
+	dst, err := cfg.newDestination(tenant, cfg.syncOptions)
+	if err == nil {
+		err = dst.ping(ctx)
+	}
+	if err != nil {
+		logln("Error checking destination:", cfg.Destination, "\n", withCode(destError(err)))
+		os.Exit(1)
+	}
+	data, err := dst.listCharts(ctx)
+	if err != nil {
+		logln("Error fetching charts of", dst, "\n", withCode(destError(err)))
+		os.Exit(1)
+	}
+	return dst, data
+}
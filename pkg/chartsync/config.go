@@ -0,0 +1,680 @@
+package chartsync
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// credentials are the auth settings of one side, the source and the
+// destination each have their own. Token is the SAS token of azblob://
+// buckets.
+type credentials struct {
+	// Type sigv4 signs every request with the AWS credentials instead.
+	Type     string `yaml:"type"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	APIKey   string `yaml:"api_key"`
+	Token    string `yaml:"token"`
+	// Headers are sent with every request to the server, for gateways
+	// routing on them.
+	Headers map[string]string `yaml:"headers"`
+	// CertFile and KeyFile are a client certificate for mTLS, CAFile the
+	// CAs the server certificate is checked against.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	CAFile   string `yaml:"ca_file"`
+	// AWSProfile is the shared config profile used for s3://, ECR and sigv4,
+	// GoogleCredentials a credentials json file for gs:// and GCP
+	// registries, both use the default credentials when empty.
+	AWSProfile        string `yaml:"aws_profile"`
+	GoogleCredentials string `yaml:"google_credentials"`
+	// AWSRegion and AWSService (execute-api by default) are what sigv4
+	// requests are signed for, the region is taken from API Gateway hosts.
+	AWSRegion  string `yaml:"aws_region"`
+	AWSService string `yaml:"aws_service"`
+	// OIDCTokenURL is the token endpoint bearer tokens are requested
+	// from with the client credentials grant, and again once they expire.
+	OIDCTokenURL     string   `yaml:"oidc_token_url"`
+	OIDCClientID     string   `yaml:"oidc_client_id"`
+	OIDCClientSecret string   `yaml:"oidc_client_secret"`
+	OIDCScopes       []string `yaml:"oidc_scopes"`
+}
+
+// addHeaders sets the headers h over those of the config.
+func (c *credentials) addHeaders(h headerFlag) {
+	if len(h) == 0 {
+		return
+	}
+	headers := make(map[string]string, len(c.Headers)+len(h))
+	for k, v := range c.Headers {
+		headers[k] = v
+	}
+	for k, v := range h {
+		headers[k] = v
+	}
+	c.Headers = headers
+}
+
+// headerFlag collects repeated "Name: value" flags.
+type headerFlag map[string]string
+
+func (h headerFlag) String() string {
+	var pairs []string
+	for k, v := range h {
+		pairs = append(pairs, k+": "+v)
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func (h headerFlag) Set(s string) error {
+	name, value, ok := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return fmt.Errorf("invalid header %q, want 'Name: value'", s)
+	}
+	h[http.CanonicalHeaderKey(name)] = strings.TrimSpace(value)
+	return nil
+}
+
+// authFlags are the credential flags of one side, -source-* or -dest-*.
+// The secrets can also be set in CM_SYNC_SOURCE_PASSWORD, _TOKEN, _API_KEY
+// and _OIDC_CLIENT_SECRET (CM_SYNC_DEST_* for the destination), out of
+// process lists.
+type authFlags struct {
+	prefix            string
+	auth              *string
+	username          *string
+	password          *string
+	token             *string
+	apiKey            *string
+	cert              *string
+	key               *string
+	ca                *string
+	awsProfile        *string
+	googleCredentials *string
+	oidcTokenURL      *string
+	oidcClientID      *string
+	oidcClientSecret  *string
+	oidcScopes        *string
+	headers           headerFlag
+}
+
+func addAuthFlags(fs *flag.FlagSet, prefix, side string) *authFlags {
+	a := &authFlags{prefix: prefix, headers: headerFlag{}}
+	a.auth = fs.String(prefix+"-auth", "", "sigv4 to sign every request to the "+side+" with the default AWS credential chain, see -aws-region")
+	a.username = fs.String(prefix+"-username", "", "username for basic auth to the "+side)
+	a.password = fs.String(prefix+"-password", "", "password for basic auth to the "+side)
+	a.token = fs.String(prefix+"-token", "", "bearer or private token for the "+side+", the SAS token of azblob://")
+	a.apiKey = fs.String(prefix+"-api-key", "", "artifactory api key for the "+side)
+	a.cert = fs.String(prefix+"-cert", "", "client certificate pem file for mTLS to the "+side)
+	a.key = fs.String(prefix+"-key", "", "key pem file of -"+prefix+"-cert, if it isn't in the certificate file")
+	a.ca = fs.String(prefix+"-ca", "", "pem file with the CAs the "+side+" server certificate is checked against")
+	a.awsProfile = fs.String(prefix+"-aws-profile", "", "aws shared config profile for an s3:// or ECR "+side)
+	a.googleCredentials = fs.String(prefix+"-google-credentials", "", "google credentials json file for a gs:// or GCP registry "+side)
+	a.oidcTokenURL = fs.String(prefix+"-oidc-token-url", "", "OIDC token endpoint to get bearer tokens for the "+side+" from with client credentials, refreshed as they expire")
+	a.oidcClientID = fs.String(prefix+"-oidc-client-id", "", "client id for -"+prefix+"-oidc-token-url")
+	a.oidcClientSecret = fs.String(prefix+"-oidc-client-secret", "", "client secret for -"+prefix+"-oidc-token-url")
+	a.oidcScopes = fs.String(prefix+"-oidc-scopes", "", "comma separated scopes requested from -"+prefix+"-oidc-token-url")
+	fs.Var(a.headers, prefix+"-header", "header sent with every request to the "+side+", like 'X-Org: platform', repeatable")
+	return a
+}
+
+// apply sets the flags in set, and the secrets of the environment, over
+// the credentials of the config.
+func (a *authFlags) apply(c *credentials, set map[string]bool) {
+	env := "CM_SYNC_" + strings.ToUpper(a.prefix) + "_"
+	for _, f := range []struct {
+		name, env string
+		value     *string
+		field     *string
+	}{
+		{"auth", "", a.auth, &c.Type},
+		{"username", "", a.username, &c.Username},
+		{"password", "PASSWORD", a.password, &c.Password},
+		{"token", "TOKEN", a.token, &c.Token},
+		{"api-key", "API_KEY", a.apiKey, &c.APIKey},
+		{"cert", "", a.cert, &c.CertFile},
+		{"key", "", a.key, &c.KeyFile},
+		{"ca", "", a.ca, &c.CAFile},
+		{"aws-profile", "", a.awsProfile, &c.AWSProfile},
+		{"google-credentials", "", a.googleCredentials, &c.GoogleCredentials},
+		{"oidc-token-url", "", a.oidcTokenURL, &c.OIDCTokenURL},
+		{"oidc-client-id", "", a.oidcClientID, &c.OIDCClientID},
+		{"oidc-client-secret", "OIDC_CLIENT_SECRET", a.oidcClientSecret, &c.OIDCClientSecret},
+	} {
+		if set[a.prefix+"-"+f.name] {
+			*f.field = *f.value
+		} else if v := os.Getenv(env + f.env); f.env != "" && v != "" {
+			*f.field = v
+		}
+	}
+	if set[a.prefix+"-oidc-scopes"] {
+		c.OIDCScopes = splitList(*a.oidcScopes)
+	}
+	c.addHeaders(a.headers)
+}
+
+type syncOptions struct {
+	Deps      bool     `yaml:"deps"`
+	Force     bool     `yaml:"force"`
+	Include   []string `yaml:"include"`
+	Exclude   []string `yaml:"exclude"`
+	Retention int      `yaml:"retention"`
+	// RequireAnnotations only lets versions through whose Chart.yaml has
+	// these annotations, a key with an empty value only has to be set.
+	RequireAnnotations map[string]string `yaml:"require_annotations"`
+	// Shard like 2/8 limits a run to the charts whose name hashes into
+	// it, so independent runs split the charts between them.
+	Shard string `yaml:"shard"`
+	// NameWindow is the range of chart names a run is at, set by the run.
+	NameWindow  nameWindow `yaml:"-"`
+	Concurrency int        `yaml:"concurrency"`
+	// MaxConcurrency adapts the charts transferred in parallel between 1
+	// and it, starting at Concurrency.
+	MaxConcurrency  int         `yaml:"max_concurrency"`
+	SourceAuth      credentials `yaml:"source_auth"`
+	DestinationAuth credentials `yaml:"destination_auth"`
+	// ChartTimeout bounds the download and upload of one chart.
+	ChartTimeout time.Duration `yaml:"chart_timeout"`
+	// ReleaseNotes adds what changed in each synced version to the
+	// summary, from its artifacthub.io/changes or README.md.
+	ReleaseNotes bool `yaml:"release_notes"`
+	// policy is loaded from the Policy files of the config.
+	policy *chartPolicy
+}
+
+// tenantConfig overrides the global options for one source tenant, unset
+// fields fall back to the global value.
+type tenantConfig struct {
+	Path            string       `yaml:"path"`
+	Destination     *string      `yaml:"destination"`
+	Include         []string     `yaml:"include"`
+	Exclude         []string     `yaml:"exclude"`
+	Retention       *int         `yaml:"retention"`
+	Concurrency     *int         `yaml:"concurrency"`
+	SourceAuth      *credentials `yaml:"source_auth"`
+	DestinationAuth *credentials `yaml:"destination_auth"`
+	// RequireAnnotations replaces the global required annotations, an
+	// empty map requires none.
+	RequireAnnotations map[string]string `yaml:"require_annotations"`
+}
+
+// spokeConfig is a destination of a hub, the tenant fields are the path on
+// the hub, the path on the spoke and the options of its transfers.
+type spokeConfig struct {
+	// Name is how the spoke is reported, its url if empty.
+	Name         string `yaml:"name"`
+	URL          string `yaml:"url"`
+	Type         string `yaml:"type"`
+	tenantConfig `yaml:",inline"`
+}
+
+// stageConfig is a repository of a promotion pipeline.
+type stageConfig struct {
+	Name   string      `yaml:"name"`
+	URL    string      `yaml:"url"`
+	Type   string      `yaml:"type"`
+	Tenant string      `yaml:"tenant"`
+	Auth   credentials `yaml:"auth"`
+	// Approval is what promotions into the stage wait for: confirm asks
+	// on the terminal, file looks the version up in ApprovalFile and
+	// webhook asks ApprovalWebhook. Promotions need none if empty.
+	Approval        string `yaml:"approval"`
+	ApprovalFile    string `yaml:"approval_file"`
+	ApprovalWebhook string `yaml:"approval_webhook"`
+}
+
+type config struct {
+	Source      string `yaml:"source"`
+	Destination string `yaml:"destination"`
+	// Mirrors are more destinations the source is synced into, with the
+	// destination type, auth and tenant settings.
+	Mirrors []string `yaml:"mirrors"`
+	// Hub is another name for Source, for configs with spokes. Spokes are
+	// the destinations the hub is synced into each with their own type,
+	// auth and filters, instead of Destination.
+	Hub    string        `yaml:"hub"`
+	Spokes []spokeConfig `yaml:"spokes"`
+	// Stages are the repositories charts are promoted through in order,
+	// see cm_sync promote, PromotionAudit the jsonl file every promotion
+	// is recorded in.
+	Stages         []stageConfig `yaml:"stages"`
+	PromotionAudit string        `yaml:"promotion_audit"`
+	// Policy are rego files and data documents, or directories of them,
+	// deciding in package cm_sync which versions may be uploaded where.
+	// PolicyContents passes the files of each chart to it as well.
+	Policy         []string `yaml:"policy"`
+	PolicyContents bool     `yaml:"policy_contents"`
+	// mirrorTypes are the detected server types of http mirrors and
+	// spokes.
+	mirrorTypes map[string]string
+	// client sends the requests of the repositories, httpClient when nil.
+	client *http.Client
+	// SourceType and DestinationType are chartmuseum, harbor, artifactory
+	// or gitlab, sources can also be static and destinations nexus.
+	// Chartmuseum, harbor and static are detected when empty.
+	SourceType      string `yaml:"source_type"`
+	DestinationType string `yaml:"destination_type"`
+	PlainHTTP       bool   `yaml:"plain_http"`
+	// IndexURL is the url a file or bucket destination is served from,
+	// charts are listed with absolute urls below it in index.yaml.
+	IndexURL string `yaml:"index_url"`
+	// CommitMessage is the text/template for commits to a git destination.
+	CommitMessage string `yaml:"commit_message"`
+	// MaxMemory (e.g. 64M) is the largest chart kept in memory, bigger
+	// ones are spooled to SpoolDir, the system temp dir when empty.
+	MaxMemory string `yaml:"max_memory"`
+	SpoolDir  string `yaml:"spool_dir"`
+	// ChartCache is a directory keeping downloaded charts by digest, so
+	// they are fetched from the source once, up to ChartCacheSize.
+	ChartCache     string `yaml:"chart_cache"`
+	ChartCacheSize string `yaml:"chart_cache_size"`
+	// MaxChartSize (e.g. 100M) is the largest chart transferred.
+	MaxChartSize string `yaml:"max_chart_size"`
+	// MaxBandwidth caps transfer rates, e.g. 10MB/s or down=8MB/s,up=2MB/s.
+	MaxBandwidth string `yaml:"max_bandwidth"`
+	// UserAgent replaces the cm_sync/VERSION User-Agent of requests.
+	UserAgent string `yaml:"user_agent"`
+	// RequestIDHeader like X-Request-Id is sent with a new UUID each run.
+	RequestIDHeader string `yaml:"request_id_header"`
+	// SourceRPS and DestinationRPS cap the requests per second sent to
+	// the source and destination servers.
+	SourceRPS      float64 `yaml:"source_rps"`
+	DestinationRPS float64 `yaml:"dest_rps"`
+	// Interval runs cm_sync as a daemon syncing every interval, transfers
+	// only start inside Window when it is set.
+	Interval time.Duration `yaml:"interval"`
+	Window   string        `yaml:"window"`
+	// DryRun lists the charts a sync would transfer and their size.
+	DryRun bool `yaml:"dry_run"`
+	// SummaryJSON is a file the summary of each run is written to.
+	SummaryJSON string `yaml:"summary_json"`
+	// Changelog is a file the changes of each run on the destinations are
+	// written to, as markdown or json per ChangelogFormat.
+	Changelog       string `yaml:"changelog"`
+	ChangelogFormat string `yaml:"changelog_format"`
+	// MaxFailureRate is the share of failed versions, like 5%, a run
+	// still exits 0 with. Unset failures don't change the exit code.
+	MaxFailureRate string `yaml:"max_failure_rate"`
+	// QuarantineAfter skips versions that failed this many runs in a
+	// row, counted in -index-cache.
+	QuarantineAfter int `yaml:"quarantine_after"`
+	// RetryBackoff is the wait before failed versions are retried, first
+	// in the next runs, doubling with each attempt.
+	RetryBackoff time.Duration `yaml:"retry_backoff"`
+	// Events is the kafka:// or nats:// url synced and failed versions
+	// are published to.
+	Events string `yaml:"events"`
+	// Notifications are where the summary of each run is sent, with a
+	// template of their own each.
+	Notifications []notificationConfig `yaml:"notifications"`
+	// NameWindows are the chart names the charts are split at, each range
+	// is diffed and synced before the next.
+	NameWindows []string `yaml:"name_windows"`
+	// Trigger is the sqs:// queue whose messages start daemon runs.
+	Trigger string `yaml:"trigger"`
+	// Coordinator is the address workers lease the diffed versions from,
+	// Worker the url of the coordinator a worker transfers them for.
+	// Workers send CoordinatorToken as a bearer token, the coordinator
+	// refuses requests without it.
+	Coordinator      string        `yaml:"coordinator"`
+	Worker           string        `yaml:"worker"`
+	CoordinatorToken string        `yaml:"coordinator_token"`
+	LeaseTimeout     time.Duration `yaml:"lease_timeout"`
+	// HealthAddr serves /healthz and /readyz of a daemon, /healthz fails
+	// once a run or wait is StallTimeout overdue.
+	HealthAddr   string        `yaml:"health_addr"`
+	StallTimeout time.Duration `yaml:"stall_timeout"`
+	// ApprovalWebhook decides on the versions a sync is about to upload to
+	// each destination, ApprovalDefault (allow or deny) is the decision
+	// when it fails or doesn't decide within ApprovalTimeout. Webhooks
+	// answering 202 post the decision to ApprovalCallbackURL, served on
+	// ApprovalCallbackAddr.
+	ApprovalWebhook      string        `yaml:"approval_webhook"`
+	ApprovalTimeout      time.Duration `yaml:"approval_timeout"`
+	ApprovalDefault      string        `yaml:"approval_default"`
+	ApprovalCallbackAddr string        `yaml:"approval_callback_addr"`
+	ApprovalCallbackURL  string        `yaml:"approval_callback_url"`
+	// SourceIndex and DestinationIndex are chart lists saved earlier that
+	// stand in for the servers, to plan without network access.
+	SourceIndex      string `yaml:"source_index"`
+	DestinationIndex string `yaml:"dest_index"`
+	// TrustedSigners are the gpg fingerprints import accepts the manifest
+	// signature of a bundle from.
+	TrustedSigners []string `yaml:"trusted_signers"`
+	// IndexCache is the directory server indexes are cached in between
+	// runs, for conditional requests.
+	IndexCache string `yaml:"index_cache"`
+	// IndexConcurrency is the number of tenants planned in parallel.
+	IndexConcurrency int `yaml:"index_concurrency"`
+	syncOptions      `yaml:",inline"`
+	Tenants          []tenantConfig `yaml:"tenants"`
+}
+
+type syncJob struct {
+	tenant string
+	target string
+	// server is the destination or mirror url the job syncs into, spoke
+	// the name of the spoke.
+	server      string
+	spoke       string
+	source      chartSource
+	destination chartDestination
+	options     syncOptions
+}
+
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &config{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	if cfg.Source == "" {
+		cfg.Source = cfg.Hub
+	}
+	for i, s := range cfg.Spokes {
+		if s.URL == "" {
+			return nil, fmt.Errorf("spoke %d has no url", i+1)
+		}
+		if s.Name == "" {
+			cfg.Spokes[i].Name = s.URL
+		}
+		cfg.Spokes[i].Path = normalizeTenant(s.Path)
+		if s.Destination != nil {
+			d := normalizeTenant(*s.Destination)
+			cfg.Spokes[i].Destination = &d
+		}
+	}
+	for i, t := range cfg.Tenants {
+		cfg.Tenants[i].Path = normalizeTenant(t.Path)
+		if t.Destination != nil {
+			d := normalizeTenant(*t.Destination)
+			cfg.Tenants[i].Destination = &d
+		}
+	}
+	return cfg, nil
+}
+
+func (t tenantConfig) apply(opts syncOptions) syncOptions {
+	if t.Include != nil {
+		opts.Include = t.Include
+	}
+	if t.Exclude != nil {
+		opts.Exclude = t.Exclude
+	}
+	if t.Retention != nil {
+		opts.Retention = *t.Retention
+	}
+	if t.RequireAnnotations != nil {
+		opts.RequireAnnotations = t.RequireAnnotations
+	}
+	if t.Concurrency != nil {
+		opts.Concurrency = *t.Concurrency
+	}
+	if t.SourceAuth != nil {
+		opts.SourceAuth = *t.SourceAuth
+	}
+	if t.DestinationAuth != nil {
+		opts.DestinationAuth = *t.DestinationAuth
+	}
+	return opts
+}
+
+func isHTTP(ref string) bool {
+	return strings.HasPrefix(ref, "http://") || strings.HasPrefix(ref, "https://")
+}
+
+// newStore returns the blob storage behind a bucket url, or nil when ref
+// is not one.
+func newStore(ref, tenant string, auth credentials, client *http.Client) (blobStore, error) {
+	switch {
+	case strings.HasPrefix(ref, "file://"):
+		return newDirStore(ref, tenant)
+	case strings.HasPrefix(ref, "s3://"):
+		return newS3Store(ref, tenant, auth, client)
+	case strings.HasPrefix(ref, "gs://"):
+		return newGCSStore(ref, tenant, auth, client)
+	case strings.HasPrefix(ref, "azblob://"):
+		return newAzblobStore(ref, tenant, auth, client)
+	case strings.HasPrefix(ref, "sftp://"):
+		return newSFTPStore(ref, tenant, auth)
+	}
+	return nil, nil
+}
+
+// detectServerTypes detects the type of http sources and destinations that
+// don't have one configured, index snapshots aren't asked.
+func (c *config) detectServerTypes(ctx context.Context) {
+	if c.SourceType == "" && c.SourceIndex == "" && isHTTP(c.Source) {
+		c.SourceType = detectServerType(ctx, c.Source, c.SourceAuth, c.client)
+	}
+	if c.DestinationType == "" {
+		for _, m := range c.Mirrors {
+			if isHTTP(m) {
+				if c.mirrorTypes == nil {
+					c.mirrorTypes = make(map[string]string)
+				}
+				c.mirrorTypes[m] = detectServerType(ctx, m, c.DestinationAuth, c.client)
+			}
+		}
+	}
+	for _, s := range c.Spokes {
+		if s.Type == "" && c.DestinationType == "" && isHTTP(s.URL) {
+			if c.mirrorTypes == nil {
+				c.mirrorTypes = make(map[string]string)
+			}
+			c.mirrorTypes[s.URL] = detectServerType(ctx, s.URL, s.apply(c.syncOptions).DestinationAuth, c.client)
+		}
+	}
+	if len(c.Spokes) > 0 {
+		return
+	}
+	if c.DestinationType == "" && c.DestinationIndex == "" && isHTTP(c.Destination) {
+		c.DestinationType = detectServerType(ctx, c.Destination, c.DestinationAuth, c.client)
+	}
+}
+
+// newSource returns the source for a tenant, on an oci registry or bucket
+// the tenant is a path below the configured namespace or prefix.
+func (c *config) newSource(tenant string, opts syncOptions) (chartSource, error) {
+	opts.SourceAuth = withStoredLogin(c.Source, opts.SourceAuth)
+	client := clientOr(c.client)
+	if c.SourceIndex != "" {
+		if tenant != "" {
+			return nil, fmt.Errorf("an index snapshot can't be split into tenants")
+		}
+		return newSnapshotRepo(c.SourceIndex)
+	}
+	if strings.HasPrefix(c.Source, "oci://") {
+		ref := strings.TrimSuffix(c.Source, "/")
+		if tenant != "" {
+			ref += "/" + tenant
+		}
+		return newOCISource(ref, c.PlainHTTP, opts.SourceAuth, opts.Include, client), nil
+	}
+	if store, err := newStore(c.Source, tenant, opts.SourceAuth, client); store != nil || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		return newStoreRepo(store, client), nil
+	}
+	switch c.SourceType {
+	case "harbor":
+		return newHarborRepo(c.Source, tenant, opts.SourceAuth, client), nil
+	case "artifactory":
+		return newArtifactoryRepo(c.Source, tenant, opts.SourceAuth, client), nil
+	case "static":
+		return newStaticRepo(c.Source, tenant, opts.SourceAuth, client), nil
+	case "gitlab":
+		return newGitLabRepo(c.Source, tenant, opts.SourceAuth, client), nil
+	}
+	return repo{server: c.Source, tenant: tenant, auth: opts.SourceAuth, client: client}, nil
+}
+
+func (c *config) newDestination(tenant string, opts syncOptions) (chartDestination, error) {
+	opts.DestinationAuth = withStoredLogin(c.Destination, opts.DestinationAuth)
+	client := clientOr(c.client)
+	if c.DestinationIndex != "" {
+		if tenant != "" {
+			return nil, fmt.Errorf("an index snapshot can't be split into tenants")
+		}
+		return newSnapshotRepo(c.DestinationIndex)
+	}
+	store, err := newStore(c.Destination, tenant, opts.DestinationAuth, client)
+	if strings.HasPrefix(c.Destination, "git+") {
+		store, err = newGitStore(c.Destination, tenant, opts.DestinationAuth, c.CommitMessage)
+	}
+	if store != nil || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		dst := newStoreRepo(store, client)
+		if c.IndexURL != "" {
+			dst.baseURL = strings.TrimSuffix(c.IndexURL, "/")
+			if tenant != "" {
+				dst.baseURL += "/" + tenant
+			}
+		}
+		return dst, nil
+	}
+	switch c.DestinationType {
+	case "harbor":
+		return newHarborRepo(c.Destination, tenant, opts.DestinationAuth, client), nil
+	case "artifactory":
+		return newArtifactoryRepo(c.Destination, tenant, opts.DestinationAuth, client), nil
+	case "nexus":
+		return newNexusRepo(c.Destination, tenant, opts.DestinationAuth, client), nil
+	case "gitlab":
+		return newGitLabRepo(c.Destination, tenant, opts.DestinationAuth, client), nil
+	case "static":
+		return nil, fmt.Errorf("static helm repositories can only be a source")
+	}
+	return repo{server: c.Destination, tenant: tenant, auth: opts.DestinationAuth, force: opts.Force, client: client}, nil
+}
+
+// mirror is the config of the destination mirror url, of the configured
+// type or the one detected for it.
+func (c *config) mirror(url string) *config {
+	m := *c
+	m.Destination = url
+	m.DestinationIndex = ""
+	if t, ok := c.mirrorTypes[url]; ok {
+		m.DestinationType = t
+	}
+	return &m
+}
+
+// spoke is the config of the hub and spoke s.
+func (c *config) spoke(s spokeConfig) *config {
+	m := c.mirror(s.URL)
+	if s.Type != "" {
+		m.DestinationType = s.Type
+	}
+	return m
+}
+
+// spokeJobs are the jobs of a hub and spoke config, one per spoke.
+func (c *config) spokeJobs() ([]syncJob, error) {
+	var jobs []syncJob
+	for _, s := range c.Spokes {
+		opts := s.apply(c.syncOptions)
+		target := s.Path
+		if s.Destination != nil {
+			target = *s.Destination
+		}
+		src, err := c.newSource(s.Path, opts)
+		if err != nil {
+			return nil, err
+		}
+		dst, err := c.spoke(s).newDestination(target, opts)
+		if err != nil {
+			return nil, fmt.Errorf("spoke %s: %w", s.Name, err)
+		}
+		jobs = append(jobs, syncJob{
+			tenant:      s.Path,
+			target:      target,
+			server:      s.URL,
+			spoke:       s.Name,
+			source:      src,
+			destination: dst,
+			options:     opts,
+		})
+	}
+	return jobs, nil
+}
+
+// jobs expands the tenants given on the command line and in the config file
+// into source/destination pairs, config entries win for the same path.
+func (c *config) jobs(tenants []string, tenantMap map[string]string) ([]syncJob, error) {
+	if len(c.Spokes) > 0 {
+		return c.spokeJobs()
+	}
+	overrides := make(map[string]tenantConfig)
+	for _, t := range c.Tenants {
+		overrides[t.Path] = t
+	}
+	if len(c.Tenants) > 0 && len(tenants) == 1 && tenants[0] == "" && len(tenantMap) == 0 {
+		tenants = nil
+	}
+	seen := make(map[string]bool)
+	for _, t := range c.Tenants {
+		if !seen[t.Path] {
+			tenants = append(tenants, t.Path)
+			seen[t.Path] = true
+		}
+	}
+
+	var jobs []syncJob
+	done := make(map[string]bool)
+	for _, tenant := range tenants {
+		if done[tenant] {
+			continue
+		}
+		done[tenant] = true
+
+		target, ok := tenantMap[tenant]
+		if !ok {
+			target = tenant
+		}
+		opts := c.syncOptions
+		if t, ok := overrides[tenant]; ok {
+			if t.Destination != nil {
+				target = *t.Destination
+			}
+			opts = t.apply(opts)
+		}
+		dests := []*config{c}
+		for _, m := range c.Mirrors {
+			dests = append(dests, c.mirror(m))
+		}
+		for _, d := range dests {
+			src, err := c.newSource(tenant, opts)
+			if err != nil {
+				return nil, err
+			}
+			dst, err := d.newDestination(target, opts)
+			if err != nil {
+				return nil, err
+			}
+			jobs = append(jobs, syncJob{
+				tenant:      tenant,
+				target:      target,
+				server:      d.Destination,
+				source:      src,
+				destination: dst,
+				options:     opts,
+			})
+		}
+	}
+	return jobs, nil
+}
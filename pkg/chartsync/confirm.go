@@ -0,0 +1,84 @@
+package chartsync
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// maxListed is the most changes confirmChanges lists, the others are
+// counted.
+const maxListed = 20
+
+// confirm asks question on the terminal, anything but y or yes declines.
+// Without a terminal the answer is read from stdin, which declines at its
+// end.
+func confirm(question string) bool {
+	fmt.Fprint(os.Stderr, question+" [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// confirmChanges is the guard of destructive operations: it lists the
+// changes, versions deleted or overwritten, and asks question before they
+// are made. yes, from -yes, goes ahead without asking.
+func confirmChanges(yes bool, question string, changes []string) bool {
+	if yes {
+		return true
+	}
+	for i, c := range changes {
+		if i == maxListed {
+			logf("  ... and %d more\n", len(changes)-maxListed)
+			break
+		}
+		logln(" ", c)
+	}
+	return confirm(redact(question))
+}
+
+// overwrites lists the queued versions of jobs their destination has
+// already, those -force uploads again.
+func overwrites(jobs []plannedJob) []string {
+	var list []string
+	for _, job := range jobs {
+		for _, item := range job.plan.queue {
+			if hasVersion(job.plan.destData, item.Chart, item.Version) {
+				change := overwriteChange(item.Chart, item.Version, indexDigest(job.plan.destData, item.Chart, item.Version), indexDigest(job.plan.sourceData, item.Chart, item.Version))
+				list = append(list, fmt.Sprintf("%s on %s", change, job.destination))
+			}
+		}
+	}
+	return list
+}
+
+// overwriteChange describes the overwrite of a version, with the digests
+// when they are known.
+func overwriteChange(chart, version, oldDigest, newDigest string) string {
+	if oldDigest == "" || newDigest == "" {
+		return chart + "-" + version
+	}
+	return fmt.Sprintf("%s-%s (%s replaced by %s)", chart, version, shortDigest(oldDigest), shortDigest(newDigest))
+}
+
+// confirmOverwrites asks before -force overwrites versions in a sync, a
+// declined overwrite is skipped and the new versions still synced.
+func confirmOverwrites(jobs []plannedJob, summary *runSummary) {
+	list := overwrites(jobs)
+	if len(list) == 0 || confirmChanges(false, fmt.Sprintf("Overwrite %d versions whose digests differ on the destination?", len(list)), list) {
+		return
+	}
+	logf("Skipping %d overwrites, pass -yes to overwrite without asking\n", len(list))
+	for i, job := range jobs {
+		var queue []syncItem
+		for _, item := range job.plan.queue {
+			if hasVersion(job.plan.destData, item.Chart, item.Version) {
+				summary.skip(item)
+				continue
+			}
+			queue = append(queue, item)
+		}
+		jobs[i].plan.queue = queue
+	}
+}
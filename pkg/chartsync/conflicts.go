@@ -0,0 +1,88 @@
+package chartsync
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// conflict is a version both servers have with different digests, the
+// mirrors diverged and syncs leave it alone unless forced.
+type conflict struct {
+	Chart             string `json:"chart"`
+	Version           string `json:"version"`
+	SourceDigest      string `json:"source_digest"`
+	DestinationDigest string `json:"destination_digest"`
+	// Destination is the destination of the sync that found it.
+	Destination string `json:"destination,omitempty"`
+}
+
+// findConflicts lists the versions of data1 that data2 has with another
+// digest, sorted by chart and newest version first. Versions without a
+// digest on either side can't be compared and aren't listed.
+func findConflicts(data1, data2 ChartData) []conflict {
+	var conflicts []conflict
+	for chart, versions := range data1 {
+		digests := make(map[string]string)
+		for _, v := range data2[chart] {
+			digests[v.Version] = strings.TrimPrefix(v.Digest, "sha256:")
+		}
+		for _, v := range versions {
+			d1, d2 := strings.TrimPrefix(v.Digest, "sha256:"), digests[v.Version]
+			if d1 != "" && d2 != "" && d1 != d2 {
+				conflicts = append(conflicts, conflict{Chart: chart, Version: v.Version, SourceDigest: d1, DestinationDigest: d2})
+			}
+		}
+	}
+	sortConflicts(conflicts)
+	return conflicts
+}
+
+func sortConflicts(conflicts []conflict) {
+	sort.Slice(conflicts, func(i, j int) bool {
+		a, b := conflicts[i], conflicts[j]
+		if a.Chart != b.Chart {
+			return a.Chart < b.Chart
+		}
+		if a.Version != b.Version {
+			return newerVersion(a.Version, b.Version)
+		}
+		return a.Destination < b.Destination
+	})
+}
+
+// printConflicts writes the conflicts section of diff and sync output.
+func printConflicts(w io.Writer, conflicts []conflict) {
+	if len(conflicts) == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\nConflicts, same version with different digests:")
+	servers := false
+	for _, c := range conflicts {
+		servers = servers || c.Destination != ""
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if servers {
+		fmt.Fprintln(tw, "  VERSION\tSOURCE\tDESTINATION\tON")
+	} else {
+		fmt.Fprintln(tw, "  VERSION\tSOURCE\tDESTINATION")
+	}
+	for _, c := range conflicts {
+		fmt.Fprintf(tw, "  %s-%s\t%s\t%s", c.Chart, c.Version, shortDigest(c.SourceDigest), shortDigest(c.DestinationDigest))
+		if servers {
+			fmt.Fprintf(tw, "\t%s", redact(c.Destination))
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+}
+
+// shortDigest is the start of a sha256 digest, enough to tell them apart.
+func shortDigest(d string) string {
+	if len(d) > 12 {
+		return d[:12]
+	}
+	return d
+}
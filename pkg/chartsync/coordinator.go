@@ -0,0 +1,428 @@
+package chartsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// workItem is a chart version the coordinator hands out, Job names the
+// source and destination it is synced between.
+type workItem struct {
+	ID      int    `json:"id"`
+	Job     string `json:"job"`
+	Chart   string `json:"chart"`
+	Version string `json:"version"`
+}
+
+// workResult is what a worker reports back for a work item.
+type workResult struct {
+	ID      int    `json:"id"`
+	Size    int64  `json:"size,omitempty"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Kind    string `json:"kind,omitempty"`
+	Code    string `json:"code,omitempty"`
+}
+
+type leaseRequest struct {
+	Worker string `json:"worker"`
+	Max    int    `json:"max"`
+}
+
+type leaseResponse struct {
+	// Run changes with every run of the coordinator, workers list the
+	// charts again when it does.
+	Run   int64      `json:"run"`
+	Items []workItem `json:"items"`
+	Done  bool       `json:"done"`
+}
+
+func jobKey(source chartSource, destination chartDestination) string {
+	return fmt.Sprintf("%s -> %s", source, destination)
+}
+
+// jobReporter is the reporter of the quarantine, retry queue, events and
+// changelog for job, nil without any of them.
+func jobReporter(opts runOptions, job syncJob) Reporter {
+	destination := fmt.Sprint(job.destination)
+	var rs reporters
+	if opts.quarantine != nil {
+		rs = append(rs, opts.quarantine.reporter(destination))
+	}
+	if opts.retries != nil {
+		rs = append(rs, opts.retries.reporter(destination))
+	}
+	if opts.events != nil {
+		rs = append(rs, opts.events.reporter(fmt.Sprint(job.source), destination))
+	}
+	if opts.destinations != nil {
+		rs = append(rs, opts.destinations.reporter(job.server))
+	}
+	if opts.changelog != nil {
+		rs = append(rs, opts.changelog.reporter(destination))
+	}
+	if len(rs) == 0 {
+		return nil
+	}
+	return rs
+}
+
+// coordinator leases the queued versions of a run to workers. Leases that
+// aren't reported back within timeout are handed out again.
+type coordinator struct {
+	mu        sync.Mutex
+	run       int64
+	token     string
+	timeout   time.Duration
+	items     []workItem
+	pending   []int
+	leases    map[int]time.Time
+	reported  map[int]bool
+	reporters map[string]Reporter
+	summary   *runSummary
+	done      chan struct{}
+}
+
+// coordinate serves the queues of the planned jobs to workers on addr and
+// returns once all of them were reported or ctx is done.
+func coordinate(ctx context.Context, planned []plannedJob, opts runOptions, summary *runSummary) {
+	c := &coordinator{
+		run:       time.Now().UnixNano(),
+		token:     opts.coordinatorToken,
+		timeout:   opts.leaseTimeout,
+		leases:    make(map[int]time.Time),
+		reported:  make(map[int]bool),
+		reporters: make(map[string]Reporter),
+		summary:   summary,
+		done:      make(chan struct{}),
+	}
+	for _, job := range planned {
+		key := jobKey(job.source, job.destination)
+		if r := jobReporter(opts, job.syncJob); r != nil {
+			c.reporters[key] = r
+		}
+		for _, item := range job.plan.queue {
+			c.pending = append(c.pending, len(c.items))
+			c.items = append(c.items, workItem{len(c.items), key, item.Chart, item.Version})
+		}
+	}
+	if len(c.items) == 0 {
+		return
+	}
+
+	ln, err := net.Listen("tcp", opts.coordinator)
+	if err != nil {
+		logln("Error listening for workers on", opts.coordinator, "\n", err)
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /lease", c.authorized(c.serveLease))
+	mux.HandleFunc("POST /report", c.authorized(c.serveReport))
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(ln)
+	logln("Coordinating", len(c.items), "charts for workers on", ln.Addr())
+
+	select {
+	case <-c.done:
+		// Workers polling for more learn that the run is over.
+		sleep(ctx, 2*workerPoll)
+	case <-ctx.Done():
+	}
+	shutdown, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+	srv.Shutdown(shutdown)
+}
+
+// authorized refuses the requests of workers that don't send the token.
+func (c *coordinator) authorized(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+c.token)) != 1 {
+			http.Error(w, "invalid coordinator token", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (c *coordinator) serveLease(w http.ResponseWriter, r *http.Request) {
+	var req leaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for id, expires := range c.leases {
+		if now.After(expires) {
+			logf("Lease of %s-%s expired, handing it out again\n", c.items[id].Chart, c.items[id].Version)
+			delete(c.leases, id)
+			c.pending = append(c.pending, id)
+		}
+	}
+	resp := leaseResponse{Run: c.run, Items: []workItem{}, Done: len(c.reported) == len(c.items)}
+	for len(c.pending) > 0 && len(resp.Items) < max(req.Max, 1) {
+		id := c.pending[0]
+		c.pending = c.pending[1:]
+		c.leases[id] = now.Add(c.timeout)
+		resp.Items = append(resp.Items, c.items[id])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (c *coordinator) serveReport(w http.ResponseWriter, r *http.Request) {
+	var results []workResult
+	if err := json.NewDecoder(r.Body).Decode(&results); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, res := range results {
+		// Late reports of leases that expired still count, the version
+		// is dropped from the queue if it was handed out again.
+		if res.ID < 0 || res.ID >= len(c.items) || c.reported[res.ID] {
+			continue
+		}
+		c.reported[res.ID] = true
+		delete(c.leases, res.ID)
+		for i, id := range c.pending {
+			if id == res.ID {
+				c.pending = append(c.pending[:i], c.pending[i+1:]...)
+				break
+			}
+		}
+
+		it := c.items[res.ID]
+		item, v := syncItem{Chart: it.Chart, Version: it.Version}, Version{it.Chart, it.Version}
+		reporter := c.reporters[it.Job]
+		switch {
+		case res.Error != "":
+			err := remoteError(res.Error, res.Kind, res.Code)
+			c.summary.fail(item, err)
+			if reporter != nil {
+				reporter.Failed(v, err)
+			}
+		case res.Skipped:
+			c.summary.skip(item)
+			if reporter != nil {
+				reporter.Skipped(v)
+			}
+		default:
+			c.summary.sync(item, res.Size)
+			if reporter != nil {
+				reporter.Synced(v, res.Size)
+			}
+		}
+	}
+	if len(c.reported) == len(c.items) {
+		select {
+		case <-c.done:
+		default:
+			close(c.done)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// remoteError is an error a worker reported, of the kind and code it had
+// there.
+func remoteError(msg, kind, code string) error {
+	err := errors.New(msg)
+	for _, k := range errorKinds {
+		if k.name == kind {
+			err = kindError{msg, k.err}
+		}
+	}
+	if code != "" {
+		err = codeError{code, err}
+	}
+	return err
+}
+
+// workerPoll is how often idle workers ask the coordinator for work.
+const workerPoll = time.Second
+
+// runWorker transfers the versions leased from the coordinator at url with
+// token, up to the concurrency of their job at a time. It returns when the
+// coordinator's run is over, or with daemon set keeps waiting for the
+// next one until ctx is done.
+func runWorker(ctx context.Context, jobs []syncJob, url, token string, daemon bool) {
+	url = strings.TrimSuffix(url, "/")
+	host, _ := os.Hostname()
+	name := fmt.Sprintf("%s-%d", host, os.Getpid())
+	byKey := make(map[string]syncJob, len(jobs))
+	batch := 1
+	for _, job := range jobs {
+		byKey[jobKey(job.source, job.destination)] = job
+		batch = max(batch, job.options.Concurrency)
+	}
+
+	type listing struct{ sourceData, destData ChartData }
+	listed := make(map[string]listing)
+	var run int64
+	connected, waiting := false, false
+	for ctx.Err() == nil {
+		var lease leaseResponse
+		err := postJSON(ctx, url+"/lease", token, leaseRequest{name, batch}, &lease)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if errors.Is(err, ErrUnauthorized) {
+				logln("Coordinator", url, "refused the -coordinator-token, stopping")
+				return
+			}
+			if connected && !daemon {
+				logln("Coordinator", url, "went away, stopping")
+				return
+			}
+			if !waiting {
+				logln("Waiting for coordinator", url, "\n", err)
+				waiting = true
+			}
+			sleep(ctx, 5*workerPoll)
+			continue
+		}
+		connected, waiting = true, false
+		if lease.Run != run {
+			run = lease.Run
+			listed = make(map[string]listing)
+		}
+		if len(lease.Items) == 0 {
+			if lease.Done && !daemon {
+				logln("Coordinator", url, "is done")
+				return
+			}
+			sleep(ctx, workerPoll)
+			continue
+		}
+
+		results := &workResults{ids: make(map[syncItem]int)}
+		queues := make(map[string][]syncItem)
+		for _, it := range lease.Items {
+			item := syncItem{Chart: it.Chart, Version: it.Version}
+			if _, ok := byKey[it.Job]; !ok {
+				results.add(workResult{ID: it.ID, Error: "worker doesn't sync " + it.Job})
+				continue
+			}
+			results.ids[item] = it.ID
+			queues[it.Job] = append(queues[it.Job], item)
+		}
+		for key, queue := range queues {
+			job := byKey[key]
+			l, ok := listed[key]
+			if !ok {
+				l.sourceData, err = job.source.listCharts(ctx)
+				if err == nil {
+					l.destData, err = job.destination.listCharts(ctx)
+				}
+				if err != nil {
+					for _, item := range queue {
+						results.Failed(Version{item.Chart, item.Version}, fmt.Errorf("listing charts: %w", err))
+					}
+					continue
+				}
+				listed[key] = l
+			}
+			diff := make(map[string][]string)
+			for _, item := range queue {
+				diff[item.Chart] = append(diff[item.Chart], item.Version)
+			}
+			plan := &syncPlan{
+				source:      job.source,
+				destination: job.destination,
+				opts:        job.options,
+				sourceData:  l.sourceData,
+				destData:    l.destData,
+				diff:        diff,
+				queue:       queue,
+			}
+			summary := newRunSummary()
+			summary.reporter = results
+			plan.run(ctx, summary)
+		}
+		// Versions cut short by ctx aren't reported, their leases expire.
+		if ctx.Err() != nil {
+			return
+		}
+		if err := postJSON(ctx, url+"/report", token, results.results, nil); err != nil {
+			logln("Failed to report to coordinator", url, err)
+		}
+	}
+}
+
+// workResults collects the outcomes of the leased versions, versions that
+// weren't leased, like dependencies, aren't reported.
+type workResults struct {
+	mu      sync.Mutex
+	ids     map[syncItem]int
+	results []workResult
+}
+
+func (w *workResults) add(r workResult) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.results = append(w.results, r)
+}
+
+func (w *workResults) id(v Version) (int, bool) {
+	id, ok := w.ids[syncItem{Chart: v.Chart, Version: v.Version}]
+	return id, ok
+}
+
+func (w *workResults) Synced(v Version, size int64) {
+	if id, ok := w.id(v); ok {
+		w.add(workResult{ID: id, Size: size})
+	}
+}
+
+func (w *workResults) Skipped(v Version) {
+	if id, ok := w.id(v); ok {
+		w.add(workResult{ID: id, Skipped: true})
+	}
+}
+
+func (w *workResults) Failed(v Version, err error) {
+	if id, ok := w.id(v); ok {
+		w.add(workResult{ID: id, Error: redact(err.Error()), Kind: ErrorKind(err), Code: ErrorCode(err)})
+	}
+}
+
+// postJSON posts body to url with the bearer token and decodes the answer
+// into out.
+func postJSON(ctx context.Context, url, token string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return unexpectedStatus(resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,33 @@
+package chartsync
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCoordinatorAuthorized(t *testing.T) {
+	c := &coordinator{token: "s3cret-token"}
+	h := c.authorized(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	for _, tt := range []struct {
+		header string
+		want   int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"s3cret-token", http.StatusUnauthorized},
+		{"Bearer s3cret-token", http.StatusNoContent},
+	} {
+		req := httptest.NewRequest("POST", "/lease", nil)
+		if tt.header != "" {
+			req.Header.Set("Authorization", tt.header)
+		}
+		rec := httptest.NewRecorder()
+		h(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("Authorization %q: status %d, want %d", tt.header, rec.Code, tt.want)
+		}
+	}
+}
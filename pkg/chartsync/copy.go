@@ -0,0 +1,155 @@
+package chartsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// pushProvenance uploads the .prov file of a chart to the destinations that
+// keep provenance: chartmuseum, harbor, artifactory and file or bucket
+// stores.
+func pushProvenance(ctx context.Context, dst chartDestination, chart, version string, prov []byte) error {
+	name := chart + "-" + version + ".tgz.prov"
+	var r repo
+	var req *http.Request
+	var err error
+	switch d := dst.(type) {
+	case *storeRepo:
+		return d.store.write(ctx, name, bytes.NewReader(prov), int64(len(prov)))
+	case repo:
+		u := d.server + "/api/prov"
+		if d.tenant != "" {
+			u = d.server + "/api/" + d.tenant + "/prov"
+		}
+		if d.force {
+			u += "?force"
+		}
+		r = d
+		body, contentType, length := multipartBody("prov", name, bytes.NewReader(prov), int64(len(prov)))
+		if req, err = r.newRequest(ctx, "POST", u, body); err == nil {
+			req.ContentLength = length
+			req.Header.Set("Content-Type", contentType)
+		}
+	case harborRepo:
+		r = d.repo
+		body, contentType, length := multipartBody("prov", name, bytes.NewReader(prov), int64(len(prov)))
+		if req, err = r.newRequest(ctx, "POST", d.server+"/api/chartrepo/"+d.tenant+"/prov", body); err == nil {
+			req.ContentLength = length
+			req.Header.Set("Content-Type", contentType)
+		}
+	case *artifactoryRepo:
+		r = d.repo
+		if req, err = r.newRequest(ctx, "PUT", d.artifactURL(chart, version)+".prov", bytes.NewReader(prov)); err == nil {
+			req.ContentLength = int64(len(prov))
+		}
+	default:
+		return fmt.Errorf("%s doesn't keep provenance files", dst)
+	}
+	if err != nil {
+		return err
+	}
+	resp, err := r.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return unexpectedStatus(resp.StatusCode)
+	}
+	return nil
+}
+
+// runCopy transfers exactly one chart version and its provenance, e.g. to
+// promote a release to production without filtering a full sync.
+func runCopy(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("copy", flag.ExitOnError)
+	cf := newCommandFlags(fs, true, true)
+	tenant := fs.String("tenant", "", "org/repo path of the chart on a multitenant source")
+	destTenant := fs.String("dest-tenant", "", "org/repo path to copy to on a multitenant destination, -tenant if empty")
+	force := fs.Bool("force", false, "overwrite the version if the destination already has it")
+	yes := fs.Bool("yes", false, "don't ask for confirmation before -force overwrites the version")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cm_sync copy [flags] CHART VERSION")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	chart, version := fs.Arg(0), fs.Arg(1)
+	cfg := cf.config(fs)
+	cfg.Force = cfg.Force || *force
+	if *destTenant == "" {
+		*destTenant = *tenant
+	}
+
+	src, sourceData := openSource(ctx, cfg, normalizeTenant(*tenant))
+	if !hasVersion(sourceData, chart, version) {
+		logln("Error finding chart:", chart+"-"+version, "not found in", src)
+		os.Exit(1)
+	}
+	dst, destData := openDestination(ctx, cfg, normalizeTenant(*destTenant))
+	if hasVersion(destData, chart, version) && !cfg.Force {
+		logf("%s already has %s-%s, pass -force to overwrite it\n", dst, chart, version)
+		return
+	}
+	if hasVersion(destData, chart, version) {
+		change := overwriteChange(chart, version, indexDigest(destData, chart, version), indexDigest(sourceData, chart, version))
+		if !confirmChanges(*yes, fmt.Sprintf("Overwrite %s-%s on %s?", chart, version, dst), []string{change}) {
+			logln("Nothing copied")
+			os.Exit(1)
+		}
+	}
+
+	if err := copyVersion(ctx, src, dst, sourceData, chart, version); err != nil {
+		logln("Error copying", chart+"-"+version, "\n", err)
+		os.Exit(1)
+	}
+	logf("Copied %s-%s from %s to %s\n", chart, version, src, dst)
+}
+
+// copyVersion transfers one chart version with its provenance from src to
+// dst and writes the index of dst.
+func copyVersion(ctx context.Context, src chartSource, dst chartDestination, sourceData ChartData, chart, version string) error {
+	sp, err := fetchSpool(ctx, src, chart, version, indexDigest(sourceData, chart, version))
+	if err != nil {
+		return fmt.Errorf("fetching from %s: %w", src, err)
+	}
+	defer sp.close()
+	if s, ok := dst.(chartStreamPusher); ok {
+		err = s.pushChartStream(ctx, chart, version, sp.reader(), sp.size)
+	} else {
+		var data []byte
+		if data, err = sp.bytes(); err == nil {
+			err = dst.pushChart(ctx, chart, version, data)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("pushing to %s: %w", dst, err)
+	}
+
+	var errs []error
+	if p, ok := src.(interface {
+		fetchProvenance(ctx context.Context, chart, version string) ([]byte, error)
+	}); ok {
+		prov, err := p.fetchProvenance(ctx, chart, version)
+		if err == nil && prov != nil {
+			err = pushProvenance(ctx, dst, chart, version, prov)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("copying provenance: %w", err))
+		}
+	}
+	if f, ok := dst.(interface{ flush(context.Context) error }); ok {
+		if err := f.flush(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("writing index of %s: %w", dst, err))
+		}
+	}
+	return errors.Join(errs...)
+}
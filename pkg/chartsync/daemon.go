@@ -0,0 +1,352 @@
+package chartsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type plannedJob struct {
+	syncJob
+	plan *syncPlan
+}
+
+// planJobs diffs every job, up to parallel at a time. Jobs whose charts
+// couldn't be fetched are reported and left out.
+func planJobs(ctx context.Context, jobs []syncJob, parallel int) []plannedJob {
+	plans := make([]*syncPlan, len(jobs))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(parallel, 1))
+	for i, job := range jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			p, err := planSync(ctx, job.source, job.destination, job.options)
+			if err != nil {
+				logln("Error fetching charts of", job.source, "or", job.destination, "\n", withCode(err))
+				return
+			}
+			plans[i] = p
+		}()
+	}
+	wg.Wait()
+
+	var planned []plannedJob
+	for i, p := range plans {
+		if p != nil {
+			planned = append(planned, plannedJob{jobs[i], p})
+		}
+	}
+	return planned
+}
+
+// runOptions are the settings of a run across its jobs.
+type runOptions struct {
+	// parallel is the number of jobs diffed at once.
+	parallel int
+	window   *transferWindow
+	// summaryFile is where the summary is written as json, if set.
+	summaryFile string
+	quarantine  *quarantine
+	retries     *retryQueue
+	events      *eventSink
+	history     *runHistory
+	// nameWindows are the ranges of chart names synced one at a time.
+	nameWindows []nameWindow
+	// trigger starts daemon runs before the interval is over.
+	trigger *sqsTrigger
+	// coordinator is the address the queues are served to workers on,
+	// instead of transferring them here, to those sending
+	// coordinatorToken.
+	coordinator      string
+	coordinatorToken string
+	leaseTimeout     time.Duration
+	// destinations holds back the mirrors failing transfers in a row.
+	destinations *destinationHealth
+	// health is what the /healthz and /readyz probes of a daemon report.
+	health *daemonHealth
+	// approval decides on the queue of each job before it is uploaded.
+	approval *changeGate
+	// changelog writes what changed on the destinations since the last
+	// run.
+	changelog *changelog
+	// notifiers send the summary of each run to chat, webhooks or email.
+	notifiers notifiers
+	// confirmOverwrites asks before -force overwrites versions.
+	confirmOverwrites bool
+}
+
+// syncJobs diffs every job, up to parallel at a time, and transfers the
+// missing charts. Outside the transfer window the diffs are queued until
+// it opens. With name windows the charts are diffed and transferred one
+// window after the other.
+func syncJobs(ctx context.Context, jobs []syncJob, opts runOptions) *runSummary {
+	summary := newRunSummary()
+	summary.spokes = newTopologyReport(jobs)
+	windows := opts.nameWindows
+	if len(windows) == 0 {
+		windows = []nameWindow{{}}
+	}
+	checkpoint := newWindowCheckpoint(indexes.dir, jobs, windows)
+	if len(windows) == 1 {
+		checkpoint = nil
+	}
+	done := checkpoint.done()
+	if done >= len(windows) {
+		done = 0
+	}
+	if done > 0 {
+		logf("Resuming at %s, %d of %d name windows were done\n", windows[done], done, len(windows))
+	}
+	for i := done; i < len(windows); i++ {
+		w := windows[i]
+		if len(windows) > 1 {
+			logf("Syncing %s (%d/%d)\n", w, i+1, len(windows))
+		}
+		windowJobs := make([]syncJob, len(jobs))
+		for j, job := range jobs {
+			job.options.NameWindow = w
+			windowJobs[j] = job
+		}
+		if !syncWindow(withNameWindow(ctx, w), windowJobs, w, opts, summary) {
+			return summary
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		checkpoint.save(i + 1)
+	}
+	if ctx.Err() == nil {
+		checkpoint.clear()
+	}
+
+	summary.print(os.Stdout)
+	if opts.summaryFile != "" {
+		if err := summary.writeJSON(opts.summaryFile); err != nil {
+			logln("Failed to write summary", opts.summaryFile, err)
+		}
+	}
+	if opts.events != nil {
+		opts.events.run(summary)
+	}
+	names := make([]string, len(jobs))
+	for i, job := range jobs {
+		names[i] = jobKey(job.source, job.destination)
+	}
+	record := newRunRecord(summary, names, ctx.Err() != nil)
+	if opts.history != nil {
+		if err := opts.history.record(record); err != nil {
+			logln("Failed to record run in history", err)
+		}
+	}
+	opts.notifiers.send(record)
+	if opts.changelog != nil {
+		if err := opts.changelog.write(summary); err != nil {
+			logln("Failed to write changelog", err)
+		}
+	}
+	if opts.quarantine != nil && ctx.Err() == nil {
+		if err := opts.quarantine.save(); err != nil {
+			logln("Failed to save quarantine", err)
+		}
+	}
+	if opts.retries != nil && ctx.Err() == nil {
+		if err := opts.retries.save(); err != nil {
+			logln("Failed to save retry queue", err)
+		}
+	}
+	return summary
+}
+
+// runReporter is jobReporter with the counts of the spoke of job added.
+func runReporter(opts runOptions, job syncJob, summary *runSummary) Reporter {
+	r := jobReporter(opts, job)
+	if summary.spokes == nil || job.spoke == "" {
+		return r
+	}
+	rs := reporters{summary.spokes.reporter(job.spoke)}
+	if r != nil {
+		rs = append(rs, r)
+	}
+	return rs
+}
+
+// syncWindow diffs and transfers the charts of the jobs in name window w
+// into summary, it returns false when ctx was done waiting for the
+// transfer window.
+func syncWindow(ctx context.Context, jobs []syncJob, w nameWindow, opts runOptions, summary *runSummary) bool {
+	planned := planJobs(ctx, jobs, opts.parallel)
+	window := opts.window
+	if now := time.Now(); window != nil && !window.contains(now) {
+		queued := 0
+		for _, job := range planned {
+			queued += len(job.plan.queue)
+		}
+		if queued > 0 {
+			opens := window.opens(now)
+			logln("Queued", queued, "charts until the transfer window opens at", opens.Format(time.RFC1123))
+			if !sleep(ctx, time.Until(opens)) {
+				return false
+			}
+			// The source and destinations moved on while waiting.
+			planned = planJobs(ctx, jobs, opts.parallel)
+		}
+	}
+	opts.changelog.plan(planned)
+	summary.failJobs(len(jobs) - len(planned))
+	summary.spokes.unreachable(jobs, planned)
+	for _, job := range planned {
+		var skipped []syncItem
+		if opts.quarantine != nil {
+			job.plan.queue, skipped = opts.quarantine.filter(fmt.Sprint(job.destination), w, job.plan.queue)
+		}
+		if opts.retries != nil {
+			var held []syncItem
+			job.plan.queue, held = opts.retries.order(fmt.Sprint(job.destination), w, job.plan.queue)
+			skipped = append(skipped, held...)
+		}
+		for _, item := range skipped {
+			summary.skip(item)
+		}
+		summary.examine(len(job.plan.sourceData))
+		summary.conflict(fmt.Sprint(job.destination), job.plan.conflicts)
+		summary.spokes.conflicts(job.spoke, len(job.plan.conflicts))
+	}
+
+	for _, job := range planned {
+		if !opts.approval.review(ctx, job, summary) {
+			job.plan.queue = nil
+		}
+	}
+	if opts.confirmOverwrites {
+		confirmOverwrites(planned, summary)
+	}
+
+	if opts.coordinator != "" {
+		coordinate(ctx, planned, opts, summary)
+		planned = nil
+	}
+	scheduled, backingOff := opts.destinations.schedule(planned)
+	for _, job := range backingOff {
+		summary.reporter = runReporter(opts, job.syncJob, summary)
+		for _, item := range job.plan.queue {
+			summary.skip(item)
+		}
+	}
+	var deferred []plannedJob
+	for _, job := range scheduled {
+		if opts.destinations.held(job.server) {
+			deferred = append(deferred, job)
+			continue
+		}
+		runJob(ctx, job, opts, summary)
+		if opts.destinations.held(job.server) {
+			job.plan.queue = job.plan.held
+			deferred = append(deferred, job)
+			continue
+		}
+		if len(job.plan.held) > 0 {
+			logln("Transfer window closed, leaving", len(job.plan.held), "charts to", redact(job.server), "for the next run")
+		}
+		for _, item := range job.plan.held {
+			summary.skip(item)
+		}
+	}
+	retried := make(map[string]bool)
+	for _, job := range deferred {
+		if !retried[job.server] {
+			logln("Retrying", redact(job.server), "after the other destinations")
+			opts.destinations.retry(job.server)
+			retried[job.server] = true
+		}
+		runJob(ctx, job, opts, summary)
+		for _, item := range job.plan.held {
+			summary.skip(item)
+		}
+	}
+	return true
+}
+
+// runJob transfers the queue of job, holding back the rest once the
+// transfer window closed or its destination failed too often.
+func runJob(ctx context.Context, job plannedJob, opts runOptions, summary *runSummary) {
+	if job.tenant != "" || job.target != "" {
+		logln("Syncing tenant", "/"+job.tenant, "to", "/"+job.target)
+	}
+	summary.reporter = runReporter(opts, job.syncJob, summary)
+	if window := opts.window; window != nil || opts.destinations != nil {
+		job.plan.hold = func() bool {
+			return (window != nil && !window.contains(time.Now())) || opts.destinations.held(job.server)
+		}
+		job.plan.held = nil
+	}
+	job.plan.run(ctx, summary)
+}
+
+// runDaemon syncs the jobs every interval, and on the requests of the
+// trigger, until ctx is done.
+func runDaemon(ctx context.Context, jobs []syncJob, interval time.Duration, opts runOptions) {
+	if opts.trigger != nil {
+		go opts.trigger.listen(ctx)
+	}
+	var requests []string
+	for run := 0; ; run++ {
+		start := time.Now()
+		if run > 0 && requestIDHeader != "" {
+			newRequestID()
+		}
+		for _, job := range jobs {
+			for _, r := range []any{job.source, job.destination} {
+				if s, ok := r.(interface{ refresh() }); ok {
+					s.refresh()
+				}
+			}
+		}
+		opts.health.set("running", time.Time{})
+		summary := syncJobs(ctx, jobs, opts)
+		opts.health.finished(summary, ctx.Err() != nil)
+		if len(requests) > 0 && ctx.Err() == nil {
+			opts.trigger.ack(ctx, requests)
+		}
+		opts.health.set("idle", start.Add(interval))
+		var ok bool
+		if requests, ok = waitForRun(ctx, start.Add(interval), opts.trigger); !ok {
+			return
+		}
+	}
+}
+
+// waitForRun waits until next or a sync request on trigger, whose receipt
+// handles it returns. It returns false when ctx is done first.
+func waitForRun(ctx context.Context, next time.Time, trigger *sqsTrigger) ([]string, bool) {
+	if trigger == nil {
+		return nil, sleep(ctx, time.Until(next))
+	}
+	t := time.NewTimer(time.Until(next))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil, true
+	case requests := <-trigger.requests:
+		logln("Sync requested on", trigger)
+		return requests, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// sleep waits for d, it returns false when ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
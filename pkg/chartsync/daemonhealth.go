@@ -0,0 +1,141 @@
+package chartsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// daemonHealth tracks what the scheduler of a daemon is doing and how its
+// last run went, for the /healthz and /readyz probes of Kubernetes.
+// Its methods do nothing on a nil daemonHealth.
+type daemonHealth struct {
+	mu sync.Mutex
+	// stall is how long a run may take or a wait may be overdue before
+	// the daemon counts as wedged.
+	stall  time.Duration
+	budget float64
+
+	state string
+	since time.Time
+	// until is when the current wait is over, zero while running.
+	until time.Time
+
+	runs int
+	last *healthRun
+}
+
+// healthRun is how a finished run went.
+type healthRun struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Synced      int       `json:"synced"`
+	Failed      int       `json:"failed"`
+	JobsFailed  int       `json:"jobs_failed,omitempty"`
+	Interrupted bool      `json:"interrupted,omitempty"`
+}
+
+// newDaemonHealth returns the health of a daemon whose runs are wedged
+// after stall, and not ready above budget failed versions, -1 for none.
+func newDaemonHealth(stall time.Duration, budget float64) *daemonHealth {
+	return &daemonHealth{stall: stall, budget: budget, state: "starting", since: time.Now()}
+}
+
+// set moves the scheduler to state, a wait ending at until or a run when
+// until is zero.
+func (h *daemonHealth) set(state string, until time.Time) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.state, h.since, h.until = state, time.Now(), until
+}
+
+// finished records the summary of a run.
+func (h *daemonHealth) finished(s *runSummary, interrupted bool) {
+	if h == nil {
+		return
+	}
+	s.mu.Lock()
+	run := &healthRun{Start: s.start, End: time.Now(), Synced: s.synced, Failed: len(s.failures), JobsFailed: s.jobsFailed, Interrupted: interrupted}
+	s.mu.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.runs++
+	h.last = run
+}
+
+// live is false, with the reason, once the scheduler is stuck in a run or
+// a wait for longer than it should be.
+func (h *daemonHealth) live(now time.Time) (bool, string) {
+	switch {
+	case h.until.IsZero() && now.Sub(h.since) > h.stall:
+		return false, fmt.Sprintf("%s for %s", h.state, now.Sub(h.since).Round(time.Second))
+	case !h.until.IsZero() && now.Sub(h.until) > h.stall:
+		return false, fmt.Sprintf("%s, overdue by %s", h.state, now.Sub(h.until).Round(time.Second))
+	}
+	return true, ""
+}
+
+// ready is false, with the reason, until a run finished and while the
+// last one failed jobs or more versions than the budget.
+func (h *daemonHealth) ready() (bool, string) {
+	switch r := h.last; {
+	case r == nil:
+		return false, "no run finished yet"
+	case r.Interrupted:
+		return false, "the last run was interrupted"
+	case r.JobsFailed > 0:
+		return false, fmt.Sprintf("%d jobs of the last run couldn't be diffed", r.JobsFailed)
+	case h.budget >= 0 && r.Synced+r.Failed > 0 && float64(r.Failed)/float64(r.Synced+r.Failed) > h.budget:
+		return false, fmt.Sprintf("%d of %d versions of the last run failed", r.Failed, r.Synced+r.Failed)
+	}
+	return true, ""
+}
+
+// serve answers /healthz and /readyz on addr, 200 when live or ready and
+// 503 otherwise, with the state as json.
+func (h *daemonHealth) serve(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	probe := func(check func(*daemonHealth) (bool, string)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			h.mu.Lock()
+			ok, reason := check(h)
+			status := struct {
+				OK      bool       `json:"ok"`
+				Reason  string     `json:"reason,omitempty"`
+				State   string     `json:"state"`
+				Since   time.Time  `json:"since"`
+				Until   *time.Time `json:"until,omitempty"`
+				Runs    int        `json:"runs"`
+				LastRun *healthRun `json:"last_run,omitempty"`
+			}{ok, reason, h.state, h.since, nil, h.runs, h.last}
+			if !h.until.IsZero() {
+				until := h.until
+				status.Until = &until
+			}
+			h.mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			if !ok {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			json.NewEncoder(w).Encode(status)
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", probe(func(h *daemonHealth) (bool, string) { return h.live(time.Now()) }))
+	mux.Handle("/readyz", probe((*daemonHealth).ready))
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			logln("Failed to serve health probes on", addr, err)
+		}
+	}()
+	return nil
+}
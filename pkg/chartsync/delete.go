@@ -0,0 +1,124 @@
+package chartsync
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+)
+
+// deleteURL is where a version is deleted with the server's own api,
+// chartmuseum, harbor and artifactory support it. Plugged repositories
+// that can delete have no url, deleteChart calls their Delete.
+func deleteURL(dst chartDestination, chart, version string) (repo, string, error) {
+	switch d := dst.(type) {
+	case repo:
+		return d, d.apiURL() + "/" + escapePath(chart) + "/" + escapePath(version), nil
+	case harborRepo:
+		return d.repo, d.apiURL() + "/" + escapePath(chart) + "/" + escapePath(version), nil
+	case *artifactoryRepo:
+		return d.repo, d.artifactURL(chart, version), nil
+	case *pluggedRepository:
+		if d.Capabilities().Delete {
+			return repo{}, "", nil
+		}
+	}
+	return repo{}, "", fmt.Errorf("deleting charts from %s isn't supported", dst)
+}
+
+func deleteChart(ctx context.Context, dst chartDestination, chart, version string) error {
+	if p, ok := dst.(*pluggedRepository); ok {
+		return p.Delete(ctx, chart, version)
+	}
+	r, u, err := deleteURL(dst, chart, version)
+	if err != nil {
+		return err
+	}
+	req, err := r.newRequest(ctx, "DELETE", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := r.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		return unexpectedStatus(resp.StatusCode)
+	}
+	return nil
+}
+
+// runDelete removes chart versions from a destination, with the auth and
+// config of a sync, after asking for confirmation.
+func runDelete(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	cf := addDestinationFlags(fs)
+	tenant := fs.String("tenant", "", "org/repo path of the chart on a multitenant chartmuseum")
+	allVersions := fs.Bool("all-versions", false, "delete every version of the chart")
+	dryRun := fs.Bool("dry-run", false, "only list the versions that would be deleted")
+	yes := fs.Bool("yes", false, "don't ask for confirmation")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cm_sync delete [flags] CHART VERSION..., or delete [flags] -all-versions CHART")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() == 1 && !*allVersions || fs.NArg() > 1 && *allVersions {
+		fs.Usage()
+		os.Exit(1)
+	}
+	cfg := cf.config(fs)
+	dst, data := openDestination(ctx, cfg, normalizeTenant(*tenant))
+	if _, _, err := deleteURL(dst, "", ""); err != nil {
+		logln("Error deleting charts:", err)
+		os.Exit(1)
+	}
+
+	chart := fs.Arg(0)
+	versions := fs.Args()[1:]
+	if *allVersions {
+		for _, cv := range data[chart] {
+			versions = append(versions, cv.Version)
+		}
+		sort.Slice(versions, func(i, j int) bool { return newerVersion(versions[i], versions[j]) })
+	}
+	if len(versions) == 0 {
+		logln("Error finding chart:", chart, "not found in", dst)
+		os.Exit(1)
+	}
+	for _, v := range versions {
+		if !hasVersion(data, chart, v) {
+			logln("Error finding chart:", chart+"-"+v, "not found in", dst)
+			os.Exit(1)
+		}
+	}
+
+	if *dryRun {
+		for _, v := range versions {
+			outf("Would delete %s-%s from %s\n", chart, v, dst)
+		}
+		return
+	}
+	var changes []string
+	for _, v := range versions {
+		changes = append(changes, chart+"-"+v)
+	}
+	if !confirmChanges(*yes, fmt.Sprintf("Delete %d versions of %s from %s?", len(versions), chart, dst), changes) {
+		logln("Nothing deleted")
+		os.Exit(1)
+	}
+	failed := false
+	for _, v := range versions {
+		if err := deleteChart(ctx, dst, chart, v); err != nil {
+			logf("Failed to delete %s-%s from %s %v\n", chart, v, dst, err)
+			failed = true
+			continue
+		}
+		logf("Deleted %s-%s from %s\n", chart, v, dst)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
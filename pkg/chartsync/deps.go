@@ -0,0 +1,172 @@
+package chartsync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+	"gopkg.in/yaml.v3"
+)
+
+type depResolver struct {
+	mu sync.Mutex
+	// client is the source's repo, its credentials are only sent to
+	// dependency repositories on the source server.
+	client     repo
+	sourceURL  string
+	sourceData ChartData
+	destData   ChartData
+	indexes    map[string]ChartData
+	queued     map[string]struct{}
+}
+
+func newDepResolver(source chartSource, sourceData, destData ChartData, diff map[string][]string) *depResolver {
+	r := &depResolver{
+		sourceURL:  strings.TrimSuffix(source.String(), "/"),
+		sourceData: sourceData,
+		destData:   destData,
+		indexes:    make(map[string]ChartData),
+		queued:     make(map[string]struct{}),
+	}
+	if src, ok := source.(interface{ base() repo }); ok {
+		r.client = src.base()
+	}
+	for chart, versions := range diff {
+		for _, v := range versions {
+			r.queued[chart+"-"+v] = struct{}{}
+		}
+	}
+	return r
+}
+
+// chartDependencies reads the dependencies declared in a packaged chart's
+// Chart.yaml, or requirements.yaml for apiVersion v1 charts.
+func chartDependencies(r io.Reader) ([]ChartDependency, error) {
+	files, err := chartFiles(r, "Chart.yaml", "requirements.yaml")
+	if err != nil {
+		return nil, err
+	}
+	var deps []ChartDependency
+	for _, name := range []string{"Chart.yaml", "requirements.yaml"} {
+		if files[name] == nil {
+			continue
+		}
+		var meta struct {
+			Dependencies []ChartDependency `yaml:"dependencies"`
+		}
+		if err := yaml.Unmarshal(files[name], &meta); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", name, err)
+		}
+		deps = append(deps, meta.Dependencies...)
+	}
+	return deps, nil
+}
+
+// index is the index of repo, fetched once without holding mu so charts
+// whose dependencies are known don't wait for a slow repository.
+func (r *depResolver) index(ctx context.Context, repo string) (ChartData, error) {
+	if repo == r.sourceURL {
+		return r.sourceData, nil
+	}
+	r.mu.Lock()
+	data, ok := r.indexes[repo]
+	r.mu.Unlock()
+	if ok {
+		return data, nil
+	}
+	data, err := fetchIndex(ctx, r.clientFor(repo), repo)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if cached, ok := r.indexes[repo]; ok {
+		return cached, nil
+	}
+	r.indexes[repo] = data
+	return data, nil
+}
+
+// resolve picks the newest version of dep that satisfies its constraint and
+// returns it as a sync item, unless the destination already has it or it was
+// queued before. Dependencies without a reachable repository are skipped.
+func (r *depResolver) resolve(ctx context.Context, dep ChartDependency) (*syncItem, error) {
+	repo := strings.TrimSuffix(dep.Repository, "/")
+	if repo == "" || strings.HasPrefix(repo, "file://") {
+		return nil, nil
+	}
+	if !strings.HasPrefix(repo, "http://") && !strings.HasPrefix(repo, "https://") {
+		return nil, fmt.Errorf("repository %q is not reachable by url", dep.Repository)
+	}
+
+	data, err := r.index(ctx, repo)
+	if err != nil {
+		return nil, fmt.Errorf("fetching index of %s: %w", repo, err)
+	}
+
+	c := dep.Version
+	if c == "" {
+		c = "*"
+	}
+	constraint, err := semver.NewConstraint(c)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version constraint %q: %w", dep.Version, err)
+	}
+
+	var best *ChartVersion
+	var bestVersion *semver.Version
+	for i, cv := range data[dep.Name] {
+		v, err := semver.NewVersion(cv.Version)
+		if err != nil || !constraint.Check(v) {
+			continue
+		}
+		if bestVersion == nil || v.GreaterThan(bestVersion) {
+			best, bestVersion = &data[dep.Name][i], v
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no version of %s in %s matches %q", dep.Name, repo, dep.Version)
+	}
+
+	key := dep.Name + "-" + best.Version
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.queued[key]; ok {
+		return nil, nil
+	}
+	for _, cv := range r.destData[dep.Name] {
+		if cv.Version == best.Version {
+			return nil, nil
+		}
+	}
+	r.queued[key] = struct{}{}
+
+	item := &syncItem{Chart: dep.Name, Version: best.Version}
+	if repo != r.sourceURL {
+		if len(best.URLs) == 0 {
+			return nil, fmt.Errorf("index of %s has no url for %s", repo, key)
+		}
+		item.URL, err = resolveRef(repo, best.URLs[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return item, nil
+}
+
+func (r *depResolver) open(ctx context.Context, u string) (io.ReadCloser, int64, error) {
+	return openURL(ctx, r.clientFor(u), u)
+}
+
+// clientFor is the repo fetching u. Dependency repositories come from the
+// Chart.yaml of whoever pushed the chart, those not on the source server
+// are fetched without credentials.
+func (r *depResolver) clientFor(u string) repo {
+	if r.client.server != "" && sameServer(u, r.client.server) {
+		return r.client
+	}
+	return repo{client: r.client.client}
+}
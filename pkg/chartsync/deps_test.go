@@ -0,0 +1,26 @@
+package chartsync
+
+import "testing"
+
+func TestDepResolverClientFor(t *testing.T) {
+	source := repo{server: "https://charts.example.com", auth: credentials{Username: "user", Password: "secret"}}
+	r := &depResolver{client: source}
+	tests := []struct {
+		u    string
+		want bool
+	}{
+		{"https://charts.example.com/charts/redis-1.0.0.tgz", true},
+		{"https://charts.example.com.evil.net/index.yaml", false},
+		{"https://charts.example.com@evil/index.yaml", false},
+		{"https://charts.bitnami.com/bitnami/index.yaml", false},
+	}
+	for _, tt := range tests {
+		got := r.clientFor(tt.u).auth.Username != ""
+		if got != tt.want {
+			t.Errorf("clientFor(%q) has credentials %v, want %v", tt.u, got, tt.want)
+		}
+	}
+	if (&depResolver{}).clientFor("https://charts.example.com/index.yaml").server != "" {
+		t.Error("clientFor without a source repo should be unauthenticated")
+	}
+}
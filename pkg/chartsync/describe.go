@@ -0,0 +1,135 @@
+package chartsync
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// findVersion returns version of chart in data, the newest one when
+// version is empty.
+func findVersion(data ChartData, chart, version string) (ChartVersion, error) {
+	versions := append([]ChartVersion(nil), data[chart]...)
+	if len(versions) == 0 {
+		return ChartVersion{}, fmt.Errorf("chart %s not found", chart)
+	}
+	sortVersions(versions)
+	if version == "" {
+		return versions[0], nil
+	}
+	for _, cv := range versions {
+		if cv.Version == version {
+			return cv, nil
+		}
+	}
+	return ChartVersion{}, fmt.Errorf("version %s of chart %s not found", version, chart)
+}
+
+// printChartVersion writes the index metadata of cv as aligned fields,
+// empty ones are left out.
+func printChartVersion(w io.Writer, cv ChartVersion) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(tw, "%s:\t%s\n", name, value)
+		}
+	}
+	field("Name", cv.Name)
+	field("Version", cv.Version)
+	field("App version", cv.AppVersion)
+	field("API version", cv.APIVersion)
+	field("Type", cv.Type)
+	field("Kube version", cv.KubeVersion)
+	field("Description", cv.Description)
+	field("Home", cv.Home)
+	field("Icon", cv.Icon)
+	field("Keywords", strings.Join(cv.Keywords, ", "))
+	field("Sources", strings.Join(cv.Sources, ", "))
+	for _, m := range cv.Maintainers {
+		maintainer := m.Name
+		if m.Email != "" {
+			maintainer += " <" + m.Email + ">"
+		}
+		if m.URL != "" {
+			maintainer += " " + m.URL
+		}
+		field("Maintainer", maintainer)
+	}
+	if cv.Deprecated {
+		field("Deprecated", "yes")
+	}
+	field("Digest", cv.Digest)
+	if !cv.Created.IsZero() {
+		field("Created", cv.Created.Format("2006-01-02 15:04:05 MST"))
+	}
+	for _, u := range cv.URLs {
+		field("URL", u)
+	}
+	for _, d := range cv.Dependencies {
+		dep := d.Name + " " + d.Version
+		if d.Repository != "" {
+			dep += " from " + d.Repository
+		}
+		field("Dependency", dep)
+	}
+	for _, k := range slices.Sorted(maps.Keys(cv.Annotations)) {
+		field("Annotation", k+"="+cv.Annotations[k])
+	}
+	return tw.Flush()
+}
+
+// runDescribe prints the index metadata of one chart version, the newest
+// when no version is given.
+func runDescribe(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("describe", flag.ExitOnError)
+	sf := addSourceFlags(fs)
+	format := fs.String("o", "text", "output format, text, json or yaml")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cm_sync describe [flags] CHART [VERSION]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *format != "text" && *format != "json" && *format != "yaml" {
+		logln("Error parsing -o: unknown output format", *format+", use text, json or yaml")
+		os.Exit(1)
+	}
+	cfg := sf.config(fs)
+	_, data := openSource(ctx, cfg, "")
+
+	cv, err := findVersion(data, fs.Arg(0), fs.Arg(1))
+	if err != nil {
+		logln("Error finding chart:", err)
+		os.Exit(1)
+	}
+	switch *format {
+	case "text":
+		err = printChartVersion(os.Stdout, cv)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(cv)
+	case "yaml":
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err = enc.Encode(cv); err == nil {
+			err = enc.Close()
+		}
+	}
+	if err != nil {
+		logln("Error writing chart:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,133 @@
+package chartsync
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// tripFailures is the number of transfers in a row a destination fails
+// before it is moved behind the healthy ones.
+const tripFailures = 5
+
+// destinationHealth tracks the failures of each destination a source is
+// mirrored into. A destination failing tripFailures transfers in a row is
+// held back for the rest of its queue, which is retried once after the
+// other destinations, and skipped by the runs of a daemon for a backoff
+// starting at 5m and doubling up to an hour. Its methods do nothing on a
+// nil destinationHealth.
+type destinationHealth struct {
+	mu      sync.Mutex
+	servers map[string]*serverHealth
+}
+
+type serverHealth struct {
+	// failing is the number of transfers failed in a row.
+	failing int
+	tripped bool
+	until   time.Time
+	backoff time.Duration
+	// failures are all failed transfers of the last run, to order the
+	// next one by.
+	failures int
+}
+
+func newDestinationHealth() *destinationHealth {
+	return &destinationHealth{servers: make(map[string]*serverHealth)}
+}
+
+func (h *destinationHealth) server(server string) *serverHealth {
+	s, ok := h.servers[server]
+	if !ok {
+		s = &serverHealth{}
+		h.servers[server] = s
+	}
+	return s
+}
+
+// schedule orders the jobs of a run, those of the destinations that failed
+// least in the last run first, and returns those still backing off apart.
+func (h *destinationHealth) schedule(jobs []plannedJob) (scheduled, backingOff []plannedJob) {
+	if h == nil {
+		return jobs, nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, job := range jobs {
+		s := h.server(job.server)
+		if time.Now().Before(s.until) {
+			logf("Skipping %s until %s, it failed %d transfers in a row\n", redact(job.server), s.until.Format(time.RFC1123), tripFailures)
+			backingOff = append(backingOff, job)
+			continue
+		}
+		scheduled = append(scheduled, job)
+	}
+	sort.SliceStable(scheduled, func(i, j int) bool {
+		return h.server(scheduled[i].server).failures < h.server(scheduled[j].server).failures
+	})
+	for _, s := range h.servers {
+		s.failing, s.tripped, s.failures = 0, false, 0
+	}
+	return scheduled, backingOff
+}
+
+// held is true once server is held back for the rest of the pass.
+func (h *destinationHealth) held(server string) bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.server(server).tripped
+}
+
+// retry gives server another pass after the healthy destinations.
+func (h *destinationHealth) retry(server string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.server(server)
+	s.failing, s.tripped = 0, false
+}
+
+// reporter counts the outcomes of the transfers to server.
+func (h *destinationHealth) reporter(server string) Reporter {
+	return serverReporter{h, server}
+}
+
+type serverReporter struct {
+	health *destinationHealth
+	server string
+}
+
+func (r serverReporter) Synced(Version, int64) {
+	r.health.mu.Lock()
+	defer r.health.mu.Unlock()
+	s := r.health.server(r.server)
+	s.failing = 0
+	if !s.tripped {
+		s.backoff, s.until = 0, time.Time{}
+	}
+}
+
+func (r serverReporter) Skipped(Version) {}
+
+// Failed counts the failures of the destination and timed out transfers,
+// a source that can't serve a chart says nothing about the destination.
+func (r serverReporter) Failed(_ Version, err error) {
+	var side sideError
+	if !(errors.As(err, &side) && side.side == "DEST") && ErrorCode(err) != "E_CHART_TIMEOUT" {
+		return
+	}
+	r.health.mu.Lock()
+	defer r.health.mu.Unlock()
+	s := r.health.server(r.server)
+	s.failures++
+	if s.failing++; s.failing < tripFailures || s.tripped {
+		return
+	}
+	s.tripped = true
+	s.backoff = min(max(2*s.backoff, 5*time.Minute), time.Hour)
+	s.until = time.Now().Add(s.backoff)
+	logf("Holding back %s, it failed %d transfers in a row\n", redact(r.server), s.failing)
+}
@@ -0,0 +1,223 @@
+package chartsync
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+)
+
+// diffCharts compares the source with opts applied with the destination.
+func diffCharts(sourceData, destData ChartData, opts syncOptions) Diff {
+	filtered := applyPolicy(sourceData, opts)
+	versions := func(items []syncItem) []Version {
+		list := []Version{}
+		for _, item := range items {
+			list = append(list, Version{item.Chart, item.Version})
+		}
+		return list
+	}
+	return Diff{
+		Missing: versions(sortedItems(compareCharts(filtered, destData))),
+		Extra:   versions(pruneItems(sourceData, destData, opts)),
+		Changed: versions(sortedItems(changedCharts(filtered, destData))),
+	}
+}
+
+// runDiff lists how the destination differs from the source: versions a
+// sync would add (+), versions only the destination has (-) and versions
+// whose digests differ (~), the conflicts among them with both digests.
+// With -source-index and -dest-index it works
+// from saved chart lists, for change reviews without network access.
+// With -mirrors the source is the source of truth that every replica is
+// compared against at once, see diffReplicas.
+func runDiff(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	cf := newCommandFlags(fs, true, true)
+	tenant := fs.String("tenant", "", "org/repo path to compare on multitenant chartmuseums")
+	exitCode := fs.Bool("exit-code", false, "exit with 1 when there are differences")
+	mirrors := fs.String("mirrors", "", "comma separated urls of more replicas compared against the source together with -d")
+	format := fs.String("o", "text", "output format, text or json")
+	fs.StringVar(format, "format", "text", "same as -o")
+	fs.Parse(args)
+	cfg := cf.config(fs)
+	if *format != "text" && *format != "json" {
+		logf("Error: -o is text or json, not %q\n", *format)
+		os.Exit(1)
+	}
+	if *mirrors != "" {
+		cfg.Mirrors = splitList(*mirrors)
+		if diffReplicas(ctx, cfg, normalizeTenant(*tenant)) && *exitCode {
+			os.Exit(1)
+		}
+		return
+	}
+
+	_, sourceData := openSource(ctx, cfg, normalizeTenant(*tenant))
+	_, destData := openDestination(ctx, cfg, normalizeTenant(*tenant))
+	diff := diffCharts(sourceData, destData, cfg.syncOptions)
+	conflicts := findConflicts(applyPolicy(sourceData, cfg.syncOptions), destData)
+	differs := len(diff.Missing)+len(diff.Extra)+len(diff.Changed) > 0
+	if *format == "json" {
+		out := diffJSON{
+			Missing:   diffVersions(diff.Missing, sourceData),
+			Extra:     diffVersions(diff.Extra, destData),
+			Changed:   diffVersions(diff.Changed, sourceData),
+			Conflicts: conflicts,
+		}
+		if out.Conflicts == nil {
+			out.Conflicts = []conflict{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(out)
+		if differs && *exitCode {
+			os.Exit(1)
+		}
+		return
+	}
+	for _, v := range diff.Missing {
+		outf("+ %s-%s\n", v.Chart, v.Version)
+	}
+	for _, v := range diff.Extra {
+		outf("- %s-%s\n", v.Chart, v.Version)
+	}
+	for _, v := range diff.Changed {
+		outf("~ %s-%s\n", v.Chart, v.Version)
+	}
+	printConflicts(os.Stdout, conflicts)
+	if !differs {
+		outln("No differences")
+		return
+	}
+	outf("%d to add, %d only in the destination, %d changed\n", len(diff.Missing), len(diff.Extra), len(diff.Changed))
+	if *exitCode {
+		os.Exit(1)
+	}
+}
+
+// diffJSON is the output of diff -o json.
+type diffJSON struct {
+	Missing   []changeVersion `json:"missing"`
+	Extra     []changeVersion `json:"extra"`
+	Changed   []changeVersion `json:"changed"`
+	Conflicts []conflict      `json:"conflicts"`
+}
+
+// diffVersions lists versions with their digests in data.
+func diffVersions(versions []Version, data ChartData) []changeVersion {
+	list := []changeVersion{}
+	for _, v := range versions {
+		list = append(list, changeVersion{v.Chart, v.Version, indexDigest(data, v.Chart, v.Version)})
+	}
+	return list
+}
+
+// replicaStatus is how a replica differs from the source for a version.
+type replicaStatus string
+
+const (
+	replicaOK       replicaStatus = "ok"
+	replicaMissing  replicaStatus = "missing"
+	replicaExtra    replicaStatus = "extra"
+	replicaChanged  replicaStatus = "changed"
+	replicaConflict replicaStatus = "conflict"
+)
+
+// diffReplicas fetches the source and the -d and -mirrors replicas in
+// parallel and prints a table of the versions any replica diverges on,
+// with the status on each, and a count per replica. It returns whether
+// any replica differs.
+func diffReplicas(ctx context.Context, cfg *config, tenant string) bool {
+	replicas := []*config{cfg}
+	for _, m := range cfg.Mirrors {
+		replicas = append(replicas, cfg.mirror(m))
+	}
+	var sourceData ChartData
+	data := make([]ChartData, len(replicas))
+	src := *cfg
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, sourceData = openSource(ctx, &src, tenant)
+	}()
+	for i, r := range replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, data[i] = openDestination(ctx, r, tenant)
+		}()
+	}
+	wg.Wait()
+
+	statuses := make(map[Version][]replicaStatus)
+	status := func(v Version, i int, s replicaStatus) {
+		if statuses[v] == nil {
+			statuses[v] = make([]replicaStatus, len(replicas))
+			for j := range statuses[v] {
+				statuses[v][j] = replicaOK
+			}
+		}
+		statuses[v][i] = s
+	}
+	differs := false
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	var counts []string
+	for i, destData := range data {
+		diff := diffCharts(sourceData, destData, cfg.syncOptions)
+		for _, v := range diff.Missing {
+			status(v, i, replicaMissing)
+		}
+		for _, v := range diff.Extra {
+			status(v, i, replicaExtra)
+		}
+		for _, v := range diff.Changed {
+			status(v, i, replicaChanged)
+		}
+		for _, c := range findConflicts(applyPolicy(sourceData, cfg.syncOptions), destData) {
+			status(Version{c.Chart, c.Version}, i, replicaConflict)
+		}
+		differs = differs || len(diff.Missing)+len(diff.Extra)+len(diff.Changed) > 0
+		counts = append(counts, fmt.Sprintf("%s\t%d to add, %d only in the replica, %d changed", replicas[i].Destination, len(diff.Missing), len(diff.Extra), len(diff.Changed)))
+	}
+	if !differs {
+		outln("No differences")
+		return false
+	}
+
+	versions := make([]Version, 0, len(statuses))
+	for v := range statuses {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].Chart != versions[j].Chart {
+			return versions[i].Chart < versions[j].Chart
+		}
+		return newerVersion(versions[i].Version, versions[j].Version)
+	})
+	fmt.Fprint(tw, "VERSION")
+	for _, r := range replicas {
+		fmt.Fprint(tw, "\t", redact(r.Destination))
+	}
+	fmt.Fprintln(tw)
+	for _, v := range versions {
+		fmt.Fprint(tw, v.Chart+"-"+v.Version)
+		for _, s := range statuses[v] {
+			fmt.Fprint(tw, "\t", s)
+		}
+		fmt.Fprintln(tw)
+	}
+	tw.Flush()
+	fmt.Fprintln(os.Stdout)
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, c := range counts {
+		fmt.Fprintln(tw, redact(c))
+	}
+	tw.Flush()
+	return true
+}
@@ -0,0 +1,96 @@
+package chartsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// dirStore is a helm repository in a local directory.
+type dirStore struct {
+	dir string
+}
+
+func newDirStore(ref, tenant string) (*dirStore, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host != "" && u.Host != "localhost" {
+		return nil, fmt.Errorf("file urls must be local, got host %q", u.Host)
+	}
+	return &dirStore{dir: filepath.Join(filepath.FromSlash(u.Path), filepath.FromSlash(tenant))}, nil
+}
+
+func (d *dirStore) localDir() string {
+	return d.dir
+}
+
+func (d *dirStore) String() string {
+	return "file://" + filepath.ToSlash(d.dir)
+}
+
+func (d *dirStore) ping(ctx context.Context) error {
+	info, err := os.Stat(d.dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", d.dir)
+	}
+	return nil
+}
+
+func (d *dirStore) list(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+func (d *dirStore) read(ctx context.Context, name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(d.dir, filepath.FromSlash(name)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errNotExist
+	}
+	return data, err
+}
+
+// write replaces files through a rename, so a web server in front of the
+// directory never serves a half written index or chart.
+func (d *dirStore) write(ctx context.Context, name string, r io.Reader, size int64) error {
+	path := filepath.Join(d.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,136 @@
+package chartsync
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+)
+
+// chartSizer is a source that can tell the size of a chart without
+// downloading it.
+type chartSizer interface {
+	chartSize(ctx context.Context, chart, version string) (int64, error)
+}
+
+func (r repo) chartSize(ctx context.Context, chart, version string) (int64, error) {
+	return headSize(ctx, r, downloadURL(r.url(), chart, version))
+}
+
+func (s *staticRepo) chartSize(ctx context.Context, chart, version string) (int64, error) {
+	s.mu.Lock()
+	u, err := indexChartURL(s.index, s.url(), chart, version)
+	s.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	return headSize(ctx, s.repo, u)
+}
+
+// headSize returns the Content-Length the server announces for u.
+func headSize(ctx context.Context, r repo, u string) (int64, error) {
+	req, err := r.newRequest(ctx, "HEAD", u, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := r.do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, unexpectedStatus(resp.StatusCode)
+	}
+	if resp.ContentLength < 0 {
+		return 0, fmt.Errorf("%s has no Content-Length", u)
+	}
+	return resp.ContentLength, nil
+}
+
+// chartSizes asks sizer for the size of each queued chart, up to workers
+// at a time. Sizes it can't tell are -1.
+func chartSizes(ctx context.Context, sizer chartSizer, queue []syncItem, workers int) []int64 {
+	sizes := make([]int64, len(queue))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(workers, 1))
+	for i, item := range queue {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			size, err := sizer.chartSize(ctx, item.Chart, item.Version)
+			if err != nil {
+				size = -1
+			}
+			sizes[i] = size
+		}()
+	}
+	wg.Wait()
+	return sizes
+}
+
+// checkDiskSpace estimates the space the queued charts take on local disk,
+// all of them in a file or git destination and the largest ones being
+// transferred at once in the spool dir, and fails if it isn't free. Charts
+// the source can't tell the size of are left out.
+func checkDiskSpace(dst chartDestination, queue []syncItem, sizes []int64, workers int, spooled bool) error {
+	localDir := ""
+	if s, ok := dst.(*storeRepo); ok {
+		if d, ok := s.store.(interface{ localDir() string }); ok {
+			localDir = d.localDir()
+		}
+	}
+	spooling := spooled && spoolLimit > 0
+	if sizes == nil || len(queue) == 0 || localDir == "" && !spooling {
+		return nil
+	}
+
+	sizes = append([]int64(nil), sizes...)
+	for i, size := range sizes {
+		sizes[i] = max(size, 0)
+	}
+
+	need := make(map[string]int64)
+	if localDir != "" {
+		for _, size := range sizes {
+			need[localDir] += size
+		}
+	}
+	if spooling {
+		dir := spoolDir
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		sort.Slice(sizes, func(i, j int) bool { return sizes[i] > sizes[j] })
+		for _, size := range sizes[:min(workers, len(sizes))] {
+			if size > spoolLimit {
+				need[dir] += size
+			}
+		}
+	}
+	for dir, n := range need {
+		free, err := freeSpace(dir)
+		if err != nil || free < 0 {
+			continue
+		}
+		if n > free {
+			return fmt.Errorf("%s needs about %s free for %d charts, only %s are available", dir, formatSize(n), len(queue), formatSize(free))
+		}
+	}
+	return nil
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fG", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fM", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fK", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package chartsync
+
+// freeSpace is unknown on this platform, the check is skipped.
+func freeSpace(dir string) (int64, error) {
+	return -1, nil
+}
@@ -0,0 +1,9 @@
+// Package chartsync syncs helm charts between chart repositories. It is
+// the library behind the cm_sync command: a Client is any source or
+// destination cm_sync can talk to, a Differ compares two of them and a
+// Syncer transfers the versions the destination is missing.
+//
+//	src := chartsync.NewClient("https://charts.example.com", chartsync.ClientOptions{})
+//	dst := chartsync.NewClient("s3://mirror/charts", chartsync.ClientOptions{})
+//	summary, err := (&chartsync.Syncer{Options: chartsync.Options{Concurrency: 4}}).Sync(ctx, src, dst)
+package chartsync
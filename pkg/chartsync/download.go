@@ -0,0 +1,136 @@
+package chartsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// saveChart downloads a chart into dir, through a temporary file that is
+// only renamed into place once the download is complete and matches the
+// digest from the index. Names and versions come from the source index,
+// those that would leave dir are refused.
+func saveChart(ctx context.Context, src chartSource, chart, version, digest, dir string) (string, error) {
+	if strings.ContainsAny(chart+version, "/\\") || strings.HasPrefix(chart, ".") {
+		return "", fmt.Errorf("invalid chart file name %s-%s.tgz", chart, version)
+	}
+	var body io.Reader
+	if s, ok := src.(chartStreamer); ok {
+		rc, size, err := s.openChart(ctx, chart, version)
+		if err != nil {
+			return "", err
+		}
+		defer rc.Close()
+		if body, err = limitChart(rc, size); err != nil {
+			return "", err
+		}
+	} else {
+		data, err := src.fetchChart(ctx, chart, version)
+		if err != nil {
+			return "", err
+		}
+		if body, err = limitChart(bytes.NewReader(data), int64(len(data))); err != nil {
+			return "", err
+		}
+	}
+
+	name := filepath.Join(dir, chart+"-"+version+".tgz")
+	f, err := os.CreateTemp(dir, ".cm_sync-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, h), body)
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); digest != "" && sum != digest {
+		return "", corruptf("checksum mismatch, index %s download %s", digest, sum)
+	}
+	return name, os.Rename(f.Name(), name)
+}
+
+// runDownload saves chart tarballs and their .prov files to a local
+// directory, for offline inspection or distribution by hand.
+func runDownload(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	cf := addSourceFlags(fs)
+	out := fs.String("o", ".", "directory to save the charts in")
+	tenant := fs.String("tenant", "", "org/repo path of the charts on a multitenant source")
+	maxChartSizeFlag := fs.String("max-chart-size", "", "refuse charts larger than this (e.g. 100M), unlimited if empty")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cm_sync download [flags] CHART[@VERSION]..., the newest version when none is given")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	cfg := cf.config(fs)
+	if *maxChartSizeFlag != "" {
+		cfg.MaxChartSize = *maxChartSizeFlag
+	}
+	if err := setMaxChartSize(cfg.MaxChartSize); err != nil {
+		logln("Error parsing -max-chart-size:", err)
+		os.Exit(1)
+	}
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		logln("Error creating", *out, "\n", err)
+		os.Exit(1)
+	}
+	src, data := openSource(ctx, cfg, normalizeTenant(*tenant))
+
+	failed := false
+	for _, arg := range fs.Args() {
+		chart, version, _ := strings.Cut(arg, "@")
+		cv, err := findVersion(data, chart, version)
+		if err != nil {
+			logln("Failed to download", arg, err)
+			failed = true
+			continue
+		}
+		name, err := saveChart(ctx, src, cv.Name, cv.Version, cv.Digest, *out)
+		if err != nil {
+			logf("Failed to download %s-%s from %s %v\n", cv.Name, cv.Version, src, err)
+			failed = true
+			continue
+		}
+		logln("Saved", name)
+
+		p, ok := src.(interface {
+			fetchProvenance(ctx context.Context, chart, version string) ([]byte, error)
+		})
+		if !ok {
+			continue
+		}
+		prov, err := p.fetchProvenance(ctx, cv.Name, cv.Version)
+		if err == nil && prov != nil {
+			err = os.WriteFile(name+".prov", prov, 0644)
+			if err == nil {
+				logln("Saved", name+".prov")
+			}
+		}
+		if err != nil {
+			logf("Failed to download provenance of %s-%s %v\n", cv.Name, cv.Version, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,82 @@
+package chartsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// dryRun diffs the jobs like a sync and lists the charts that would be
+// transferred instead of transferring them, with their total size and how
+// long that takes at -max-bandwidth.
+func dryRun(ctx context.Context, jobs []syncJob, parallel int) {
+	var total int64
+	queued, unknown := 0, 0
+	var conflicts []conflict
+	for _, job := range planJobs(ctx, jobs, parallel) {
+		if job.tenant != "" || job.target != "" {
+			outln("Tenant", "/"+job.tenant, "to", "/"+job.target)
+		}
+		var queue []syncItem
+		for _, item := range job.plan.queue {
+			if reason := dryRunDecision(ctx, job, item); reason != "" {
+				outf("Would deny %s-%s to %s: %s\n", item.Chart, item.Version, job.destination, reason)
+				continue
+			}
+			queue = append(queue, item)
+		}
+		sizes := make([]int64, len(queue))
+		for i := range sizes {
+			sizes[i] = -1
+		}
+		if sizer, ok := job.source.(chartSizer); ok {
+			sizes = chartSizes(ctx, sizer, queue, job.options.Concurrency)
+		}
+		for i, item := range queue {
+			if sizes[i] < 0 {
+				unknown++
+				outf("Would sync %s-%s to %s (size unknown)\n", item.Chart, item.Version, job.destination)
+				continue
+			}
+			total += sizes[i]
+			outf("Would sync %s-%s to %s (%s)\n", item.Chart, item.Version, job.destination, formatSize(sizes[i]))
+		}
+		queued += len(queue)
+		for _, item := range job.plan.unannotated {
+			outf("Would hold back %s-%s, it lacks the annotations %s\n", item.Chart, item.Version, formatAnnotations(job.options.RequireAnnotations))
+		}
+		for _, c := range job.plan.conflicts {
+			c.Destination = fmt.Sprint(job.destination)
+			conflicts = append(conflicts, c)
+		}
+	}
+
+	estimate := fmt.Sprintf("Would sync %d charts, %s", queued, formatSize(total))
+	if unknown > 0 {
+		estimate += fmt.Sprintf(" and %d of unknown size", unknown)
+	}
+	outln(estimate)
+	sortConflicts(conflicts)
+	printConflicts(os.Stdout, conflicts)
+	if rate := bandwidth(); rate > 0 {
+		d := time.Duration(float64(total) / rate * float64(time.Second))
+		outf("Estimated transfer time at %s/s: %s\n", formatSize(int64(rate)), d.Round(time.Second))
+	} else if total > 0 {
+		logln("Pass -max-bandwidth for an estimate of the transfer time")
+	}
+}
+
+// dryRunDecision is why a policy deciding on the metadata of versions
+// denies item, policies on their contents aren't asked.
+func dryRunDecision(ctx context.Context, job plannedJob, item syncItem) string {
+	policy := job.options.policy
+	if policy == nil || policy.contents {
+		return ""
+	}
+	reason, err := policy.decide(ctx, newPolicyInput(job.source, job.destination, job.plan.sourceData, item))
+	if err != nil {
+		return "evaluating policy: " + err.Error()
+	}
+	return reason
+}
@@ -0,0 +1,80 @@
+package chartsync
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+)
+
+var ecrHostPattern = regexp.MustCompile(`^(\d{12})\.dkr\.ecr(-fips)?\.([a-z0-9-]+)\.amazonaws\.com(\.cn)?$`)
+
+// ecrAuth hands out registry credentials from ECR's GetAuthorizationToken,
+// signed with the default AWS credential chain, or that of profile, and
+// refreshed before the token expires.
+type ecrAuth struct {
+	region  string
+	account string
+	profile string
+	client  *http.Client
+
+	mu      sync.Mutex
+	creds   credentials
+	expires time.Time
+}
+
+func newECRAuth(host, profile string, client *http.Client) *ecrAuth {
+	m := ecrHostPattern.FindStringSubmatch(host)
+	if m == nil {
+		return nil
+	}
+	return &ecrAuth{account: m[1], region: m[3], profile: profile, client: client}
+}
+
+func (e *ecrAuth) credentials(ctx context.Context) (credentials, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if time.Until(e.expires) > 5*time.Minute {
+		return e.creds, nil
+	}
+
+	opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(e.region), awsconfig.WithHTTPClient(e.client)}
+	if e.profile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(e.profile))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return credentials{}, fmt.Errorf("error loading aws config: %w", err)
+	}
+	out, err := ecr.NewFromConfig(cfg).GetAuthorizationToken(ctx, &ecr.GetAuthorizationTokenInput{})
+	if err != nil {
+		return credentials{}, fmt.Errorf("error getting ecr token: %w", err)
+	}
+	for _, data := range out.AuthorizationData {
+		if data.AuthorizationToken == nil {
+			continue
+		}
+		token, err := base64.StdEncoding.DecodeString(*data.AuthorizationToken)
+		if err != nil {
+			return credentials{}, fmt.Errorf("error decoding ecr token: %w", err)
+		}
+		user, pass, ok := strings.Cut(string(token), ":")
+		if !ok {
+			return credentials{}, fmt.Errorf("malformed ecr token")
+		}
+		e.creds = credentials{Username: user, Password: pass}
+		e.expires = time.Now().Add(time.Hour)
+		if data.ExpiresAt != nil {
+			e.expires = *data.ExpiresAt
+		}
+		return e.creds, nil
+	}
+	return credentials{}, fmt.Errorf("ecr returned no authorization data for %s", e.account)
+}
@@ -0,0 +1,268 @@
+package chartsync
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+)
+
+const ageHeader = "age-encryption.org/"
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// bundleRecipients are the recipients a bundle is encrypted to, age keys
+// (age1...) and ssh public keys are handled by age, anything else is a gpg
+// key id or email looked up in the gpg keyring.
+type bundleRecipients struct {
+	age []age.Recipient
+	gpg []string
+}
+
+func parseRecipients(list []string) (*bundleRecipients, error) {
+	r := &bundleRecipients{}
+	for _, s := range list {
+		switch {
+		case strings.HasPrefix(s, "age1"):
+			rcpt, err := age.ParseX25519Recipient(s)
+			if err != nil {
+				return nil, err
+			}
+			r.age = append(r.age, rcpt)
+		case strings.HasPrefix(s, "ssh-"):
+			rcpt, err := agessh.ParseRecipient(s)
+			if err != nil {
+				return nil, err
+			}
+			r.age = append(r.age, rcpt)
+		default:
+			r.gpg = append(r.gpg, s)
+		}
+	}
+	if len(r.age) > 0 && len(r.gpg) > 0 {
+		return nil, errors.New("a bundle is encrypted with either age or gpg, not both")
+	}
+	return r, nil
+}
+
+// encrypt wraps w, the returned writer must be closed to finish the
+// encrypted stream.
+func (r *bundleRecipients) encrypt(w io.Writer) (io.WriteCloser, error) {
+	if len(r.age) > 0 {
+		return age.Encrypt(w, r.age...)
+	}
+	// Keys given by their full fingerprint are pinned, any other recipient
+	// has to be trusted in the keyring, gpg refuses it otherwise.
+	args := []string{"--batch", "--yes", "--encrypt"}
+	pinned := true
+	for _, id := range r.gpg {
+		args = append(args, "--recipient", id)
+		pinned = pinned && isFingerprint(id)
+	}
+	if pinned {
+		args = append([]string{"--trust-model", "always"}, args...)
+	}
+	return startGPG(w, nil, args...)
+}
+
+// isFingerprint tells if s is a full v4 or v5 gpg fingerprint.
+func isFingerprint(s string) bool {
+	s = normalizeFingerprint(s)
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789ABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+func normalizeFingerprint(s string) string {
+	return strings.ToUpper(strings.ReplaceAll(strings.TrimPrefix(s, "0x"), " ", ""))
+}
+
+// gpgPipe is a running gpg process, closing it closes stdin and waits for
+// gpg to exit.
+type gpgPipe struct {
+	io.WriteCloser
+	io.Reader
+	cmd    *exec.Cmd
+	stderr *bytes.Buffer
+}
+
+func startGPG(stdout io.Writer, stdin io.Reader, args ...string) (*gpgPipe, error) {
+	cmd := exec.Command("gpg", args...)
+	p := &gpgPipe{cmd: cmd, stderr: &bytes.Buffer{}}
+	cmd.Stderr = p.stderr
+	var err error
+	if stdin == nil {
+		if p.WriteCloser, err = cmd.StdinPipe(); err != nil {
+			return nil, err
+		}
+	} else {
+		cmd.Stdin = stdin
+	}
+	if stdout == nil {
+		if p.Reader, err = cmd.StdoutPipe(); err != nil {
+			return nil, err
+		}
+	} else {
+		cmd.Stdout = stdout
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *gpgPipe) Close() error {
+	if p.WriteCloser != nil {
+		p.WriteCloser.Close()
+	}
+	if p.Reader != nil {
+		io.Copy(io.Discard, p.Reader)
+	}
+	if err := p.cmd.Wait(); err != nil {
+		return fmt.Errorf("gpg: %w\n%s", err, strings.TrimSpace(p.stderr.String()))
+	}
+	return nil
+}
+
+// gpgSign returns an armored detached signature of data.
+func gpgSign(data []byte, key string) ([]byte, error) {
+	var sig bytes.Buffer
+	p, err := startGPG(&sig, bytes.NewReader(data), "--batch", "--yes", "--local-user", key, "--armor", "--detach-sign")
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Close(); err != nil {
+		return nil, err
+	}
+	return sig.Bytes(), nil
+}
+
+// gpgVerify checks a detached signature of data against the gpg keyring
+// and returns the fingerprints of the signing key and its primary key.
+func gpgVerify(data, sig []byte) ([]string, error) {
+	f, err := os.CreateTemp("", "cm_sync-sig-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(sig)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	var status bytes.Buffer
+	p, err := startGPG(&status, bytes.NewReader(data), "--batch", "--status-fd", "1", "--verify", f.Name(), "-")
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Close(); err != nil {
+		return nil, err
+	}
+	// GOODSIG is only given for keys that aren't expired or revoked,
+	// VALIDSIG has the fingerprints.
+	var good bool
+	var fprs []string
+	for _, line := range strings.Split(status.String(), "\n") {
+		fields := strings.Fields(strings.TrimPrefix(line, "[GNUPG:] "))
+		switch {
+		case len(fields) > 0 && fields[0] == "GOODSIG":
+			good = true
+		case len(fields) > 1 && fields[0] == "VALIDSIG":
+			fprs = append(fprs, fields[1])
+			if len(fields) > 10 && fields[10] != fields[1] {
+				fprs = append(fprs, fields[10])
+			}
+		}
+	}
+	if !good || len(fprs) == 0 {
+		return nil, errors.New("gpg gave no good signature")
+	}
+	return fprs, nil
+}
+
+// trustedSigner tells if one of the fingerprints of a signature is in
+// trusted.
+func trustedSigner(fprs, trusted []string) bool {
+	for _, f := range fprs {
+		for _, t := range trusted {
+			if normalizeFingerprint(f) == normalizeFingerprint(t) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadIdentities reads age identities or an ssh private key from files.
+func loadIdentities(paths []string) ([]age.Identity, error) {
+	var ids []age.Identity
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Contains(data, []byte("PRIVATE KEY")) {
+			id, err := agessh.ParseIdentity(data)
+			if err != nil {
+				return nil, fmt.Errorf("error reading %s: %w", path, err)
+			}
+			ids = append(ids, id)
+			continue
+		}
+		parsed, err := age.ParseIdentities(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", path, err)
+		}
+		ids = append(ids, parsed...)
+	}
+	return ids, nil
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (r readCloser) Close() error {
+	return r.close()
+}
+
+// decryptBundle returns the plain stream of a bundle file, age encrypted
+// bundles are opened with ids and anything that isn't zstd is given to gpg.
+func decryptBundle(f *os.File, ids []age.Identity) (io.ReadCloser, error) {
+	br := bufio.NewReader(f)
+	head, _ := br.Peek(len(ageHeader))
+	switch {
+	case bytes.HasPrefix(head, zstdMagic):
+		return io.NopCloser(br), nil
+	case string(head) == ageHeader:
+		if len(ids) == 0 {
+			return nil, errors.New("bundle is age encrypted, pass the key with -identity")
+		}
+		r, err := age.Decrypt(br, ids...)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(r), nil
+	}
+	p, err := startGPG(nil, br, "--batch", "--quiet", "--decrypt")
+	if err != nil {
+		return nil, err
+	}
+	return readCloser{Reader: p.Reader, close: p.Close}, nil
+}
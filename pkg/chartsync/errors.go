@@ -0,0 +1,198 @@
+package chartsync
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// The kinds of errors that operations fail with, match them with
+// errors.Is. Errors of a sync are collected as *Error per chart version.
+var (
+	ErrNotFound     = errors.New("not found")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrConflict     = errors.New("conflict")
+	ErrCorrupt      = errors.New("corrupt")
+	ErrNetwork      = errors.New("network error")
+)
+
+var errorKinds = []struct {
+	name string
+	err  error
+}{
+	{"not_found", ErrNotFound},
+	{"unauthorized", ErrUnauthorized},
+	{"conflict", ErrConflict},
+	{"corrupt", ErrCorrupt},
+	{"network", ErrNetwork},
+}
+
+// ErrorKind names the kind of err: not_found, unauthorized, conflict,
+// corrupt or network, empty when it is none of them.
+func ErrorKind(err error) string {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.name
+		}
+	}
+	var netErr net.Error
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "not_found"
+	case errors.As(err, &netErr):
+		return "network"
+	}
+	return ""
+}
+
+// ErrorCode is the stable code of err for automation, in json output and
+// logs: E_SOURCE_ or E_DEST_ and the upper case ErrorKind of an error on
+// that side, like E_DEST_UNAUTHORIZED, or E_SOURCE_FAILED and E_DEST_FAILED
+// for other errors there. Errors of the chart itself are E_CHART_CORRUPT,
+// E_CHART_TOO_LARGE and E_CHART_TIMEOUT, failed policies E_POLICY_FAILED.
+// Errors of neither side are E_ and their kind, or E_UNKNOWN.
+func ErrorCode(err error) string {
+	var ce codeError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &ce):
+		return ce.code
+	case errors.Is(err, ErrCorrupt):
+		return "E_CHART_CORRUPT"
+	case errors.Is(err, errChartTooLarge):
+		return "E_CHART_TOO_LARGE"
+	}
+	side := ""
+	var se sideError
+	if errors.As(err, &se) {
+		side = se.side + "_"
+	}
+	switch kind := ErrorKind(err); {
+	case kind != "":
+		return "E_" + side + strings.ToUpper(kind)
+	case side != "":
+		return "E_" + side + "FAILED"
+	}
+	return "E_UNKNOWN"
+}
+
+// withCode is err with its ErrorCode in front, for log lines.
+func withCode(err error) string {
+	return ErrorCode(err) + " " + err.Error()
+}
+
+// sideError is an error of the source or the destination, which of them
+// goes into its ErrorCode.
+type sideError struct {
+	side string
+	err  error
+}
+
+func sourceError(err error) error {
+	return sideError{"SOURCE", err}
+}
+
+func destError(err error) error {
+	return sideError{"DEST", err}
+}
+
+func (e sideError) Error() string {
+	return e.err.Error()
+}
+
+func (e sideError) Unwrap() error {
+	return e.err
+}
+
+// codeError is an error with the ErrorCode code.
+type codeError struct {
+	code string
+	err  error
+}
+
+func (e codeError) Error() string {
+	return e.err.Error()
+}
+
+func (e codeError) Unwrap() error {
+	return e.err
+}
+
+// statusError is an unexpected http status, it is the kind of error the
+// status stands for.
+type statusError struct {
+	code int
+}
+
+func unexpectedStatus(code int) error {
+	return statusError{code}
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("unexpected status code: %d", e.code)
+}
+
+func (e statusError) Is(target error) bool {
+	switch e.code {
+	case http.StatusNotFound, http.StatusGone:
+		return target == ErrNotFound
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return target == ErrUnauthorized
+	case http.StatusConflict:
+		return target == ErrConflict
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return target == ErrNetwork
+	}
+	return false
+}
+
+// kindError keeps the message of an error while making it one of the
+// kinds above.
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func (e kindError) Error() string {
+	return e.msg
+}
+
+func (e kindError) Is(target error) bool {
+	return target == e.kind
+}
+
+func corruptf(format string, args ...any) error {
+	return kindError{fmt.Sprintf(format, args...), ErrCorrupt}
+}
+
+// Error is a chart version a sync failed on, Kind is ErrorKind of Err and
+// Code its ErrorCode.
+type Error struct {
+	Version
+	Kind string
+	Code string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Chart + "-" + e.Version.Version + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the kind of error, also for network errors of the standard
+// library that aren't ErrNetwork themselves.
+func (e *Error) Is(target error) bool {
+	for _, k := range errorKinds {
+		if k.err == target {
+			return k.name == e.Kind
+		}
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+package chartsync
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+// syncEvent is published for every version synced or failed, and with
+// the summary once a run is over.
+type syncEvent struct {
+	Event       string       `json:"event"`
+	Source      string       `json:"source,omitempty"`
+	Destination string       `json:"destination,omitempty"`
+	Chart       string       `json:"chart,omitempty"`
+	Version     string       `json:"version,omitempty"`
+	Size        int64        `json:"size,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	Kind        string       `json:"kind,omitempty"`
+	Code        string       `json:"code,omitempty"`
+	Summary     *summaryJSON `json:"summary,omitempty"`
+	Time        time.Time    `json:"time"`
+}
+
+func (e syncEvent) json() []byte {
+	data, _ := json.Marshal(e)
+	return data
+}
+
+// publisher sends a batch of events to a broker, keyed by chart name
+// where it has keys.
+type publisher interface {
+	publish(ctx context.Context, events []syncEvent) error
+	close() error
+}
+
+// eventSink publishes events in the background so transfers don't wait
+// on the broker, close sends what is still queued.
+type eventSink struct {
+	target string
+	pub    publisher
+	queue  chan syncEvent
+	done   chan struct{}
+}
+
+// newEventSink connects to a kafka://broker,broker/topic or
+// nats://host/subject url, kafka+tls:// and nats+tls:// use TLS. User
+// info in the url is a SASL PLAIN login for kafka, a user for nats.
+// sns://topic-arn and sqs://queue-url publish to AWS.
+func newEventSink(ctx context.Context, target string) (*eventSink, error) {
+	switch {
+	case strings.HasPrefix(target, "sns://"):
+		pub, err := newSNSPublisher(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		return startEventSink(target, pub), nil
+	case strings.HasPrefix(target, "sqs://"):
+		pub, err := newSQSPublisher(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		return startEventSink(target, pub), nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	name := strings.TrimPrefix(u.Path, "/")
+	if name == "" {
+		return nil, fmt.Errorf("%s has no topic or subject", target)
+	}
+	var pub publisher
+	switch u.Scheme {
+	case "kafka", "kafka+tls":
+		pub = newKafkaPublisher(u, name)
+	case "nats", "nats+tls":
+		if pub, err = newNATSPublisher(u, name); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported events url %s, expected kafka:// or nats://", target)
+	}
+	return startEventSink(u.Redacted(), pub), nil
+}
+
+func startEventSink(target string, pub publisher) *eventSink {
+	s := &eventSink{target: target, pub: pub, queue: make(chan syncEvent, 1024), done: make(chan struct{})}
+	go s.send()
+	return s
+}
+
+func (s *eventSink) send() {
+	defer close(s.done)
+	for e := range s.queue {
+		batch := []syncEvent{e}
+		for len(batch) < cap(s.queue) && len(s.queue) > 0 {
+			batch = append(batch, <-s.queue)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		if err := s.pub.publish(ctx, batch); err != nil {
+			logf("Failed to publish %d events to %s %v\n", len(batch), s.target, err)
+		}
+		cancel()
+	}
+}
+
+func (s *eventSink) close() {
+	close(s.queue)
+	<-s.done
+	if err := s.pub.close(); err != nil {
+		logln("Failed to close", s.target, err)
+	}
+}
+
+// run publishes the summary of a run.
+func (s *eventSink) run(summary *runSummary) {
+	out := summary.json()
+	s.queue <- syncEvent{Event: "run", Summary: &out, Time: time.Now().UTC()}
+}
+
+// reporter publishes the outcomes of a job syncing source to destination.
+func (s *eventSink) reporter(source, destination string) Reporter {
+	return eventReporter{s, source, destination}
+}
+
+type eventReporter struct {
+	sink        *eventSink
+	source      string
+	destination string
+}
+
+func (r eventReporter) event(name string, v Version) syncEvent {
+	return syncEvent{Event: name, Source: r.source, Destination: r.destination, Chart: v.Chart, Version: v.Version, Time: time.Now().UTC()}
+}
+
+func (r eventReporter) Synced(v Version, size int64) {
+	e := r.event("synced", v)
+	e.Size = size
+	r.sink.queue <- e
+}
+
+func (r eventReporter) Skipped(v Version) {}
+
+func (r eventReporter) Failed(v Version, err error) {
+	e := r.event("failed", v)
+	e.Error = redact(err.Error())
+	e.Kind = ErrorKind(err)
+	e.Code = ErrorCode(err)
+	r.sink.queue <- e
+}
+
+type kafkaPublisher struct {
+	w *kafka.Writer
+}
+
+func newKafkaPublisher(u *url.URL, topic string) *kafkaPublisher {
+	transport := &kafka.Transport{}
+	if u.Scheme == "kafka+tls" {
+		transport.TLS = &tls.Config{}
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		transport.SASL = plain.Mechanism{Username: u.User.Username(), Password: password}
+	}
+	return &kafkaPublisher{&kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(u.Host, ",")...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		BatchTimeout: 10 * time.Millisecond,
+		RequiredAcks: kafka.RequireAll,
+		Transport:    transport,
+	}}
+}
+
+func (p *kafkaPublisher) publish(ctx context.Context, events []syncEvent) error {
+	msgs := make([]kafka.Message, len(events))
+	for i, e := range events {
+		msgs[i] = kafka.Message{Key: []byte(e.Chart), Value: e.json()}
+	}
+	return p.w.WriteMessages(ctx, msgs...)
+}
+
+func (p *kafkaPublisher) close() error {
+	return p.w.Close()
+}
+
+type natsPublisher struct {
+	nc      *nats.Conn
+	subject string
+}
+
+func newNATSPublisher(u *url.URL, subject string) (*natsPublisher, error) {
+	server := &url.URL{Scheme: "nats", User: u.User, Host: u.Host}
+	if u.Scheme == "nats+tls" {
+		server.Scheme = "tls"
+	}
+	nc, err := nats.Connect(server.String(), nats.Name("cm_sync"))
+	if err != nil {
+		return nil, err
+	}
+	return &natsPublisher{nc, subject}, nil
+}
+
+func (p *natsPublisher) publish(ctx context.Context, events []syncEvent) error {
+	for _, e := range events {
+		if err := p.nc.Publish(p.subject, e.json()); err != nil {
+			return err
+		}
+	}
+	return p.nc.FlushWithContext(ctx)
+}
+
+func (p *natsPublisher) close() error {
+	err := p.nc.Flush()
+	p.nc.Close()
+	return err
+}
@@ -0,0 +1,212 @@
+package chartsync
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sortVersions orders versions newest first, versions that are not valid
+// semver sort after the valid ones.
+func sortVersions(versions []ChartVersion) {
+	sort.SliceStable(versions, func(i, j int) bool {
+		return newerVersion(versions[i].Version, versions[j].Version)
+	})
+}
+
+// newerVersion orders a before b when it is the newer semver, versions
+// that are not valid semver come last in lexical order.
+func newerVersion(a, b string) bool {
+	va, erra := semver.NewVersion(a)
+	vb, errb := semver.NewVersion(b)
+	switch {
+	case erra != nil && errb != nil:
+		return a < b
+	case erra != nil || errb != nil:
+		return erra == nil
+	case va.Equal(vb):
+		return a < b
+	}
+	return va.GreaterThan(vb)
+}
+
+// sortedItems lists the versions of diff by chart name, newest version
+// first, so every run works through them in the same order.
+func sortedItems(diff map[string][]string) []syncItem {
+	charts := make([]string, 0, len(diff))
+	for chart := range diff {
+		charts = append(charts, chart)
+	}
+	sort.Strings(charts)
+	var items []syncItem
+	for _, chart := range charts {
+		versions := append([]string(nil), diff[chart]...)
+		sort.Slice(versions, func(i, j int) bool { return newerVersion(versions[i], versions[j]) })
+		for _, version := range versions {
+			items = append(items, syncItem{Chart: chart, Version: version})
+		}
+	}
+	return items
+}
+
+// parseShard reads shards like 2/8, the second of eight. An empty shard
+// is all charts, 1/1.
+func parseShard(s string) (index, count int, err error) {
+	if s == "" {
+		return 1, 1, nil
+	}
+	i, n, ok := strings.Cut(s, "/")
+	if ok {
+		index, err = strconv.Atoi(strings.TrimSpace(i))
+		if err == nil {
+			count, err = strconv.Atoi(strings.TrimSpace(n))
+		}
+	}
+	if !ok || err != nil || count < 1 || index < 1 || index > count {
+		return 0, 0, fmt.Errorf("invalid shard %q, expected N/M with 1 <= N <= M", s)
+	}
+	return index, count, nil
+}
+
+// inShard tells if chart belongs to the shard index of count, charts are
+// spread by the hash of their name so every invocation agrees on it.
+func inShard(chart string, index, count int) bool {
+	if count <= 1 {
+		return true
+	}
+	h := fnv.New32a()
+	h.Write([]byte(chart))
+	return int(h.Sum32()%uint32(count)) == index-1
+}
+
+// parseAnnotations reads required annotations like
+// quality-gate=passed,signed, a key without a value only has to be set.
+func parseAnnotations(s string) (map[string]string, error) {
+	required := make(map[string]string)
+	for _, item := range splitList(s) {
+		key, value, _ := strings.Cut(item, "=")
+		if key = strings.TrimSpace(key); key == "" {
+			return nil, fmt.Errorf("invalid annotation %q, expected KEY=VALUE or KEY", item)
+		}
+		required[key] = strings.TrimSpace(value)
+	}
+	return required, nil
+}
+
+// hasAnnotations tells if v carries the required annotations, a key
+// without a required value only has to be set, even to "".
+func hasAnnotations(v ChartVersion, required map[string]string) bool {
+	for key, value := range required {
+		got, ok := v.Annotations[key]
+		if !ok || value != "" && got != value {
+			return false
+		}
+	}
+	return true
+}
+
+// formatAnnotations lists required annotations sorted by key for logs.
+func formatAnnotations(required map[string]string) string {
+	items := make([]string, 0, len(required))
+	for key, value := range required {
+		if value != "" {
+			key += "=" + value
+		}
+		items = append(items, key)
+	}
+	sort.Strings(items)
+	return strings.Join(items, ",")
+}
+
+// annotated returns the versions of data carrying the required
+// annotations, data itself when none are required.
+func annotated(data ChartData, required map[string]string) ChartData {
+	if len(required) == 0 {
+		return data
+	}
+	out := make(ChartData)
+	for chart, versions := range data {
+		for _, v := range versions {
+			if hasAnnotations(v, required) {
+				out[chart] = append(out[chart], v)
+			}
+		}
+	}
+	return out
+}
+
+// heldBack lists the versions of data1 a sync with opts would transfer to
+// data2 but for their missing opts.RequireAnnotations.
+func heldBack(data1, data2 ChartData, opts syncOptions) []syncItem {
+	if len(opts.RequireAnnotations) == 0 {
+		return nil
+	}
+	ungated := opts
+	ungated.RequireAnnotations = nil
+	candidates := applyPolicy(data1, ungated)
+	for chart, versions := range candidates {
+		var missing []ChartVersion
+		for _, v := range versions {
+			if !hasAnnotations(v, opts.RequireAnnotations) {
+				missing = append(missing, v)
+			}
+		}
+		candidates[chart] = missing
+	}
+	return sortedItems(compareCharts(candidates, data2))
+}
+
+// applyPolicy returns the charts of data that pass the include/exclude
+// filters and are in opts.Shard and opts.NameWindow, keeping only the newest opts.Retention
+// versions of each that carry opts.RequireAnnotations.
+func applyPolicy(data ChartData, opts syncOptions) ChartData {
+	out := make(ChartData)
+	index, count, err := parseShard(opts.Shard)
+	if err != nil {
+		// Shards are checked where they are set, one that got through
+		// anyway matches no charts rather than all of them.
+		return out
+	}
+	for chart, versions := range annotated(data, opts.RequireAnnotations) {
+		if !inShard(chart, index, count) || !opts.NameWindow.contains(chart) {
+			continue
+		}
+		if len(opts.Include) > 0 && !matchAny(opts.Include, chart) {
+			continue
+		}
+		if matchAny(opts.Exclude, chart) {
+			continue
+		}
+		versions = append([]ChartVersion(nil), versions...)
+		if opts.Retention > 0 && len(versions) > opts.Retention {
+			sortVersions(versions)
+			versions = versions[:opts.Retention]
+		}
+		out[chart] = versions
+	}
+	return out
+}
@@ -0,0 +1,119 @@
+package chartsync
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestApplyPolicy(t *testing.T) {
+	signed := map[string]string{"signed": "true"}
+	data := ChartData{
+		"web": {
+			{Name: "web", Version: "1.0.0"},
+			{Name: "web", Version: "1.10.0", Annotations: signed},
+			{Name: "web", Version: "1.2.0"},
+			{Name: "web", Version: "2.0.0-rc.1", Annotations: signed},
+		},
+		"web-internal": {{Name: "web-internal", Version: "0.1.0"}},
+		"api":          {{Name: "api", Version: "2.0.0", Annotations: signed}},
+		"api-db":       {{Name: "api-db", Version: "1.0.0", Annotations: map[string]string{"signed": ""}}},
+	}
+	tests := []struct {
+		name string
+		opts syncOptions
+		want map[string][]string
+	}{
+		{"everything", syncOptions{}, map[string][]string{
+			"web": {"1.0.0", "1.10.0", "1.2.0", "2.0.0-rc.1"}, "web-internal": {"0.1.0"}, "api": {"2.0.0"}, "api-db": {"1.0.0"},
+		}},
+		{"include", syncOptions{Include: []string{"web*"}}, map[string][]string{
+			"web": {"1.0.0", "1.10.0", "1.2.0", "2.0.0-rc.1"}, "web-internal": {"0.1.0"},
+		}},
+		{"exclude wins", syncOptions{Include: []string{"web*"}, Exclude: []string{"*-internal"}}, map[string][]string{
+			"web": {"1.0.0", "1.10.0", "1.2.0", "2.0.0-rc.1"},
+		}},
+		{"retention keeps the newest", syncOptions{Retention: 2}, map[string][]string{
+			"web": {"2.0.0-rc.1", "1.10.0"}, "web-internal": {"0.1.0"}, "api": {"2.0.0"}, "api-db": {"1.0.0"},
+		}},
+		{"annotations", syncOptions{RequireAnnotations: map[string]string{"signed": ""}}, map[string][]string{
+			"web": {"1.10.0", "2.0.0-rc.1"}, "api": {"2.0.0"}, "api-db": {"1.0.0"},
+		}},
+		{"annotations before retention", syncOptions{Retention: 1, RequireAnnotations: signed}, map[string][]string{
+			"web": {"2.0.0-rc.1"}, "api": {"2.0.0"},
+		}},
+		{"name window", syncOptions{NameWindow: nameWindow{From: "b", To: "web-"}}, map[string][]string{
+			"web": {"1.0.0", "1.10.0", "1.2.0", "2.0.0-rc.1"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string][]string)
+			for chart, versions := range applyPolicy(data, tt.opts) {
+				for _, v := range versions {
+					got[chart] = append(got[chart], v.Version)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("applyPolicy = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if len(data["web"]) != 4 || data["web"][0].Version != "1.0.0" {
+		t.Errorf("applyPolicy changed its input: %v", data["web"])
+	}
+}
+
+func TestParseShard(t *testing.T) {
+	tests := []struct {
+		in           string
+		index, count int
+		ok           bool
+	}{
+		{"", 1, 1, true},
+		{"1/1", 1, 1, true},
+		{"2/8", 2, 8, true},
+		{" 3 / 4 ", 3, 4, true},
+		{"0/4", 0, 0, false},
+		{"5/4", 0, 0, false},
+		{"1/0", 0, 0, false},
+		{"-1/4", 0, 0, false},
+		{"2", 0, 0, false},
+		{"a/b", 0, 0, false},
+	}
+	for _, tt := range tests {
+		index, count, err := parseShard(tt.in)
+		if (err == nil) != tt.ok || index != tt.index || count != tt.count {
+			t.Errorf("parseShard(%q) = %d, %d, %v, want %d, %d, ok %v", tt.in, index, count, err, tt.index, tt.count, tt.ok)
+		}
+	}
+}
+
+func TestShardsSplitCharts(t *testing.T) {
+	const count = 4
+	data := make(ChartData)
+	for i := 0; i < 100; i++ {
+		chart := fmt.Sprintf("chart-%d", i)
+		data[chart] = []ChartVersion{{Name: chart, Version: "1.0.0"}}
+	}
+	var all []string
+	for index := 1; index <= count; index++ {
+		shard := applyPolicy(data, syncOptions{Shard: fmt.Sprintf("%d/%d", index, count)})
+		if len(shard) == 0 {
+			t.Errorf("shard %d/%d is empty", index, count)
+		}
+		for chart := range shard {
+			all = append(all, chart)
+		}
+	}
+	if len(all) != len(data) {
+		t.Fatalf("%d shards hold %d charts, want each of the %d once", count, len(all), len(data))
+	}
+	sort.Strings(all)
+	for i := 1; i < len(all); i++ {
+		if all[i] == all[i-1] {
+			t.Errorf("%s is in more than one shard", all[i])
+		}
+	}
+}
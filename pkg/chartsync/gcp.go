@@ -0,0 +1,64 @@
+package chartsync
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const gcpScope = "https://www.googleapis.com/auth/cloud-platform"
+
+func isGCPRegistryHost(host string) bool {
+	return strings.HasSuffix(host, "-docker.pkg.dev") || host == "gcr.io" || strings.HasSuffix(host, ".gcr.io")
+}
+
+// gcpAuth uses Application Default Credentials, or the credentials json
+// file, the token source refreshes the access token by itself once it
+// expires.
+type gcpAuth struct {
+	file   string
+	client *http.Client
+	once   sync.Once
+	src    oauth2.TokenSource
+	err    error
+}
+
+func (g *gcpAuth) token() (*oauth2.Token, error) {
+	g.once.Do(func() {
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, g.client)
+		if g.file == "" {
+			g.src, g.err = google.DefaultTokenSource(ctx, gcpScope)
+			return
+		}
+		var data []byte
+		if data, g.err = os.ReadFile(g.file); g.err != nil {
+			return
+		}
+		var creds *google.Credentials
+		if creds, g.err = google.CredentialsFromJSON(ctx, data, gcpScope); g.err == nil {
+			g.src = creds.TokenSource
+		}
+	})
+	if g.err != nil && g.file != "" {
+		return nil, fmt.Errorf("error loading google credentials %s: %w", g.file, g.err)
+	}
+	if g.err != nil {
+		return nil, fmt.Errorf("error loading application default credentials: %w", g.err)
+	}
+	return g.src.Token()
+}
+
+// credentials ignores the context, the token source refreshes on its own.
+func (g *gcpAuth) credentials(context.Context) (credentials, error) {
+	t, err := g.token()
+	if err != nil {
+		return credentials{}, err
+	}
+	return credentials{Username: "oauth2accesstoken", Password: t.AccessToken}, nil
+}
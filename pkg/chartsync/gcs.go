@@ -0,0 +1,161 @@
+package chartsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/oauth2"
+)
+
+// gcsStore keeps the repository below prefix in a Cloud Storage bucket,
+// talking to the JSON api with Application Default Credentials. An emulator
+// can be used with ?endpoint=http://localhost:4443.
+type gcsStore struct {
+	client   *http.Client
+	endpoint string
+	bucket   string
+	prefix   string
+}
+
+func newGCSStore(ref, tenant string, creds credentials, client *http.Client) (*gcsStore, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if tenant != "" {
+		prefix = strings.Trim(prefix+"/"+tenant, "/")
+	}
+	s := &gcsStore{
+		client:   client,
+		endpoint: "https://storage.googleapis.com",
+		bucket:   u.Host,
+		prefix:   prefix,
+	}
+	if endpoint := u.Query().Get("endpoint"); endpoint != "" {
+		s.endpoint = strings.TrimSuffix(endpoint, "/")
+	} else {
+		auth := &gcpAuth{file: creds.GoogleCredentials, client: client}
+		if _, err := auth.token(); err != nil {
+			return nil, err
+		}
+		s.client = oauth2.NewClient(context.WithValue(context.Background(), oauth2.HTTPClient, client), auth.src)
+	}
+	return s, nil
+}
+
+func (s *gcsStore) String() string {
+	return "gs://" + s.bucket + "/" + s.prefix
+}
+
+func (s *gcsStore) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+func (s *gcsStore) do(req *http.Request) (*http.Response, error) {
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, errNotExist
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL.Path, unexpectedStatus(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+func (s *gcsStore) ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.endpoint+"/storage/v1/b/"+url.PathEscape(s.bucket), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *gcsStore) list(ctx context.Context) ([]string, error) {
+	prefix := s.key("")
+	var names []string
+	pageToken := ""
+	for {
+		q := url.Values{"prefix": {prefix}, "delimiter": {"/"}}
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		req, err := http.NewRequestWithContext(ctx, "GET", s.endpoint+"/storage/v1/b/"+url.PathEscape(s.bucket)+"/o?"+q.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			names = append(names, strings.TrimPrefix(item.Name, prefix))
+		}
+		if pageToken = page.NextPageToken; pageToken == "" {
+			return names, nil
+		}
+	}
+}
+
+func (s *gcsStore) read(ctx context.Context, name string) ([]byte, error) {
+	u := s.endpoint + "/storage/v1/b/" + url.PathEscape(s.bucket) + "/o/" + escapePath(s.key(name)) + "?alt=media"
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+func (s *gcsStore) write(ctx context.Context, name string, r io.Reader, size int64) error {
+	q := url.Values{"uploadType": {"media"}, "name": {s.key(name)}}
+	u := s.endpoint + "/upload/storage/v1/b/" + url.PathEscape(s.bucket) + "/o?" + q.Encode()
+	req, err := http.NewRequestWithContext(ctx, "POST", u, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/gzip")
+	if strings.HasSuffix(name, ".yaml") {
+		req.Header.Set("Content-Type", "application/x-yaml")
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
@@ -0,0 +1,208 @@
+package chartsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const defaultCommitMessage = `Sync {{len .Charts}} chart versions from cm_sync
+{{range .Charts}}
+- {{.}}{{end}}
+`
+
+// gitStore publishes the repository to a branch of a git repository, e.g.
+// git+https://github.com/org/charts.git?branch=gh-pages&path=charts. It
+// works on a shallow clone in a temporary directory that is committed and
+// pushed once the sync is done.
+type gitStore struct {
+	remote  string
+	branch  string
+	subdir  string
+	auth    credentials
+	message *template.Template
+
+	mu    sync.Mutex
+	clone string
+	dir   *dirStore
+}
+
+type commitInfo struct {
+	Charts      []string
+	Destination string
+	Time        time.Time
+}
+
+func newGitStore(ref, tenant string, auth credentials, message string) (*gitStore, error) {
+	u, err := url.Parse(strings.TrimPrefix(ref, "git+"))
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	u.RawQuery = ""
+	s := &gitStore{
+		remote: u.String(),
+		branch: q.Get("branch"),
+		subdir: strings.Trim(q.Get("path")+"/"+tenant, "/"),
+		auth:   auth,
+	}
+	if s.branch == "" {
+		s.branch = "gh-pages"
+	}
+	if message == "" {
+		message = defaultCommitMessage
+	}
+	if s.message, err = template.New("commit").Parse(message); err != nil {
+		return nil, fmt.Errorf("invalid commit message template: %w", err)
+	}
+	return s, nil
+}
+
+func (s *gitStore) String() string {
+	return "git+" + s.remote + "@" + s.branch + "/" + s.subdir
+}
+
+// git runs a git command, basic auth for https remotes is sent as an extra
+// header so credentials never end up in the clone's config.
+func (s *gitStore) git(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if s.auth.Username != "" && strings.HasPrefix(s.remote, "https://") {
+		// The header goes through the environment rather than -c, where
+		// it would show in the process list. The entries are appended to
+		// those already set in GIT_CONFIG_COUNT.
+		token := base64.StdEncoding.EncodeToString([]byte(s.auth.Username + ":" + s.auth.Password))
+		n, _ := strconv.Atoi(os.Getenv("GIT_CONFIG_COUNT"))
+		cmd.Env = append(cmd.Env,
+			fmt.Sprintf("GIT_CONFIG_COUNT=%d", n+1),
+			fmt.Sprintf("GIT_CONFIG_KEY_%d=http.extraHeader", n),
+			fmt.Sprintf("GIT_CONFIG_VALUE_%d=Authorization: Basic %s", n, token),
+		)
+	}
+	for _, kv := range []string{"GIT_AUTHOR_NAME=cm_sync", "GIT_AUTHOR_EMAIL=cm_sync@localhost", "GIT_COMMITTER_NAME=cm_sync", "GIT_COMMITTER_EMAIL=cm_sync@localhost"} {
+		if k, _, _ := strings.Cut(kv, "="); os.Getenv(k) == "" {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("git %s: %w\n%s", args[0], err, strings.TrimSpace(out.String()))
+	}
+	return out.String(), nil
+}
+
+func (s *gitStore) ping(ctx context.Context) error {
+	_, err := s.git(ctx, "", "ls-remote", "--heads", s.remote)
+	return err
+}
+
+// open clones the branch on first use, a missing branch starts as an
+// orphan branch.
+func (s *gitStore) open(ctx context.Context) (*dirStore, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.dir != nil {
+		return s.dir, nil
+	}
+	clone, err := os.MkdirTemp("", "cm_sync-git-")
+	if err != nil {
+		return nil, err
+	}
+	heads, err := s.git(ctx, "", "ls-remote", "--heads", s.remote, s.branch)
+	if err == nil && strings.TrimSpace(heads) != "" {
+		_, err = s.git(ctx, "", "clone", "--quiet", "--depth", "1", "--single-branch", "--branch", s.branch, s.remote, clone)
+	} else if err == nil {
+		if _, err = s.git(ctx, clone, "init", "--quiet"); err == nil {
+			if _, err = s.git(ctx, clone, "checkout", "--quiet", "--orphan", s.branch); err == nil {
+				_, err = s.git(ctx, clone, "remote", "add", "origin", s.remote)
+			}
+		}
+	}
+	if err != nil {
+		os.RemoveAll(clone)
+		return nil, err
+	}
+	s.clone = clone
+	s.dir = &dirStore{dir: filepath.Join(clone, filepath.FromSlash(s.subdir))}
+	return s.dir, nil
+}
+
+func (s *gitStore) list(ctx context.Context) ([]string, error) {
+	d, err := s.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names, err := d.list(ctx)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return names, err
+}
+
+func (s *gitStore) read(ctx context.Context, name string) ([]byte, error) {
+	d, err := s.open(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return d.read(ctx, name)
+}
+
+func (s *gitStore) write(ctx context.Context, name string, r io.Reader, size int64) error {
+	d, err := s.open(ctx)
+	if err != nil {
+		return err
+	}
+	return d.write(ctx, name, r, size)
+}
+
+func (s *gitStore) commit(ctx context.Context, charts []string) error {
+	d, err := s.open(ctx)
+	if err != nil {
+		return err
+	}
+	var msg bytes.Buffer
+	if err := s.message.Execute(&msg, commitInfo{Charts: charts, Destination: s.String(), Time: time.Now()}); err != nil {
+		return fmt.Errorf("error rendering commit message: %w", err)
+	}
+	if _, err := s.git(ctx, s.clone, "add", "-A", "--", d.dir); err != nil {
+		return err
+	}
+	if _, err := s.git(ctx, s.clone, "diff", "--cached", "--quiet"); err == nil {
+		return nil
+	}
+	if _, err := s.git(ctx, s.clone, "commit", "--quiet", "-m", msg.String()); err != nil {
+		return err
+	}
+	_, err = s.git(ctx, s.clone, "push", "--quiet", "origin", "HEAD:refs/heads/"+s.branch)
+	return err
+}
+
+// localDir is where the clone is made.
+func (s *gitStore) localDir() string {
+	return os.TempDir()
+}
+
+func (s *gitStore) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.clone == "" {
+		return nil
+	}
+	err := os.RemoveAll(s.clone)
+	s.clone, s.dir = "", nil
+	return err
+}
@@ -0,0 +1,68 @@
+package chartsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// gitlabRepo is a channel of a project's GitLab helm package registry. Its
+// layout matches a multitenant chartmuseum with the channel as tenant:
+// index.yaml below packages/helm/<channel>, uploads to packages/helm/api/<channel>/charts.
+type gitlabRepo struct {
+	*staticRepo
+}
+
+// newGitLabRepo takes the helm repo url of a project, e.g.
+// https://gitlab.example.com/api/v4/projects/42/packages/helm/stable.
+func newGitLabRepo(server, channel string, auth credentials, client *http.Client) gitlabRepo {
+	server = strings.TrimSuffix(server, "/")
+	if base, rest, ok := strings.Cut(server, "/packages/helm/"); ok && channel == "" {
+		server, channel = base, rest
+	}
+	server = strings.TrimSuffix(server, "/packages/helm")
+	r := gitlabRepo{newStaticRepo(server+"/packages/helm", channel, auth, client)}
+	if auth.Token != "" {
+		// Deploy and job tokens only work as Private-Token, not bearer.
+		r.headers = http.Header{"Private-Token": {auth.Token}}
+		r.auth.Token = ""
+	}
+	return r
+}
+
+func (g gitlabRepo) hasChart(ctx context.Context, chart, version string) (bool, error) {
+	return headExists(ctx, g.repo, chartURL(g.url(), chart, version))
+}
+
+func (g gitlabRepo) listCharts(ctx context.Context) (ChartData, error) {
+	if g.tenant == "" {
+		return nil, fmt.Errorf("gitlab needs a helm channel, pass it with -tenants or in the url path")
+	}
+	return g.staticRepo.listCharts(ctx)
+}
+
+func (g gitlabRepo) pushChart(ctx context.Context, chart, version string, data []byte) error {
+	return g.pushChartStream(ctx, chart, version, bytes.NewReader(data), int64(len(data)))
+}
+
+func (g gitlabRepo) pushChartStream(ctx context.Context, chart, version string, data io.Reader, size int64) error {
+	body, contentType, length := multipartBody("chart", chart+"-"+version+".tgz", data, size)
+	req, err := g.newRequest(ctx, "POST", g.apiURL(), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = length
+	req.Header.Set("Content-Type", contentType)
+	resp, err := g.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return unexpectedStatus(resp.StatusCode)
+	}
+	return nil
+}
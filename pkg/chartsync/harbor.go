@@ -0,0 +1,129 @@
+package chartsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// harborRepo talks to the chart repository of a Harbor project, the tenant
+// is the project name.
+type harborRepo struct {
+	repo
+}
+
+// newHarborRepo takes the project from the tenant, or from the url path
+// when no tenant is given, e.g. https://harbor.example.com/library.
+func newHarborRepo(server, project string, auth credentials, client *http.Client) harborRepo {
+	if u, err := url.Parse(server); err == nil && project == "" && strings.Trim(u.Path, "/") != "" {
+		project = strings.Trim(u.Path, "/")
+		u.Path = ""
+		server = u.String()
+	}
+	return harborRepo{repo{server: server, tenant: project, auth: auth, client: client}}
+}
+
+func (h harborRepo) url() string {
+	return h.server + "/chartrepo/" + h.tenant
+}
+
+func (h harborRepo) apiURL() string {
+	return h.server + "/api/chartrepo/" + h.tenant + "/charts"
+}
+
+func (h harborRepo) String() string {
+	return h.url()
+}
+
+func (h harborRepo) ping(ctx context.Context) error {
+	resp, err := h.get(ctx, h.server+"/api/v2.0/systeminfo")
+	if err != nil {
+		return fmt.Errorf("error making request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return unexpectedStatus(resp.StatusCode)
+	}
+	return nil
+}
+
+func (h harborRepo) getJSON(ctx context.Context, u string, v interface{}) error {
+	resp, err := h.get(ctx, u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %w", u, unexpectedStatus(resp.StatusCode))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// indexVersion is empty, the chart list alone doesn't tell versions apart.
+func (h harborRepo) indexVersion() string {
+	return ""
+}
+
+// listCharts needs one request per chart, Harbor's chart list only holds
+// a summary of each chart.
+func (h harborRepo) listCharts(ctx context.Context) (ChartData, error) {
+	if h.tenant == "" {
+		return nil, fmt.Errorf("harbor needs a project, pass it with -tenants or in the url path")
+	}
+	var charts []struct {
+		Name string `json:"name"`
+	}
+	if err := h.getJSON(ctx, h.apiURL(), &charts); err != nil {
+		return nil, err
+	}
+	data := make(ChartData)
+	for _, c := range charts {
+		var versions []ChartVersion
+		if err := h.getJSON(ctx, h.apiURL()+"/"+escapePath(c.Name), &versions); err != nil {
+			return nil, err
+		}
+		data[c.Name] = versions
+	}
+	rememberListing(h.url(), data)
+	return data, nil
+}
+
+func (h harborRepo) fetchChart(ctx context.Context, chart, version string) ([]byte, error) {
+	return downloadChart(ctx, h.repo, downloadURL(h.url(), chart, version))
+}
+
+func (h harborRepo) fetchProvenance(ctx context.Context, chart, version string) ([]byte, error) {
+	return downloadProvenance(ctx, h.repo, downloadURL(h.url(), chart, version))
+}
+
+func (h harborRepo) openChart(ctx context.Context, chart, version string) (io.ReadCloser, int64, error) {
+	return openURL(ctx, h.repo, downloadURL(h.url(), chart, version))
+}
+
+func (h harborRepo) pushChart(ctx context.Context, chart, version string, data []byte) error {
+	return h.pushChartStream(ctx, chart, version, bytes.NewReader(data), int64(len(data)))
+}
+
+func (h harborRepo) pushChartStream(ctx context.Context, chart, version string, data io.Reader, size int64) error {
+	body, contentType, length := multipartBody("chart", chart+"-"+version+".tgz", data, size)
+	req, err := h.newRequest(ctx, "POST", h.apiURL(), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = length
+	req.Header.Set("Content-Type", contentType)
+	resp, err := h.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return unexpectedStatus(resp.StatusCode)
+	}
+	return nil
+}
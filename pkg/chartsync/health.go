@@ -0,0 +1,204 @@
+package chartsync
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// healthChart is the name of the probe chart health uploads and deletes.
+const healthChart = "cm-sync-health"
+
+// healthCheck is the outcome of one check of an endpoint, status is ok,
+// fail or skipped.
+type healthCheck struct {
+	Check   string  `json:"check"`
+	Status  string  `json:"status"`
+	Seconds float64 `json:"seconds,omitempty"`
+	Detail  string  `json:"detail,omitempty"`
+}
+
+// endpointHealth is the readiness of a source or destination.
+type endpointHealth struct {
+	Role     string        `json:"role"`
+	Endpoint string        `json:"endpoint"`
+	Ready    bool          `json:"ready"`
+	Checks   []healthCheck `json:"checks"`
+}
+
+func (h *endpointHealth) add(check string, elapsed time.Duration, err error, detail string) bool {
+	c := healthCheck{Check: check, Status: "ok", Seconds: elapsed.Seconds(), Detail: detail}
+	if err != nil {
+		c.Status, c.Detail = "fail", redact(err.Error())
+		h.Ready = false
+	}
+	h.Checks = append(h.Checks, c)
+	return err == nil
+}
+
+func (h *endpointHealth) skip(check, detail string) {
+	h.Checks = append(h.Checks, healthCheck{Check: check, Status: "skipped", Detail: detail})
+}
+
+// hasCredentials tells whether auth authenticates at all.
+func hasCredentials(auth credentials) bool {
+	return auth.Username != "" || auth.Token != "" || auth.APIKey != "" || auth.OIDCTokenURL != "" || auth.Type != "" || auth.CertFile != ""
+}
+
+// healthEndpoint is what sources and destinations have in common.
+type healthEndpoint interface {
+	ping(ctx context.Context) error
+	listCharts(ctx context.Context) (ChartData, error)
+	String() string
+}
+
+// checkEndpoint pings r, lists its charts and, for a writable destination,
+// uploads and deletes a probe chart.
+func checkEndpoint(ctx context.Context, role string, r healthEndpoint, auth credentials, probe bool) endpointHealth {
+	h := endpointHealth{Role: role, Endpoint: redact(r.String()), Ready: true}
+	start := time.Now()
+	pingErr := r.ping(ctx)
+	h.add("info", time.Since(start), pingErr, "")
+
+	start = time.Now()
+	data, listErr := r.listCharts(ctx)
+	versions := 0
+	for _, v := range data {
+		versions += len(v)
+	}
+	h.add("index", time.Since(start), listErr, fmt.Sprintf("%d charts, %d versions", len(data), versions))
+
+	switch {
+	case ErrorKind(pingErr) == "unauthorized" || ErrorKind(listErr) == "unauthorized":
+		err := pingErr
+		if ErrorKind(err) != "unauthorized" {
+			err = listErr
+		}
+		h.add("auth", 0, err, "")
+	case pingErr != nil && listErr != nil:
+		h.skip("auth", "the endpoint couldn't be reached")
+	case hasCredentials(auth):
+		h.add("auth", 0, nil, "credentials accepted")
+	default:
+		h.add("auth", 0, nil, "anonymous")
+	}
+
+	dst, ok := r.(chartDestination)
+	switch {
+	case !probe || !ok:
+		return h
+	case listErr != nil:
+		h.skip("write", "the index couldn't be listed")
+		return h
+	}
+	if _, _, err := deleteURL(dst, healthChart, "0.0.0"); err != nil {
+		h.skip("write", err.Error()+", the probe chart would stay")
+		return h
+	}
+	version := fmt.Sprintf("0.0.0-health.%d", time.Now().Unix())
+	start = time.Now()
+	chart, err := benchChartData(healthChart, version, 64)
+	if err == nil {
+		if err = dst.pushChart(ctx, healthChart, version, chart); err == nil {
+			err = deleteChart(ctx, dst, healthChart, version)
+		}
+	}
+	h.add("write", time.Since(start), err, "uploaded and deleted "+healthChart+"-"+version)
+	return h
+}
+
+// runHealth checks that the source and destination are reachable, serve
+// their index, accept the credentials and, for the destination, take
+// uploads, exiting 1 when any of them isn't ready.
+func runHealth(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("health", flag.ExitOnError)
+	cf := newCommandFlags(fs, true, true)
+	tenant := fs.String("tenant", "", "org/repo path on a multitenant chartmuseum")
+	probe := fs.Bool("write", true, "probe write access to the destination by uploading and deleting a tiny chart")
+	timeout := fs.Duration("timeout", 30*time.Second, "time each endpoint has for its checks")
+	format := fs.String("o", "table", "output format, table or json")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cm_sync health [flags], with -s, -d or both")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	cfg := cf.config(fs)
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	checkSource := set["s"] || *cf.configFile != ""
+	checkDest := set["d"] || *cf.configFile != ""
+	if !checkSource && !checkDest || *format != "table" && *format != "json" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	t := normalizeTenant(*tenant)
+
+	var report []endpointHealth
+	check := func(role, ref string, open func() (healthEndpoint, error), auth credentials, probe bool) {
+		ctx, cancel := context.WithTimeout(ctx, *timeout)
+		defer cancel()
+		r, err := open()
+		if err != nil {
+			h := endpointHealth{Role: role, Endpoint: redact(ref)}
+			h.add("info", 0, err, "")
+			report = append(report, h)
+			return
+		}
+		report = append(report, checkEndpoint(ctx, role, r, auth, probe))
+	}
+	if checkSource {
+		check("source", cfg.Source, func() (healthEndpoint, error) {
+			if cfg.SourceType == "" && cfg.SourceIndex == "" && isHTTP(cfg.Source) {
+				cfg.SourceType = detectServerType(ctx, cfg.Source, cfg.SourceAuth, cfg.client)
+			}
+			src, err := cfg.newSource(t, cfg.syncOptions)
+			return src, err
+		}, withStoredLogin(cfg.Source, cfg.SourceAuth), false)
+	}
+	if checkDest {
+		check("destination", cfg.Destination, func() (healthEndpoint, error) {
+			if cfg.DestinationType == "" && cfg.DestinationIndex == "" && isHTTP(cfg.Destination) {
+				cfg.DestinationType = detectServerType(ctx, cfg.Destination, cfg.DestinationAuth, cfg.client)
+			}
+			dst, err := cfg.newDestination(t, cfg.syncOptions)
+			return dst, err
+		}, withStoredLogin(cfg.Destination, cfg.DestinationAuth), *probe)
+	}
+
+	ready := true
+	for _, h := range report {
+		ready = ready && h.Ready
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "ENDPOINT\tCHECK\tSTATUS\tTIME\tDETAIL")
+		for _, h := range report {
+			for _, c := range h.Checks {
+				elapsed := "-"
+				if c.Seconds > 0 {
+					elapsed = roundDuration(time.Duration(c.Seconds * float64(time.Second))).String()
+				}
+				fmt.Fprintf(tw, "%s %s\t%s\t%s\t%s\t%s\n", h.Role, h.Endpoint, c.Check, c.Status, elapsed, c.Detail)
+			}
+		}
+		tw.Flush()
+		for _, h := range report {
+			state := "ready"
+			if !h.Ready {
+				state = "NOT ready"
+			}
+			outln(h.Role, h.Endpoint, "is", state)
+		}
+	}
+	if !ready {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,218 @@
+package chartsync
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// historyKeep is the number of runs kept in the history.
+const historyKeep = 1000
+
+// runRecord is a run in the history, with its summary.
+type runRecord struct {
+	ID          string    `json:"id"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	Jobs        []string  `json:"jobs"`
+	Interrupted bool      `json:"interrupted,omitempty"`
+	summaryJSON
+}
+
+// runHistory keeps the summaries of past runs in history.jsonl of
+// -index-cache, one run per line, oldest first.
+type runHistory struct {
+	mu   sync.Mutex
+	path string
+}
+
+func newRunHistory(dir string) *runHistory {
+	return &runHistory{path: filepath.Join(dir, "history.jsonl")}
+}
+
+func (h *runHistory) load() ([]runRecord, error) {
+	f, err := os.Open(h.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var runs []runRecord
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 64<<20)
+	for sc.Scan() {
+		var r runRecord
+		if err := json.Unmarshal(sc.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", h.path, err)
+		}
+		runs = append(runs, r)
+	}
+	return runs, sc.Err()
+}
+
+// newRunRecord is the record of the run of summary, over jobs.
+func newRunRecord(summary *runSummary, jobs []string, interrupted bool) runRecord {
+	return runRecord{
+		ID:          summary.start.UTC().Format("20060102-150405"),
+		Start:       summary.start.UTC(),
+		End:         time.Now().UTC(),
+		Jobs:        jobs,
+		Interrupted: interrupted,
+		summaryJSON: summary.json(),
+	}
+}
+
+// record adds a run, dropping the oldest beyond historyKeep.
+func (h *runHistory) record(r runRecord) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	runs, err := h.load()
+	if err != nil {
+		return err
+	}
+	if n := len(runs); n > 0 && runs[n-1].ID >= r.ID {
+		r.ID = fmt.Sprintf("%s-%d", r.ID, n)
+	}
+	runs = append(runs, r)
+	if len(runs) > historyKeep {
+		runs = runs[len(runs)-historyKeep:]
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, r := range runs {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, h.path)
+}
+
+// runHistoryCommand lists the past runs, or shows one of them.
+func runHistoryCommand(args []string) {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	cf := newCommandFlags(fs, false, false)
+	dir := fs.String("index-cache", "", "the -index-cache directory of the syncs")
+	last := fs.Int("n", 20, "number of runs to list, newest last, 0 lists all")
+	format := fs.String("o", "table", "output format, table or json")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cm_sync history [flags], or history show [flags] RUN-ID")
+		fs.PrintDefaults()
+	}
+	show := len(args) > 0 && args[0] == "show"
+	if show {
+		args = args[1:]
+	}
+	fs.Parse(args)
+	if show && fs.NArg() != 1 || !show && fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	cfg := cf.config(fs)
+	if *dir != "" {
+		cfg.IndexCache = *dir
+	}
+	if cfg.IndexCache == "" {
+		logln("Error: the history is kept in -index-cache, pass it or -config")
+		os.Exit(1)
+	}
+	runs, err := newRunHistory(cfg.IndexCache).load()
+	if err != nil {
+		logln("Error loading history:", err)
+		os.Exit(1)
+	}
+
+	if show {
+		for _, r := range runs {
+			if r.ID == fs.Arg(0) {
+				if err := printRun(os.Stdout, r, *format); err != nil {
+					logln("Error printing run:", err)
+					os.Exit(1)
+				}
+				return
+			}
+		}
+		logln("No run", fs.Arg(0), "in the history")
+		os.Exit(1)
+	}
+
+	if *last > 0 && len(runs) > *last {
+		runs = runs[len(runs)-*last:]
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if runs == nil {
+			runs = []runRecord{}
+		}
+		enc.Encode(runs)
+		return
+	}
+	if len(runs) == 0 {
+		logln("No runs recorded")
+		return
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RUN\tSTARTED\tDURATION\tEXAMINED\tSYNCED\tSKIPPED\tFAILED\tTRANSFERRED")
+	for _, r := range runs {
+		id := r.ID
+		if r.Interrupted {
+			id += " (interrupted)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\n", id, r.Start.Local().Format("2006-01-02 15:04"), r.End.Sub(r.Start).Round(time.Second),
+			r.Examined, r.Synced, r.Skipped, r.Failed, formatSize(r.Bytes))
+	}
+	tw.Flush()
+}
+
+func printRun(w io.Writer, r runRecord, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "Run\t", r.ID)
+	fmt.Fprintln(tw, "Started\t", r.Start.Local().Format(time.RFC1123))
+	fmt.Fprintln(tw, "Finished\t", r.End.Local().Format(time.RFC1123))
+	if r.Interrupted {
+		fmt.Fprintln(tw, "Interrupted\t", "yes")
+	}
+	for _, job := range r.Jobs {
+		fmt.Fprintln(tw, "Job\t", job)
+	}
+	fmt.Fprintln(tw, "Charts examined\t", r.Examined)
+	fmt.Fprintln(tw, "Versions synced\t", r.Synced)
+	fmt.Fprintln(tw, "Versions skipped\t", r.Skipped)
+	fmt.Fprintln(tw, "Versions failed\t", r.Failed)
+	fmt.Fprintln(tw, "Transferred\t", formatSize(r.Bytes))
+	tw.Flush()
+	printNotes(w, r.ReleaseNotes)
+	if len(r.Errors) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w, "\nFailed versions:")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, e := range r.Errors {
+		kind := e.Kind
+		if kind == "" {
+			kind = "other"
+		}
+		fmt.Fprintf(tw, "  %s-%s\t%s\t%s\n", e.Chart, e.Version, kind, e.Error)
+	}
+	return tw.Flush()
+}
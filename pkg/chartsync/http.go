@@ -0,0 +1,200 @@
+package chartsync
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// httpClient is shared by every http backend without a client of its own,
+// so parallel transfers reuse pooled keep-alive connections (HTTP/2 where
+// the server offers it) instead of dialing for each request.
+var httpClient = &http.Client{Transport: throttledTransport{hostTransport{}}}
+
+var transport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   16,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// limitClient returns a copy of c whose requests are held to the request
+// and bandwidth limits, through the default transport when it has none.
+// Without c it is httpClient.
+func limitClient(c *http.Client) *http.Client {
+	if c == nil {
+		return httpClient
+	}
+	client := *c
+	if client.Transport == nil {
+		client.Transport = hostTransport{}
+	}
+	client.Transport = throttledTransport{client.Transport}
+	return &client
+}
+
+// clientOr is c, or httpClient when it is nil.
+func clientOr(c *http.Client) *http.Client {
+	if c == nil {
+		return httpClient
+	}
+	return c
+}
+
+// poolConnections grows the idle pool to fit the number of parallel
+// workers, each of them keeps a connection to the source and destination.
+func poolConnections(workers int) {
+	t := transport
+	if workers*2 > t.MaxIdleConnsPerHost {
+		t.MaxIdleConnsPerHost = workers * 2
+	}
+	if t.MaxIdleConnsPerHost*2 > t.MaxIdleConns {
+		t.MaxIdleConns = t.MaxIdleConnsPerHost * 2
+	}
+	tlsMu.Lock()
+	defer tlsMu.Unlock()
+	for _, c := range tlsTransports {
+		c.MaxIdleConnsPerHost, c.MaxIdleConns = t.MaxIdleConnsPerHost, t.MaxIdleConns
+	}
+}
+
+// tlsTransports are the clones of transport with the client certificate
+// and CAs of a host, by host.
+var (
+	tlsMu         sync.Mutex
+	tlsTransports = map[string]*tlsTransport{}
+)
+
+type tlsTransport struct {
+	// files are the certificate, key and CA files it was loaded from.
+	files string
+	*http.Transport
+}
+
+// hostTransport sends requests through the tls transport of their host,
+// those to other hosts through transport.
+type hostTransport struct{}
+
+func (hostTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tlsMu.Lock()
+	t := tlsTransports[req.URL.Host]
+	tlsMu.Unlock()
+	if t == nil {
+		return transport.RoundTrip(req)
+	}
+	return t.RoundTrip(req)
+}
+
+// useTLS sends the requests to host with the client certificate and CAs
+// of auth, hosts without either keep the default transport.
+func useTLS(host string, auth credentials) error {
+	if auth.CertFile == "" && auth.CAFile == "" {
+		return nil
+	}
+	files := auth.CertFile + "\x00" + auth.KeyFile + "\x00" + auth.CAFile
+	tlsMu.Lock()
+	defer tlsMu.Unlock()
+	if t := tlsTransports[host]; t != nil && t.files == files {
+		return nil
+	}
+	cfg := &tls.Config{}
+	if auth.CertFile != "" {
+		key := auth.KeyFile
+		if key == "" {
+			key = auth.CertFile
+		}
+		cert, err := tls.LoadX509KeyPair(auth.CertFile, key)
+		if err != nil {
+			return fmt.Errorf("error loading client certificate %s: %w", auth.CertFile, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	if auth.CAFile != "" {
+		data, err := os.ReadFile(auth.CAFile)
+		if err != nil {
+			return err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in %s", auth.CAFile)
+		}
+	}
+	t := transport.Clone()
+	t.TLSClientConfig = cfg
+	tlsTransports[host] = &tlsTransport{files, t}
+	return nil
+}
+
+// version is the version of cm_sync, set with
+// -ldflags "-X example.com/cm_sync/v2/pkg/chartsync.version=v2.3.0" or
+// read from the build info of go install.
+var version = ""
+
+// userAgent is sent with every request that doesn't set its own.
+var userAgent = "cm_sync/" + buildVersion()
+
+// requestIDHeader is the header every request carries the id of the
+// current run in, none when empty.
+var (
+	requestIDHeader string
+	requestID       atomic.Value
+)
+
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "dev"
+}
+
+// newRequestID starts a new run id for requestIDHeader and returns it.
+func newRequestID() string {
+	id := uuid.NewString()
+	requestID.Store(id)
+	return id
+}
+
+// currentRequestID is the id of the current run, empty without
+// requestIDHeader.
+func currentRequestID() string {
+	if requestIDHeader == "" {
+		return ""
+	}
+	id, _ := requestID.Load().(string)
+	return id
+}
+
+// identify adds the User-Agent and the run id to a copy of req.
+func identify(req *http.Request) *http.Request {
+	id := currentRequestID()
+	if req.Header.Get("User-Agent") != "" && id == "" {
+		return req
+	}
+	req = req.Clone(req.Context())
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	if id != "" {
+		req.Header.Set(requestIDHeader, id)
+	}
+	return req
+}
@@ -0,0 +1,106 @@
+package chartsync
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+type repoIndex struct {
+	Entries ChartData `yaml:"entries"`
+}
+
+// fetchIndex reads the index.yaml of the helm repository at repoURL.
+func fetchIndex(ctx context.Context, r repo, repoURL string) (ChartData, error) {
+	body, err := getIndex(ctx, r, repoURL+"/index.yaml")
+	if err != nil {
+		return nil, err
+	}
+	var idx repoIndex
+	if err := yaml.Unmarshal(body, &idx); err != nil {
+		return nil, fmt.Errorf("error decoding index.yaml: %w", err)
+	}
+	return idx.Entries, nil
+}
+
+// indexChartURL returns the download url of a version listed in an index,
+// urls in the index are relative to the repository url.
+func indexChartURL(data ChartData, repoURL, chart, version string) (string, error) {
+	for _, cv := range data[chart] {
+		if cv.Version == version {
+			if len(cv.URLs) == 0 {
+				return "", fmt.Errorf("index of %s has no url for %s-%s", repoURL, chart, version)
+			}
+			return resolveRef(repoURL, cv.URLs[0])
+		}
+	}
+	return "", fmt.Errorf("%s-%s is not in the index of %s", chart, version, repoURL)
+}
+
+// listings are the chart lists last fetched from each repository url, for
+// the download urls they hold.
+var listings sync.Map
+
+func rememberListing(repoURL string, data ChartData) {
+	listings.Store(repoURL, data)
+}
+
+// downloadURL returns the url the listing of repoURL gives for a version.
+// Versions without one, or not listed yet, fall back to the conventional
+// charts/<name>-<version>.tgz path.
+func downloadURL(repoURL, chart, version string) string {
+	if data, ok := listings.Load(repoURL); ok {
+		if u, err := indexChartURL(data.(ChartData), repoURL, chart, version); err == nil {
+			return u
+		}
+	}
+	return chartURL(repoURL, chart, version)
+}
+
+// resolveRef resolves a url from an index against the repository url,
+// keeping the escaping the index uses.
+func resolveRef(repo, ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	if u.IsAbs() {
+		return ref, nil
+	}
+	base, err := url.Parse(repo)
+	if err != nil {
+		return "", err
+	}
+	return base.JoinPath(u.EscapedPath()).String(), nil
+}
+
+// escapePath escapes a chart file name or object key for use as one path
+// segment of a url. Unlike url.PathEscape it also escapes +, which some
+// servers and object stores decode as a space, e.g. in 1.2.3+build.5.
+func escapePath(s string) string {
+	return strings.ReplaceAll(url.PathEscape(s), "+", "%2B")
+}
+
+func hasVersion(data ChartData, chart, version string) bool {
+	for _, cv := range data[chart] {
+		if cv.Version == version {
+			return true
+		}
+	}
+	return false
+}
+
+// indexDigest returns the sha256 of a chart version from the index, empty
+// if the index doesn't have one.
+func indexDigest(data ChartData, chart, version string) string {
+	for _, cv := range data[chart] {
+		if cv.Version == version {
+			return strings.TrimPrefix(cv.Digest, "sha256:")
+		}
+	}
+	return ""
+}
@@ -0,0 +1,42 @@
+package chartsync
+
+import "testing"
+
+func TestEscapePath(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"web-1.0.0.tgz", "web-1.0.0.tgz"},
+		{"web-1.2.3+build.5.tgz", "web-1.2.3%2Bbuild.5.tgz"},
+		{"my chart-1.0.0.tgz", "my%20chart-1.0.0.tgz"},
+		{"a/b-1.0.0.tgz", "a%2Fb-1.0.0.tgz"},
+		{"web-1.0.0-rc.1%.tgz", "web-1.0.0-rc.1%25.tgz"},
+	}
+	for _, tt := range tests {
+		if got := escapePath(tt.in); got != tt.want {
+			t.Errorf("escapePath(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestResolveRef(t *testing.T) {
+	tests := []struct{ repo, ref, want string }{
+		{"https://charts.example.com", "charts/web-1.0.0.tgz", "https://charts.example.com/charts/web-1.0.0.tgz"},
+		{"https://charts.example.com/repo", "web-1.0.0.tgz", "https://charts.example.com/repo/web-1.0.0.tgz"},
+		{"https://charts.example.com/repo/", "web-1.0.0.tgz", "https://charts.example.com/repo/web-1.0.0.tgz"},
+		{"https://charts.example.com", "charts/web-1.2.3%2Bbuild.5.tgz", "https://charts.example.com/charts/web-1.2.3%2Bbuild.5.tgz"},
+		{"https://charts.example.com", "https://cdn.example.com/web-1.0.0.tgz", "https://cdn.example.com/web-1.0.0.tgz"},
+		{"https://charts.example.com/repo", "../../etc/web-1.0.0.tgz", "https://charts.example.com/etc/web-1.0.0.tgz"},
+	}
+	for _, tt := range tests {
+		got, err := resolveRef(tt.repo, tt.ref)
+		if err != nil {
+			t.Errorf("resolveRef(%q, %q): %v", tt.repo, tt.ref, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("resolveRef(%q, %q) = %q, want %q", tt.repo, tt.ref, got, tt.want)
+		}
+	}
+	if _, err := resolveRef("https://charts.example.com", "%zz"); err == nil {
+		t.Error("resolveRef of an invalid ref should fail")
+	}
+}
@@ -0,0 +1,309 @@
+package chartsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// indexes caches the index responses of servers, they are requested again
+// with If-None-Match/If-Modified-Since and a 304 is answered from the
+// cache. The cache is kept in memory, and across runs in -index-cache.
+var indexes = &indexCache{entries: make(map[string]*cachedIndex), state: make(map[string]string)}
+
+type indexCache struct {
+	mu      sync.Mutex
+	dir     string
+	entries map[string]*cachedIndex
+	state   map[string]string
+}
+
+type cachedIndex struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Digest       string `json:"digest"`
+	Body         []byte `json:"body,omitempty"`
+	// data is the decoded list of a streamed index, whose body is only
+	// kept in a file next to the entry.
+	data ChartData
+}
+
+func cacheKey(s string) string {
+	return sha256Hex([]byte(s))
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// setIndexCache applies -index-cache.
+func setIndexCache(dir string) error {
+	if dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "synced"), 0o755); err != nil {
+		return err
+	}
+	indexes.dir = dir
+	return nil
+}
+
+func (c *indexCache) load(u string) *cachedIndex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[u]; ok {
+		return e
+	}
+	if c.dir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(filepath.Join(c.dir, cacheKey(u)+".json"))
+	if err != nil {
+		return nil
+	}
+	var e cachedIndex
+	if json.Unmarshal(data, &e) != nil || e.URL != u {
+		return nil
+	}
+	if e.Body == nil {
+		if _, err := os.Stat(c.bodyPath(u)); err != nil {
+			return nil
+		}
+	}
+	c.entries[u] = &e
+	return &e
+}
+
+func (c *indexCache) store(e *cachedIndex) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[e.URL] = e
+	if c.dir == "" || e.ETag == "" && e.LastModified == "" {
+		return
+	}
+	data, err := json.Marshal(e)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(c.dir, cacheKey(e.URL)+".json"), data, 0o644)
+	}
+	if err != nil {
+		logf("Failed to cache index of %s %v\n", e.URL, err)
+	}
+}
+
+// bodyPath is the file the body of a streamed index is cached in.
+func (c *indexCache) bodyPath(u string) string {
+	return filepath.Join(c.dir, cacheKey(u)+".body")
+}
+
+// digest is the sha256 of the last response for u, empty if it wasn't
+// fetched.
+func (c *indexCache) digest(u string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[u]; ok {
+		return e.Digest
+	}
+	return ""
+}
+
+// synced returns the index digests a source and destination had when a
+// sync between them last found nothing to transfer.
+func (c *indexCache) synced(key string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if state, ok := c.state[key]; ok {
+		return state
+	}
+	if c.dir == "" {
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join(c.dir, "synced", cacheKey(key)))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func (c *indexCache) markSynced(key, state string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.state[key] = state
+	if c.dir != "" {
+		os.WriteFile(filepath.Join(c.dir, "synced", cacheKey(key)), []byte(state), 0o644)
+	}
+}
+
+// getIndex fetches an index, conditionally when it is cached.
+func getIndex(ctx context.Context, r repo, u string) ([]byte, error) {
+	req, err := r.newRequest(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	cached := indexes.load(u)
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+	resp, err := r.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		return cached.Body, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, unexpectedStatus(resp.StatusCode)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	indexes.store(&cachedIndex{
+		URL:          u,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Digest:       sha256Hex(body),
+		Body:         body,
+	})
+	return body, nil
+}
+
+// streamIndex fetches a /api/charts list like getIndex, but decodes it as
+// it is read instead of holding the whole body and the list at once. With
+// -index-cache the body is written to a file for the next run, within a
+// run a 304 is answered with the list decoded before. Lists fetched for a
+// name window only hold the charts in it.
+func streamIndex(ctx context.Context, r repo, u string) (ChartData, error) {
+	req, err := r.newRequest(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	window := nameWindowOf(ctx)
+	cached := indexes.load(u)
+	if cached != nil && !indexes.hasList(cached) {
+		cached = nil
+	}
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+	resp, err := r.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		return cachedChartData(cached, window)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, unexpectedStatus(resp.StatusCode)
+	}
+
+	e := &cachedIndex{URL: u, ETag: resp.Header.Get("ETag"), LastModified: resp.Header.Get("Last-Modified")}
+	h := sha256.New()
+	w := io.Writer(h)
+	var tmp *os.File
+	if indexes.dir != "" && (e.ETag != "" || e.LastModified != "") {
+		if tmp, err = os.CreateTemp(indexes.dir, "index-*"); err != nil {
+			logf("Failed to cache index of %s %v\n", u, err)
+		} else {
+			defer os.Remove(tmp.Name())
+			w = io.MultiWriter(h, tmp)
+		}
+	}
+	body := io.TeeReader(resp.Body, w)
+	data, err := decodeChartData(body, window)
+	if err == nil {
+		// The digest and cached body cover what follows the json too.
+		_, err = io.Copy(io.Discard, body)
+	}
+	if tmp != nil {
+		if cerr := tmp.Close(); err == nil && cerr == nil {
+			if rerr := os.Rename(tmp.Name(), indexes.bodyPath(u)); rerr != nil {
+				logf("Failed to cache index of %s %v\n", u, rerr)
+				e.ETag, e.LastModified = "", ""
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	e.Digest = hex.EncodeToString(h.Sum(nil))
+	if window == (nameWindow{}) {
+		e.data = data
+	}
+	indexes.store(e)
+	return data, nil
+}
+
+// hasList tells if the chart list of e can be had without fetching it,
+// decoded or as a body.
+func (c *indexCache) hasList(e *cachedIndex) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e.data != nil || e.Body != nil {
+		return true
+	}
+	if c.dir == "" {
+		return false
+	}
+	_, err := os.Stat(c.bodyPath(e.URL))
+	return err == nil
+}
+
+// cachedChartData is the list of a cached index in w, decoded from its
+// body when it was cached by an earlier run.
+func cachedChartData(e *cachedIndex, w nameWindow) (ChartData, error) {
+	indexes.mu.Lock()
+	data := e.data
+	indexes.mu.Unlock()
+	if data != nil {
+		if w == (nameWindow{}) {
+			return data, nil
+		}
+		windowed := make(ChartData)
+		for chart, versions := range data {
+			if w.contains(chart) {
+				windowed[chart] = versions
+			}
+		}
+		return windowed, nil
+	}
+	var body io.ReadCloser = io.NopCloser(bytes.NewReader(e.Body))
+	if e.Body == nil {
+		f, err := os.Open(indexes.bodyPath(e.URL))
+		if err != nil {
+			return nil, err
+		}
+		body = f
+	}
+	defer body.Close()
+	data, err := decodeChartData(body, w)
+	if err != nil {
+		return nil, err
+	}
+	if w == (nameWindow{}) {
+		indexes.mu.Lock()
+		e.data = data
+		indexes.mu.Unlock()
+	}
+	return data, nil
+}
@@ -0,0 +1,196 @@
+package chartsync
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/term"
+)
+
+// keychainService is the service the logins of cm_sync are stored under
+// in the OS keychain, each by the server they are for.
+const keychainService = "cm_sync"
+
+// storedLogin is a login as kept in the keychain.
+type storedLogin struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+	APIKey   string `json:"api_key,omitempty"`
+}
+
+// loginKey is what the login of server is stored under, its scheme and
+// host, so every tenant and path on the server shares it.
+func loginKey(server string) string {
+	u, err := url.Parse(server)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// storedLogins caches the keychain lookups of the process, misses too.
+var (
+	loginsMu     sync.Mutex
+	storedLogins = map[string]*storedLogin{}
+)
+
+// withStoredLogin fills auth with the keychain login of server, unless
+// auth has credentials of its own.
+func withStoredLogin(server string, auth credentials) credentials {
+	if auth.Username != "" || auth.Token != "" || auth.APIKey != "" || auth.OIDCTokenURL != "" || auth.Type != "" {
+		return auth
+	}
+	key := loginKey(server)
+	if key == "" {
+		return auth
+	}
+	loginsMu.Lock()
+	login, ok := storedLogins[key]
+	if !ok {
+		login = lookupLogin(key)
+		storedLogins[key] = login
+	}
+	loginsMu.Unlock()
+	if login != nil {
+		addSecrets(credentials{Password: login.Password, Token: login.Token, APIKey: login.APIKey})
+		auth.Username, auth.Password = login.Username, login.Password
+		auth.Token, auth.APIKey = login.Token, login.APIKey
+	}
+	return auth
+}
+
+// lookupLogin reads the login stored for key, nil when there is none or
+// the machine has no keychain. Without a session bus the Secret Service
+// isn't asked, so headless servers don't start one.
+func lookupLogin(key string) *storedLogin {
+	if runtime.GOOS != "darwin" && runtime.GOOS != "windows" && os.Getenv("DBUS_SESSION_BUS_ADDRESS") == "" {
+		return nil
+	}
+	secret, err := keyring.Get(keychainService, key)
+	if err != nil {
+		return nil
+	}
+	var login storedLogin
+	if err := json.Unmarshal([]byte(secret), &login); err != nil {
+		logln("Failed to read login of", key, "from the keychain:", err)
+		return nil
+	}
+	return &login
+}
+
+// runLogin stores the credentials of a server in the OS keychain, later
+// runs use them for the server when none are configured.
+func runLogin(args []string) {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	username := fs.String("username", "", "username for basic auth, asked for if empty and neither -token nor -api-key is set")
+	passwordStdin := fs.Bool("password-stdin", false, "read the password, token or api key from stdin instead of asking")
+	token := fs.Bool("token", false, "store a bearer or private token instead of a password")
+	apiKey := fs.Bool("api-key", false, "store an artifactory api key instead of a password")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cm_sync login [flags] SERVER")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 || *token && *apiKey {
+		fs.Usage()
+		os.Exit(1)
+	}
+	key := loginKey(fs.Arg(0))
+	if key == "" {
+		logln("Error: not a server url:", fs.Arg(0))
+		os.Exit(1)
+	}
+
+	stdin := bufio.NewReader(os.Stdin)
+	login := storedLogin{Username: *username}
+	if login.Username == "" && !*token && !*apiKey {
+		login.Username = strings.TrimSpace(prompt(stdin, "Username: ", false))
+	}
+	kind := "Password"
+	switch {
+	case *token:
+		kind = "Token"
+	case *apiKey:
+		kind = "API key"
+	}
+	var secret string
+	if *passwordStdin {
+		secret = strings.TrimRight(prompt(stdin, "", false), "\r\n")
+	} else {
+		secret = prompt(stdin, kind+": ", true)
+	}
+	if secret == "" {
+		logln("Error: no", strings.ToLower(kind), "given")
+		os.Exit(1)
+	}
+	switch {
+	case *token:
+		login.Token = secret
+	case *apiKey:
+		login.APIKey = secret
+	default:
+		login.Password = secret
+	}
+
+	data, _ := json.Marshal(login)
+	if err := keyring.Set(keychainService, key, string(data)); err != nil {
+		logln("Error storing login in the keychain:", key, "\n", err)
+		os.Exit(1)
+	}
+	logln("Stored login for", key, "in the keychain")
+}
+
+// runLogout removes the login of a server from the keychain.
+func runLogout(args []string) {
+	fs := flag.NewFlagSet("logout", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cm_sync logout SERVER")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	key := loginKey(fs.Arg(0))
+	err := keyring.Delete(keychainService, key)
+	if errors.Is(err, keyring.ErrNotFound) {
+		logln("No login stored for", key)
+		return
+	}
+	if err != nil {
+		logln("Error removing login from the keychain:", key, "\n", err)
+		os.Exit(1)
+	}
+	logln("Removed login for", key)
+}
+
+// prompt reads a line from stdin after printing label, without echo for
+// secrets typed into a terminal.
+func prompt(stdin *bufio.Reader, label string, secret bool) string {
+	fmt.Fprint(os.Stderr, label)
+	if secret && term.IsTerminal(int(os.Stdin.Fd())) {
+		b, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			logln("Error reading", strings.TrimSuffix(label, ": "), "\n", err)
+			os.Exit(1)
+		}
+		return string(b)
+	}
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return ""
+	}
+	return strings.TrimRight(line, "\r\n")
+}
@@ -0,0 +1,105 @@
+package chartsync
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sortChartVersions orders versions by chart name and newest version
+// first, or newest first by creation time with by set to created.
+func sortChartVersions(versions []ChartVersion, by string) error {
+	byName := func(a, b ChartVersion) bool {
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return newerVersion(a.Version, b.Version)
+	}
+	switch by {
+	case "name":
+		sort.Slice(versions, func(i, j int) bool { return byName(versions[i], versions[j]) })
+	case "created":
+		sort.Slice(versions, func(i, j int) bool {
+			if !versions[i].Created.Equal(versions[j].Created) {
+				return versions[i].Created.After(versions[j].Created)
+			}
+			return byName(versions[i], versions[j])
+		})
+	default:
+		return fmt.Errorf("unknown sort order %q, use name or created", by)
+	}
+	return nil
+}
+
+// writeVersions prints versions as a table, json or yaml.
+func writeVersions(w io.Writer, versions []ChartVersion, format string) error {
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "CHART\tVERSION\tAPP VERSION\tCREATED")
+		for _, cv := range versions {
+			created := ""
+			if !cv.Created.IsZero() {
+				created = cv.Created.Format("2006-01-02 15:04")
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", cv.Name, cv.Version, cv.AppVersion, created)
+		}
+		return tw.Flush()
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(versions)
+	case "yaml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(versions); err != nil {
+			return err
+		}
+		return enc.Close()
+	}
+	return fmt.Errorf("unknown output format %q, use table, json or yaml", format)
+}
+
+// runList prints the charts and versions of a source, replacing curl and
+// jq on /api/charts or index.yaml.
+func runList(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("list", flag.ExitOnError)
+	sf := addSourceFlags(fs)
+	chart := fs.String("chart", "", "only list the versions of this chart")
+	format := fs.String("o", "table", "output format, table, json or yaml")
+	sortBy := fs.String("sort", "name", "sort by name (newest version first) or created (newest first)")
+	reverse := fs.Bool("reverse", false, "reverse the sort order")
+	fs.Parse(args)
+	if err := writeVersions(io.Discard, nil, *format); err != nil {
+		logln("Error parsing -o:", err)
+		os.Exit(1)
+	}
+	cfg := sf.config(fs)
+	_, data := openSource(ctx, cfg, "")
+
+	versions := []ChartVersion{}
+	for name, cvs := range data {
+		if *chart == "" || name == *chart {
+			versions = append(versions, cvs...)
+		}
+	}
+	if err := sortChartVersions(versions, *sortBy); err != nil {
+		logln("Error parsing -sort:", err)
+		os.Exit(1)
+	}
+	if *reverse {
+		slices.Reverse(versions)
+	}
+	if err := writeVersions(os.Stdout, versions, *format); err != nil {
+		logln("Error writing list:", err)
+		os.Exit(1)
+	}
+}
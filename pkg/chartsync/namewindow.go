@@ -0,0 +1,103 @@
+package chartsync
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// nameWindow is a range of chart names, From included and To not, empty
+// bounds are open.
+type nameWindow struct {
+	From, To string
+}
+
+func (w nameWindow) contains(chart string) bool {
+	return (w.From == "" || chart >= w.From) && (w.To == "" || chart < w.To)
+}
+
+func (w nameWindow) String() string {
+	switch {
+	case w.From == "" && w.To == "":
+		return "all charts"
+	case w.From == "":
+		return "charts before " + w.To
+	case w.To == "":
+		return "charts from " + w.From
+	}
+	return "charts from " + w.From + " before " + w.To
+}
+
+// parseNameWindows splits the chart names at the given bounds, g,n,t are
+// the windows up to g, g to n, n to t and from t on.
+func parseNameWindows(bounds []string) ([]nameWindow, error) {
+	windows := []nameWindow{{}}
+	for i, b := range bounds {
+		if i > 0 && b <= bounds[i-1] {
+			return nil, fmt.Errorf("name window bounds must be sorted, %q comes after %q", b, bounds[i-1])
+		}
+		windows[len(windows)-1].To = b
+		windows = append(windows, nameWindow{From: b})
+	}
+	return windows, nil
+}
+
+type nameWindowKey struct{}
+
+// withNameWindow has the chart lists fetched with ctx only decode the
+// charts in w, where the server's list can be read as a stream.
+func withNameWindow(ctx context.Context, w nameWindow) context.Context {
+	return context.WithValue(ctx, nameWindowKey{}, w)
+}
+
+func nameWindowOf(ctx context.Context) nameWindow {
+	w, _ := ctx.Value(nameWindowKey{}).(nameWindow)
+	return w
+}
+
+// windowCheckpoint remembers how many name windows of a run are done in
+// -index-cache, a run that was cut short picks up at the next window.
+type windowCheckpoint struct {
+	path string
+}
+
+func newWindowCheckpoint(dir string, jobs []syncJob, windows []nameWindow) *windowCheckpoint {
+	if dir == "" {
+		return nil
+	}
+	key := fmt.Sprint(windows)
+	for _, job := range jobs {
+		key += " " + jobKey(job.source, job.destination)
+	}
+	return &windowCheckpoint{filepath.Join(dir, "synced", "windows-"+cacheKey(key))}
+}
+
+func (c *windowCheckpoint) done() int {
+	if c == nil {
+		return 0
+	}
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+	return n
+}
+
+func (c *windowCheckpoint) save(done int) {
+	if c == nil {
+		return
+	}
+	if err := os.WriteFile(c.path, []byte(strconv.Itoa(done)), 0o644); err != nil {
+		logln("Failed to save name window checkpoint", err)
+	}
+}
+
+func (c *windowCheckpoint) clear() {
+	if c != nil {
+		os.Remove(c.path)
+	}
+}
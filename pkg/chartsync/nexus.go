@@ -0,0 +1,88 @@
+package chartsync
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// nexusRepo is a hosted helm repository in Nexus Repository 3, server is
+// the Nexus base url and the tenant the repository name.
+type nexusRepo struct {
+	repo
+}
+
+// newNexusRepo splits the repository name off the url when no tenant is
+// given, e.g. https://nexus.example.com/repository/helm-hosted.
+func newNexusRepo(server, name string, auth credentials, client *http.Client) nexusRepo {
+	server = strings.TrimSuffix(server, "/")
+	if base, rest, ok := strings.Cut(server, "/repository/"); ok && name == "" {
+		server, name = base, rest
+	}
+	return nexusRepo{repo{server: server, tenant: name, auth: auth, client: client}}
+}
+
+func (n nexusRepo) url() string {
+	return n.server + "/repository/" + n.tenant
+}
+
+func (n nexusRepo) String() string {
+	return n.url()
+}
+
+func (n nexusRepo) ping(ctx context.Context) error {
+	resp, err := n.get(ctx, n.server+"/service/rest/v1/status")
+	if err != nil {
+		return fmt.Errorf("error making request: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return unexpectedStatus(resp.StatusCode)
+	}
+	return nil
+}
+
+func (n nexusRepo) hasChart(ctx context.Context, chart, version string) (bool, error) {
+	return headExists(ctx, n.repo, n.url()+"/"+escapePath(chart+"-"+version+".tgz"))
+}
+
+func (n nexusRepo) indexVersion() string {
+	return indexes.digest(n.url() + "/index.yaml")
+}
+
+func (n nexusRepo) listCharts(ctx context.Context) (ChartData, error) {
+	if n.tenant == "" {
+		return nil, fmt.Errorf("nexus needs a repository name, pass it with -tenants or in the url path")
+	}
+	return fetchIndex(ctx, n.repo, n.url())
+}
+
+// pushChart uses the component upload api, Nexus rebuilds index.yaml of
+// the hosted repository itself.
+func (n nexusRepo) pushChart(ctx context.Context, chart, version string, data []byte) error {
+	return n.pushChartStream(ctx, chart, version, bytes.NewReader(data), int64(len(data)))
+}
+
+func (n nexusRepo) pushChartStream(ctx context.Context, chart, version string, data io.Reader, size int64) error {
+	body, contentType, length := multipartBody("helm.asset", chart+"-"+version+".tgz", data, size)
+	u := n.server + "/service/rest/v1/components?repository=" + url.QueryEscape(n.tenant)
+	req, err := n.newRequest(ctx, "POST", u, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = length
+	req.Header.Set("Content-Type", contentType)
+	resp, err := n.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return unexpectedStatus(resp.StatusCode)
+	}
+	return nil
+}
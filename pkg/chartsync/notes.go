@@ -0,0 +1,183 @@
+package chartsync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// changesAnnotation lists the changes of a chart version, see
+// https://artifacthub.io/docs/topics/annotations/helm/.
+const changesAnnotation = "artifacthub.io/changes"
+
+// maxNoteLines is the most README lines a note lists, and maxDiffLines the
+// longest README diffed.
+const (
+	maxNoteLines = 20
+	maxDiffLines = 2000
+)
+
+// releaseNote is what changed in a synced version since the previous
+// version of the source.
+type releaseNote struct {
+	Chart    string `json:"chart"`
+	Version  string `json:"version"`
+	Previous string `json:"previous,omitempty"`
+	// From is artifacthub.io/changes or README.md.
+	From    string   `json:"from"`
+	Changes []string `json:"changes"`
+}
+
+// releaseNotes reads the notes of item from the artifacthub.io/changes
+// annotation in data, or diffs the README.md of the chart with the
+// previous version's. sp is the downloaded chart, nil when it was
+// streamed. It returns nil when there is nothing to tell.
+func releaseNotes(ctx context.Context, source chartSource, data ChartData, item syncItem, sp *spool) (*releaseNote, error) {
+	note := &releaseNote{Chart: item.Chart, Version: item.Version}
+	for _, cv := range data[item.Chart] {
+		switch {
+		case cv.Version == item.Version:
+			if changes := cv.Annotations[changesAnnotation]; changes != "" {
+				note.From = changesAnnotation
+				note.Changes = parseChanges(changes)
+			}
+		case newerVersion(item.Version, cv.Version) && (note.Previous == "" || newerVersion(cv.Version, note.Previous)):
+			note.Previous = cv.Version
+		}
+	}
+	if len(note.Changes) > 0 {
+		return note, nil
+	}
+	if note.Previous == "" {
+		return nil, nil
+	}
+
+	var r io.Reader
+	if sp != nil {
+		r = sp.reader()
+	} else {
+		s, err := fetchSpool(ctx, source, item.Chart, item.Version, indexDigest(data, item.Chart, item.Version))
+		if err != nil {
+			return nil, err
+		}
+		defer s.close()
+		r = s.reader()
+	}
+	current, err := chartFiles(r, "README.md")
+	if err != nil {
+		return nil, err
+	}
+	prev, err := fetchSpool(ctx, source, item.Chart, note.Previous, indexDigest(data, item.Chart, note.Previous))
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s-%s: %w", item.Chart, note.Previous, err)
+	}
+	defer prev.close()
+	previous, err := chartFiles(prev.reader(), "README.md")
+	if err != nil {
+		return nil, err
+	}
+	note.From = "README.md"
+	note.Changes = diffLines(string(previous["README.md"]), string(current["README.md"]))
+	if len(note.Changes) == 0 {
+		return nil, nil
+	}
+	return note, nil
+}
+
+// parseChanges reads the artifacthub.io/changes annotation, a yaml list
+// of strings or of kind and description maps.
+func parseChanges(s string) []string {
+	var entries []any
+	if err := yaml.Unmarshal([]byte(s), &entries); err != nil {
+		return []string{strings.TrimSpace(s)}
+	}
+	var changes []string
+	for _, e := range entries {
+		switch e := e.(type) {
+		case string:
+			changes = append(changes, e)
+		case map[string]any:
+			desc := fmt.Sprint(e["description"])
+			if kind, ok := e["kind"].(string); ok && kind != "" {
+				desc = kind + ": " + desc
+			}
+			changes = append(changes, desc)
+		}
+	}
+	return changes
+}
+
+// diffLines lists the lines removed from a with a - and those added to b
+// with a +, blank lines left out, up to maxNoteLines.
+func diffLines(a, b string) []string {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+	if len(x) > maxDiffLines || len(y) > maxDiffLines {
+		return []string{"README.md changed, it is too long to compare"}
+	}
+	// lcs[i][j] is the longest common subsequence of x[i:] and y[j:].
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var lines []string
+	add := func(prefix, line string) {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, prefix+" "+strings.TrimSpace(line))
+		}
+	}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			i, j = i+1, j+1
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			add("-", x[i])
+			i++
+		default:
+			add("+", y[j])
+			j++
+		}
+	}
+	if len(lines) > maxNoteLines {
+		lines = append(lines[:maxNoteLines], fmt.Sprintf("... %d more lines", len(lines)-maxNoteLines))
+	}
+	return lines
+}
+
+// printNotes writes the release notes section of the summary.
+func printNotes(w io.Writer, notes []releaseNote) {
+	if len(notes) == 0 {
+		return
+	}
+	sort.Slice(notes, func(i, j int) bool {
+		a, b := notes[i], notes[j]
+		if a.Chart != b.Chart {
+			return a.Chart < b.Chart
+		}
+		return newerVersion(a.Version, b.Version)
+	})
+	fmt.Fprintln(w, "\nRelease notes:")
+	for _, n := range notes {
+		since := ""
+		if n.Previous != "" {
+			since = " since " + n.Previous
+		}
+		fmt.Fprintf(w, "  %s-%s%s, from %s:\n", n.Chart, n.Version, since, n.From)
+		for _, c := range n.Changes {
+			fmt.Fprintf(w, "    %s\n", c)
+		}
+	}
+}
@@ -0,0 +1,415 @@
+package chartsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+const (
+	ociManifestMediaType    = "application/vnd.oci.image.manifest.v1+json"
+	helmChartLayerMediaType = "application/vnd.cncf.helm.chart.content.v1.tar+gzip"
+)
+
+// ociSource reads helm charts stored as OCI artifacts, every repository
+// below namespace is a chart and its tags are the chart versions.
+type ociSource struct {
+	scheme    string
+	host      string
+	namespace string
+	auth      credentials
+	client    *http.Client
+	// login replaces auth for registries with short lived credentials.
+	login func(ctx context.Context) (credentials, error)
+	// charts is used instead of the catalog on registries that don't
+	// implement /v2/_catalog.
+	charts []string
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+func newOCISource(ref string, plainHTTP bool, auth credentials, charts []string, client *http.Client) *ociSource {
+	ref = strings.TrimPrefix(ref, "oci://")
+	host, namespace, _ := strings.Cut(ref, "/")
+	s := &ociSource{
+		scheme:    "https",
+		host:      host,
+		namespace: strings.Trim(namespace, "/"),
+		auth:      auth,
+		client:    client,
+		tokens:    make(map[string]string),
+	}
+	if plainHTTP {
+		s.scheme = "http"
+	}
+	if auth.Username == "" {
+		if ecr := newECRAuth(host, auth.AWSProfile, client); ecr != nil {
+			s.login = ecr.credentials
+		} else if isGCPRegistryHost(host) {
+			s.login = (&gcpAuth{file: auth.GoogleCredentials, client: client}).credentials
+		} else if isACRHost(host) {
+			s.login = newACRAuth(host, client).credentials
+		}
+	}
+	for _, c := range charts {
+		if !strings.ContainsAny(c, "*?[") {
+			s.charts = append(s.charts, c)
+		}
+	}
+	return s
+}
+
+func (s *ociSource) String() string {
+	if s.namespace == "" {
+		return "oci://" + s.host
+	}
+	return "oci://" + s.host + "/" + s.namespace
+}
+
+func (s *ociSource) credentials(ctx context.Context) (credentials, error) {
+	if s.login != nil {
+		return s.login(ctx)
+	}
+	return s.auth, nil
+}
+
+func (s *ociSource) repository(chart string) string {
+	if s.namespace == "" {
+		return chart
+	}
+	return s.namespace + "/" + chart
+}
+
+// do sends the request, answering a 401 with the registry's token or basic
+// auth challenge once.
+func (s *ociSource) do(req *http.Request, scope string) (*http.Response, error) {
+	ctx := req.Context()
+	if err := useTLS(s.host, s.auth); err != nil {
+		return nil, err
+	}
+	for k, v := range s.auth.Headers {
+		req.Header.Set(k, v)
+	}
+	s.mu.Lock()
+	token := s.tokens[scope]
+	s.mu.Unlock()
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if s.login != nil {
+		creds, err := s.login(ctx)
+		if err != nil {
+			return nil, err
+		}
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+
+	retry := req.Clone(req.Context())
+	scheme, params := parseChallenge(challenge)
+	switch scheme {
+	case "bearer":
+		token, err := s.fetchToken(ctx, params, scope)
+		if err != nil {
+			return nil, fmt.Errorf("error getting registry token: %w", err)
+		}
+		s.mu.Lock()
+		s.tokens[scope] = token
+		s.mu.Unlock()
+		retry.Header.Set("Authorization", "Bearer "+token)
+	case "basic":
+		creds, err := s.credentials(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if creds.Username == "" {
+			return nil, fmt.Errorf("registry requires credentials")
+		}
+		retry.SetBasicAuth(creds.Username, creds.Password)
+	default:
+		return nil, fmt.Errorf("unsupported auth challenge %q", challenge)
+	}
+	return s.client.Do(retry)
+}
+
+func (s *ociSource) fetchToken(ctx context.Context, params map[string]string, scope string) (string, error) {
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", fmt.Errorf("invalid token realm %q", params["realm"])
+	}
+	q := realm.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	if scope != "" {
+		q.Set("scope", scope)
+	} else if params["scope"] != "" {
+		q.Set("scope", params["scope"])
+	}
+	realm.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", realm.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	creds, err := s.credentials(ctx)
+	if err != nil {
+		return "", err
+	}
+	if creds.Username != "" {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", unexpectedStatus(resp.StatusCode)
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+func parseChallenge(h string) (string, map[string]string) {
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(h), " ")
+	params := make(map[string]string)
+	for rest != "" {
+		var kv string
+		rest = strings.TrimLeft(rest, ", ")
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		if strings.HasPrefix(value, `"`) {
+			end := strings.Index(value[1:], `"`)
+			if end < 0 {
+				break
+			}
+			kv, rest = value[1:end+1], value[end+2:]
+		} else {
+			kv, rest, _ = strings.Cut(value, ",")
+		}
+		params[strings.ToLower(strings.TrimSpace(key))] = kv
+	}
+	return strings.ToLower(scheme), params
+}
+
+func (s *ociSource) get(ctx context.Context, path, accept, scope string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", s.scheme+"://"+s.host+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	resp, err := s.do(req, scope)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %w", path, unexpectedStatus(resp.StatusCode))
+	}
+	return resp, nil
+}
+
+// getPaged follows the Link headers of paginated catalog and tag lists.
+func (s *ociSource) getPaged(ctx context.Context, path, scope string, decode func(io.Reader) error) error {
+	for path != "" {
+		resp, err := s.get(ctx, path, "", scope)
+		if err != nil {
+			return err
+		}
+		err = decode(resp.Body)
+		link := resp.Header.Get("Link")
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		path = ""
+		if start, end := strings.Index(link, "<"), strings.Index(link, ">"); start >= 0 && end > start {
+			next, err := url.Parse(link[start+1 : end])
+			if err != nil {
+				return err
+			}
+			path = next.RequestURI()
+		}
+	}
+	return nil
+}
+
+func (s *ociSource) ping(ctx context.Context) error {
+	resp, err := s.get(ctx, "/v2/", "", "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *ociSource) catalog(ctx context.Context) ([]string, error) {
+	var charts []string
+	prefix := s.namespace + "/"
+	err := s.getPaged(ctx, "/v2/_catalog?n=1000", "registry:catalog:*", func(r io.Reader) error {
+		var page struct {
+			Repositories []string `json:"repositories"`
+		}
+		if err := json.NewDecoder(r).Decode(&page); err != nil {
+			return err
+		}
+		for _, name := range page.Repositories {
+			if s.namespace != "" {
+				if !strings.HasPrefix(name, prefix) {
+					continue
+				}
+				name = strings.TrimPrefix(name, prefix)
+			}
+			if !strings.Contains(name, "/") {
+				charts = append(charts, name)
+			}
+		}
+		return nil
+	})
+	return charts, err
+}
+
+func (s *ociSource) listCharts(ctx context.Context) (ChartData, error) {
+	charts := s.charts
+	if len(charts) == 0 {
+		var err error
+		if charts, err = s.catalog(ctx); err != nil {
+			return nil, fmt.Errorf("listing %s (use -include with exact chart names if the registry has no catalog): %w", s, err)
+		}
+	}
+
+	data := make(ChartData)
+	for _, chart := range charts {
+		name := s.repository(chart)
+		err := s.getPaged(ctx, "/v2/"+name+"/tags/list", "repository:"+name+":pull", func(r io.Reader) error {
+			var page struct {
+				Tags []string `json:"tags"`
+			}
+			if err := json.NewDecoder(r).Decode(&page); err != nil {
+				return err
+			}
+			for _, tag := range page.Tags {
+				// helm stores the + of semver build metadata as _ in tags
+				version := strings.ReplaceAll(tag, "_", "+")
+				if _, err := semver.StrictNewVersion(version); err != nil {
+					continue
+				}
+				data[chart] = append(data[chart], ChartVersion{Name: chart, Version: version})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+func (s *ociSource) fetchChart(ctx context.Context, chart, version string) ([]byte, error) {
+	body, _, err := s.openChart(ctx, chart, version)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// openChart streams the chart layer, the digest is checked once the body
+// was read to the end.
+func (s *ociSource) openChart(ctx context.Context, chart, version string) (io.ReadCloser, int64, error) {
+	name := s.repository(chart)
+	scope := "repository:" + name + ":pull"
+	tag := strings.ReplaceAll(version, "+", "_")
+	digest, err := s.chartLayer(ctx, name, tag, scope)
+	if err != nil {
+		return nil, 0, err
+	}
+	resp, err := s.get(ctx, "/v2/"+name+"/blobs/"+digest, "", scope)
+	if err != nil {
+		return nil, 0, err
+	}
+	return &digestReader{ReadCloser: resp.Body, h: sha256.New(), digest: digest, name: name + ":" + tag}, resp.ContentLength, nil
+}
+
+// chartDigest is the sha256 of a chart from the digest of its layer in the
+// manifest, the listing of a registry has none for -chart-cache.
+func (s *ociSource) chartDigest(ctx context.Context, chart, version string) (string, error) {
+	name := s.repository(chart)
+	digest, err := s.chartLayer(ctx, name, strings.ReplaceAll(version, "+", "_"), "repository:"+name+":pull")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(digest, "sha256:"), nil
+}
+
+// chartLayer reads the manifest of name:tag for the digest of its chart
+// layer.
+func (s *ociSource) chartLayer(ctx context.Context, name, tag, scope string) (string, error) {
+	resp, err := s.get(ctx, "/v2/"+name+"/manifests/"+tag, ociManifestMediaType, scope)
+	if err != nil {
+		return "", err
+	}
+	var manifest struct {
+		Layers []struct {
+			MediaType string `json:"mediaType"`
+			Digest    string `json:"digest"`
+		} `json:"layers"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&manifest)
+	resp.Body.Close()
+	if err != nil {
+		return "", fmt.Errorf("error decoding manifest: %w", err)
+	}
+	for _, l := range manifest.Layers {
+		if l.MediaType == helmChartLayerMediaType {
+			return l.Digest, nil
+		}
+	}
+	return "", fmt.Errorf("%s:%s is not a helm chart", name, tag)
+}
+
+// digestReader fails the final read when the content doesn't match digest.
+type digestReader struct {
+	io.ReadCloser
+	h      hash.Hash
+	digest string
+	name   string
+}
+
+func (d *digestReader) Read(p []byte) (int, error) {
+	n, err := d.ReadCloser.Read(p)
+	d.h.Write(p[:n])
+	if err == io.EOF && "sha256:"+hex.EncodeToString(d.h.Sum(nil)) != d.digest {
+		return n, corruptf("digest mismatch for %s", d.name)
+	}
+	return n, err
+}
@@ -0,0 +1,52 @@
+package chartsync
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+)
+
+// oidcSources are the token sources of the OIDC clients in use, shared by
+// the requests of every repo with the same client and http client.
+var (
+	oidcMu      sync.Mutex
+	oidcSources = map[oidcKey]oauth2.TokenSource{}
+)
+
+type oidcKey struct {
+	client string
+	http   *http.Client
+}
+
+// oidcToken returns an access token of the OIDC client of auth, requested
+// through client. It is requested from the token endpoint on first use
+// and again shortly before it expires, so long syncs don't need a
+// long-lived token.
+func oidcToken(auth credentials, client *http.Client) (string, error) {
+	client = clientOr(client)
+	key := oidcKey{strings.Join(append([]string{auth.OIDCTokenURL, auth.OIDCClientID, auth.OIDCClientSecret}, auth.OIDCScopes...), "\x00"), client}
+	oidcMu.Lock()
+	src := oidcSources[key]
+	if src == nil {
+		cfg := &clientcredentials.Config{
+			ClientID:     auth.OIDCClientID,
+			ClientSecret: auth.OIDCClientSecret,
+			TokenURL:     auth.OIDCTokenURL,
+			Scopes:       auth.OIDCScopes,
+		}
+		src = cfg.TokenSource(context.WithValue(context.Background(), oauth2.HTTPClient, client))
+		oidcSources[key] = src
+	}
+	oidcMu.Unlock()
+	t, err := src.Token()
+	if err != nil {
+		return "", fmt.Errorf("error getting oidc token from %s: %w", auth.OIDCTokenURL, err)
+	}
+	addSecret(t.AccessToken)
+	return t.AccessToken, nil
+}
@@ -0,0 +1,147 @@
+package chartsync
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/open-policy-agent/opa/v1/loader"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/open-policy-agent/opa/v1/storage/inmem"
+)
+
+// policyPackage is the rego package a policy decides in, with the rules
+// allow (a bool) and deny (a set of reasons).
+const policyPackage = "cm_sync"
+
+// chartPolicy is a rego policy that allows or denies each version before
+// it is uploaded. A version is denied when deny has a reason in it or
+// allow is defined and false. A nil chartPolicy allows everything.
+type chartPolicy struct {
+	query rego.PreparedEvalQuery
+	// contents passes the files of the packaged chart to the policy too,
+	// the chart is downloaded before it is decided on.
+	contents bool
+}
+
+// policyInput is the input document of a policy.
+type policyInput struct {
+	// Chart is the index entry of the version.
+	Chart       ChartVersion `json:"chart"`
+	Source      string       `json:"source"`
+	Destination string       `json:"destination"`
+	// Files are the files of the packaged chart by their path below the
+	// chart directory, with -policy-contents.
+	Files map[string]string `json:"files,omitempty"`
+}
+
+// newPolicyInput is the input for the transfer of item from source, whose
+// charts are data, to destination.
+func newPolicyInput(source, destination fmt.Stringer, data ChartData, item syncItem) policyInput {
+	input := policyInput{Chart: ChartVersion{Name: item.Chart, Version: item.Version}, Source: source.String(), Destination: destination.String()}
+	for _, cv := range data[item.Chart] {
+		if cv.Version == item.Version {
+			input.Chart = cv
+		}
+	}
+	return input
+}
+
+// loadPolicy compiles the rego files and json or yaml data documents at
+// paths, files or directories.
+func loadPolicy(ctx context.Context, paths []string, contents bool) (*chartPolicy, error) {
+	res, err := loader.NewFileLoader().All(paths)
+	if err != nil {
+		return nil, err
+	}
+	opts := []func(*rego.Rego){
+		rego.Query("data." + policyPackage),
+		rego.Store(inmem.NewFromObject(res.Documents)),
+	}
+	found := false
+	for _, m := range res.ParsedModules() {
+		found = found || m.Package.Path.String() == "data."+policyPackage
+		opts = append(opts, rego.ParsedModule(m))
+	}
+	if !found {
+		return nil, fmt.Errorf("no rego file in %s has package %s", strings.Join(paths, ", "), policyPackage)
+	}
+	query, err := rego.New(opts...).PrepareForEval(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &chartPolicy{query: query, contents: contents}, nil
+}
+
+// decide evaluates the policy for input, returning why it denies the
+// version or "" when it allows it.
+func (p *chartPolicy) decide(ctx context.Context, input policyInput) (string, error) {
+	if p == nil {
+		return "", nil
+	}
+	// The input goes through json so it has the field names of the tags.
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "", err
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return "", err
+	}
+	rs, err := p.query.Eval(ctx, rego.EvalInput(doc))
+	if err != nil {
+		return "", err
+	}
+	if len(rs) == 0 || len(rs[0].Expressions) == 0 {
+		return "", nil
+	}
+	result, _ := rs[0].Expressions[0].Value.(map[string]any)
+	var reasons []string
+	if deny, ok := result["deny"].([]any); ok {
+		for _, r := range deny {
+			reasons = append(reasons, fmt.Sprint(r))
+		}
+	}
+	if len(reasons) > 0 {
+		return strings.Join(reasons, "; "), nil
+	}
+	if allow, ok := result["allow"]; ok && allow != true {
+		return "not allowed by the policy", nil
+	}
+	return "", nil
+}
+
+// chartContents returns the regular files of a packaged chart by their
+// path below the chart directory.
+func chartContents(r io.Reader) (map[string]string, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		_, name, ok := strings.Cut(hdr.Name, "/")
+		if !ok || name == "" || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[name] = string(data)
+	}
+}
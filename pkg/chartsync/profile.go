@@ -0,0 +1,62 @@
+package chartsync
+
+import (
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	runtimepprof "runtime/pprof"
+)
+
+// startProfiling serves net/http/pprof on addr and writes a CPU profile
+// to cpuFile, as set. The returned stop ends the CPU profile and writes
+// the heap profile to heapFile, it is called before cm_sync exits.
+func startProfiling(addr, cpuFile, heapFile string) (stop func(), err error) {
+	if addr != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/debug/pprof/", pprof.Index)
+		mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		go func() {
+			if err := http.ListenAndServe(addr, mux); err != nil {
+				logln("Failed to serve pprof on", addr, err)
+			}
+		}()
+	}
+	var cpu *os.File
+	if cpuFile != "" {
+		if cpu, err = os.Create(cpuFile); err != nil {
+			return nil, err
+		}
+		if err := runtimepprof.StartCPUProfile(cpu); err != nil {
+			cpu.Close()
+			return nil, err
+		}
+	}
+	return func() {
+		if cpu != nil {
+			runtimepprof.StopCPUProfile()
+			cpu.Close()
+		}
+		if heapFile != "" {
+			if err := writeHeapProfile(heapFile); err != nil {
+				logln("Failed to write heap profile", heapFile, err)
+			}
+		}
+	}, nil
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	runtime.GC()
+	if err := runtimepprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
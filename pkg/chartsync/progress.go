@@ -0,0 +1,141 @@
+package chartsync
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+// syncProgress is the progress bar of a sync. When the size of every
+// queued chart is known it counts bytes, which gives a rolling throughput
+// and an ETA from the bytes left, otherwise it counts charts.
+type syncProgress struct {
+	bar   *progressbar.ProgressBar
+	bytes bool
+	hooks Progress
+
+	mu    sync.Mutex
+	done  int
+	total int
+}
+
+// newSyncProgress draws the bar on stderr, unless hooks are given that
+// report the progress instead.
+func newSyncProgress(description string, sizes []int64, hooks Progress) *syncProgress {
+	p := &syncProgress{total: len(sizes), bytes: len(sizes) > 0, hooks: hooks}
+	var total int64
+	for _, size := range sizes {
+		if size < 0 {
+			p.bytes = false
+		}
+		total += size
+	}
+	switch {
+	case hooks != nil && p.bytes:
+		p.bar = progressbar.DefaultBytesSilent(total, description)
+	case hooks != nil:
+		p.bar = progressbar.DefaultSilent(int64(len(sizes)), description)
+	case p.bytes:
+		p.bar = progressbar.DefaultBytes(total, description)
+	default:
+		p.bar = progressbar.Default(int64(len(sizes)), description)
+	}
+	return p
+}
+
+// chart starts the progress of one chart, size is -1 when unknown. Charts
+// that weren't queued to begin with, dependencies, are added to the total.
+func (p *syncProgress) chart(item syncItem, size int64, queued bool) *chartProgress {
+	if !queued {
+		p.mu.Lock()
+		p.total++
+		if !p.bytes {
+			p.bar.ChangeMax(p.total)
+		}
+		p.mu.Unlock()
+	}
+	if p.hooks != nil {
+		p.hooks.Start(Version{item.Chart, item.Version}, size)
+	}
+	if !p.bytes || !queued {
+		size = 0
+	}
+	return &chartProgress{p: p, item: item, size: size}
+}
+
+// chartProgress counts the bytes of one chart towards the bar.
+type chartProgress struct {
+	p    *syncProgress
+	item syncItem
+	size int64
+
+	mu       sync.Mutex
+	counted  int64
+	finished bool
+}
+
+// count adds n transferred bytes, beyond the announced size they grow the
+// bar's total.
+func (c *chartProgress) count(n int64) {
+	if !c.p.bytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.finished {
+		return
+	}
+	if over := c.counted + n - max(c.size, c.counted); over > 0 {
+		c.p.bar.AddMax64(over)
+	}
+	c.counted += n
+	c.p.bar.Add64(n)
+}
+
+// reader counts what is read from r.
+func (c *chartProgress) reader(r io.Reader) io.Reader {
+	if !c.p.bytes {
+		return r
+	}
+	return progressReader{r, c}
+}
+
+// finish marks the chart done whether it was transferred or not, bytes
+// that weren't counted are added so the ETA stays right.
+func (c *chartProgress) finish() {
+	c.mu.Lock()
+	if c.finished {
+		c.mu.Unlock()
+		return
+	}
+	c.finished = true
+	rest := c.size - c.counted
+	c.mu.Unlock()
+
+	p := c.p
+	if p.hooks != nil {
+		p.hooks.Finish(Version{c.item.Chart, c.item.Version})
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	p.bar.Describe(fmt.Sprintf("%s-%s (%d/%d)", c.item.Chart, c.item.Version, p.done, p.total))
+	if !p.bytes {
+		p.bar.Add(1)
+	} else if rest > 0 {
+		p.bar.Add64(rest)
+	}
+}
+
+type progressReader struct {
+	r io.Reader
+	c *chartProgress
+}
+
+func (r progressReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.c.count(int64(n))
+	return n, err
+}
@@ -0,0 +1,317 @@
+package chartsync
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// promotionRecord is a promotion in the audit log, approved or not.
+type promotionRecord struct {
+	ID          string    `json:"id"`
+	Time        time.Time `json:"time"`
+	Chart       string    `json:"chart"`
+	Version     string    `json:"version"`
+	Digest      string    `json:"digest,omitempty"`
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	RequestedBy string    `json:"requested_by"`
+	Approval    string    `json:"approval,omitempty"`
+	Approver    string    `json:"approver,omitempty"`
+	// Result is promoted, denied, failed or unchanged when the stage had
+	// the version already.
+	Result string `json:"result"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// approval is the decision of an approval gate.
+type approval struct {
+	Approved bool   `json:"approved"`
+	Approver string `json:"approver"`
+	Reason   string `json:"reason"`
+}
+
+// promotionRequest is what an approval webhook is sent.
+type promotionRequest struct {
+	ID          string `json:"id"`
+	Chart       string `json:"chart"`
+	Version     string `json:"version"`
+	Digest      string `json:"digest,omitempty"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	RequestedBy string `json:"requested_by"`
+	// CallbackURL is where a webhook answering 202 posts its decision.
+	CallbackURL string `json:"callback_url,omitempty"`
+}
+
+// runPromote moves a chart version to the next stage of the pipeline in
+// the config, after the approval gate of that stage, and records it in
+// the audit log.
+func runPromote(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("promote", flag.ExitOnError)
+	cf := newCommandFlags(fs, false, false)
+	to := fs.String("to", "", "stage to promote to, the one after the last stage having the version if empty")
+	from := fs.String("from", "", "stage to promote from, the one before -to if empty")
+	force := fs.Bool("force", false, "promote again when the stage already has the version")
+	yes := fs.Bool("yes", false, "don't ask for confirmation before -force overwrites the version in the stage")
+	audit := fs.String("audit", "", "jsonl file the promotion is recorded in, promotion_audit or promotions.jsonl next to the config if empty")
+	callbackAddr := fs.String("callback-addr", ":8095", "address approval webhooks answering 202 post their decision to")
+	callbackURL := fs.String("callback-url", "", "url of -callback-addr the webhook can reach, http://HOSTNAME:PORT if empty")
+	timeout := fs.Duration("approval-timeout", time.Hour, "time to wait for the decision of an approval webhook")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cm_sync promote -config FILE [flags] CHART VERSION")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 || *cf.configFile == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	chart, version := fs.Arg(0), fs.Arg(1)
+	cfg := cf.config(fs)
+	if len(cfg.Stages) < 2 {
+		logln("Error: the config needs at least two stages to promote between")
+		os.Exit(1)
+	}
+	auditPath := *audit
+	if auditPath == "" {
+		auditPath = cfg.PromotionAudit
+	}
+	if auditPath == "" {
+		auditPath = filepath.Join(filepath.Dir(*cf.configFile), "promotions.jsonl")
+	}
+	// The log is opened first, no promotion goes unrecorded.
+	auditLog, err := os.OpenFile(auditPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		logln("Error opening audit log:", err)
+		os.Exit(1)
+	}
+	defer auditLog.Close()
+
+	fromIdx, toIdx := stageIndex(cfg, *from), stageIndex(cfg, *to)
+	for _, name := range []string{*from, *to} {
+		if stageIndex(cfg, name) == -2 {
+			logf("Error: the config has no stage %q\n", name)
+			os.Exit(1)
+		}
+	}
+	switch {
+	case toIdx == -1 && fromIdx == -1:
+		for i := range cfg.Stages {
+			if _, data := openSource(ctx, cfg.stage(i), normalizeTenant(cfg.Stages[i].Tenant)); hasVersion(data, chart, version) {
+				fromIdx = i
+			}
+		}
+		if fromIdx == -1 {
+			logf("Error: no stage has %s-%s\n", chart, version)
+			os.Exit(1)
+		}
+		toIdx = fromIdx + 1
+	case toIdx == -1:
+		toIdx = fromIdx + 1
+	case fromIdx == -1:
+		fromIdx = toIdx - 1
+	}
+	if toIdx >= len(cfg.Stages) {
+		logf("%s-%s is in %s, the last stage\n", chart, version, cfg.Stages[fromIdx].Name)
+		return
+	}
+	if fromIdx < 0 {
+		logf("Error: %s is the first stage, there is nothing to promote from\n", cfg.Stages[toIdx].Name)
+		os.Exit(1)
+	}
+	if fromIdx >= toIdx {
+		logf("Error: %s doesn't come after %s\n", cfg.Stages[toIdx].Name, cfg.Stages[fromIdx].Name)
+		os.Exit(1)
+	}
+	stage := cfg.Stages[toIdx]
+
+	rec := promotionRecord{
+		ID:          uuid.NewString(),
+		Chart:       chart,
+		Version:     version,
+		From:        cfg.Stages[fromIdx].Name,
+		To:          stage.Name,
+		RequestedBy: currentUser(),
+		Approval:    stage.Approval,
+	}
+	record := func(result, reason string) {
+		rec.Time = time.Now().UTC()
+		rec.Result, rec.Reason = result, redact(reason)
+		data, _ := json.Marshal(rec)
+		if _, err := auditLog.Write(append(data, '\n')); err != nil {
+			logln("Error writing audit log:", err)
+			os.Exit(1)
+		}
+	}
+
+	src, sourceData := openSource(ctx, cfg.stage(fromIdx), normalizeTenant(cfg.Stages[fromIdx].Tenant))
+	if !hasVersion(sourceData, chart, version) {
+		logln("Error finding chart:", chart+"-"+version, "not found in", rec.From)
+		os.Exit(1)
+	}
+	rec.Digest = indexDigest(sourceData, chart, version)
+	if !hasVersion(annotated(sourceData, cfg.RequireAnnotations), chart, version) {
+		reason := "missing the annotations " + formatAnnotations(cfg.RequireAnnotations)
+		record("denied", reason)
+		logf("Promotion of %s-%s to %s was denied: %s\n", chart, version, rec.To, reason)
+		os.Exit(1)
+	}
+	dst, destData := openDestination(ctx, cfg.stage(toIdx), normalizeTenant(stage.Tenant))
+	if hasVersion(destData, chart, version) && !*force {
+		record("unchanged", "the stage has the version already")
+		logf("%s already has %s-%s, pass -force to promote it again\n", rec.To, chart, version)
+		return
+	}
+	if hasVersion(destData, chart, version) {
+		change := overwriteChange(chart, version, indexDigest(destData, chart, version), rec.Digest)
+		if !confirmChanges(*yes, fmt.Sprintf("Overwrite %s-%s in %s?", chart, version, rec.To), []string{change}) {
+			record("unchanged", "the overwrite was not confirmed")
+			logln("Nothing promoted")
+			os.Exit(1)
+		}
+	}
+
+	gate := approvalGate{stage: stage, callbackAddr: *callbackAddr, callbackURL: *callbackURL, timeout: *timeout}
+	decision, err := gate.approve(ctx, promotionRequest{
+		ID:          rec.ID,
+		Chart:       chart,
+		Version:     version,
+		Digest:      rec.Digest,
+		From:        rec.From,
+		To:          rec.To,
+		RequestedBy: rec.RequestedBy,
+	})
+	if err != nil {
+		record("failed", err.Error())
+		logln("Error getting approval:", err)
+		os.Exit(1)
+	}
+	rec.Approver = decision.Approver
+	if !decision.Approved {
+		record("denied", decision.Reason)
+		logf("Promotion of %s-%s to %s was denied", chart, version, rec.To)
+		if decision.Approver != "" {
+			logf(" by %s", decision.Approver)
+		}
+		if decision.Reason != "" {
+			logf(": %s", decision.Reason)
+		}
+		logln()
+		os.Exit(1)
+	}
+
+	if err := copyVersion(ctx, src, dst, sourceData, chart, version); err != nil {
+		record("failed", err.Error())
+		logln("Error promoting", chart+"-"+version, "\n", err)
+		os.Exit(1)
+	}
+	record("promoted", decision.Reason)
+	logf("Promoted %s-%s from %s to %s\n", chart, version, rec.From, rec.To)
+}
+
+// stageIndex is the index of the stage named name, -1 for an empty name
+// and -2 for an unknown one.
+func stageIndex(cfg *config, name string) int {
+	if name == "" {
+		return -1
+	}
+	for i, s := range cfg.Stages {
+		if s.Name == name {
+			return i
+		}
+	}
+	return -2
+}
+
+// stage is the config of stage i as the source and the destination.
+func (c *config) stage(i int) *config {
+	s := c.Stages[i]
+	st := *c
+	st.Source, st.SourceType, st.SourceIndex, st.SourceAuth = s.URL, s.Type, "", s.Auth
+	st.Destination, st.DestinationType, st.DestinationIndex, st.DestinationAuth = s.URL, s.Type, "", s.Auth
+	// Promotions name the version, the filters of syncs don't apply and
+	// required annotations are checked by runPromote.
+	st.Include, st.Exclude, st.Retention, st.Shard, st.RequireAnnotations = nil, nil, 0, "", nil
+	return &st
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// approvalGate decides promotions into stage.
+type approvalGate struct {
+	stage        stageConfig
+	callbackAddr string
+	callbackURL  string
+	timeout      time.Duration
+}
+
+func (g approvalGate) approve(ctx context.Context, req promotionRequest) (approval, error) {
+	switch g.stage.Approval {
+	case "":
+		return approval{Approved: true}, nil
+	case "confirm":
+		return g.confirm(req), nil
+	case "file":
+		return g.file(req)
+	case "webhook":
+		return g.webhook(ctx, req)
+	}
+	return approval{}, fmt.Errorf("unknown approval %q of stage %s, it is confirm, file or webhook", g.stage.Approval, g.stage.Name)
+}
+
+// confirm asks on the terminal, the user answering is the approver.
+func (g approvalGate) confirm(req promotionRequest) approval {
+	answer := prompt(bufio.NewReader(os.Stdin), fmt.Sprintf("Promote %s-%s (%s) from %s to %s? [y/N]: ", req.Chart, req.Version, shortDigest(req.Digest), req.From, req.To), false)
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return approval{Approved: true, Approver: req.RequestedBy, Reason: "confirmed"}
+	}
+	return approval{Approver: req.RequestedBy, Reason: "not confirmed"}
+}
+
+// file approves the versions listed in the approval file, a line like
+// "CHART VERSION APPROVER" each.
+func (g approvalGate) file(req promotionRequest) (approval, error) {
+	if g.stage.ApprovalFile == "" {
+		return approval{}, fmt.Errorf("stage %s has no approval_file", g.stage.Name)
+	}
+	data, err := os.ReadFile(g.stage.ApprovalFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return approval{}, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		f := strings.Fields(line)
+		if len(f) >= 2 && !strings.HasPrefix(f[0], "#") && f[0] == req.Chart && f[1] == req.Version {
+			return approval{Approved: true, Approver: strings.Join(f[2:], " "), Reason: "listed in " + g.stage.ApprovalFile}, nil
+		}
+	}
+	return approval{Reason: "not listed in " + g.stage.ApprovalFile}, nil
+}
+
+// webhook posts the request to the approval webhook of the stage.
+func (g approvalGate) webhook(ctx context.Context, req promotionRequest) (approval, error) {
+	if g.stage.ApprovalWebhook == "" {
+		return approval{}, fmt.Errorf("stage %s has no approval_webhook", g.stage.Name)
+	}
+	w := approvalWebhook{url: g.stage.ApprovalWebhook, callbackAddr: g.callbackAddr, callbackURL: g.callbackURL, timeout: g.timeout}
+	return w.ask(ctx, req.ID, req.Chart+"-"+req.Version, func(callbackURL string) any {
+		req.CallbackURL = callbackURL
+		return req
+	})
+}
@@ -0,0 +1,244 @@
+package chartsync
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// chartProxy serves the source as a helm repository, charts are fetched
+// from it on first request and kept in a cache directory. Charts fetched
+// are also pushed to the destination when there is one.
+type chartProxy struct {
+	src      chartSource
+	dst      chartDestination
+	opts     syncOptions
+	cacheDir string
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	index   []byte
+	files   map[string]ChartVersion
+	fetched time.Time
+	stored  map[string]bool
+	pulls   map[string]*sync.Mutex
+
+	// refreshing is set while the source is listed, requests are served
+	// the index they have until then.
+	refreshing bool
+}
+
+// refreshIndex lists the source again once the index is older than ttl.
+// The source is listed without holding mu, a slow source doesn't hold up
+// the requests that have an index.
+func (p *chartProxy) refreshIndex(ctx context.Context) error {
+	p.mu.Lock()
+	if p.index != nil && (time.Since(p.fetched) < p.ttl || p.refreshing) {
+		p.mu.Unlock()
+		return nil
+	}
+	p.refreshing = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		p.refreshing = false
+		p.mu.Unlock()
+	}()
+
+	data, err := p.src.listCharts(ctx)
+	if err != nil {
+		return err
+	}
+	data = applyPolicy(data, p.opts)
+	entries := make(ChartData)
+	files := make(map[string]ChartVersion)
+	for chart, versions := range data {
+		for _, cv := range versions {
+			name := cv.Name + "-" + cv.Version + ".tgz"
+			files[name] = cv
+			cv.URLs = []string{"charts/" + escapePath(name)}
+			entries[chart] = append(entries[chart], cv)
+		}
+		sortVersions(entries[chart])
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(indexFile{APIVersion: "v1", Entries: entries, Generated: time.Now().UTC()}); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.index, p.files, p.fetched = buf.Bytes(), files, time.Now()
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *chartProxy) lookup(name string) (ChartVersion, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	cv, ok := p.files[name]
+	return cv, ok
+}
+
+// pull makes sure name is in the cache, concurrent requests for the same
+// chart wait for one download.
+func (p *chartProxy) pull(ctx context.Context, name string, cv ChartVersion) (string, error) {
+	p.mu.Lock()
+	m, ok := p.pulls[name]
+	if !ok {
+		m = &sync.Mutex{}
+		p.pulls[name] = m
+	}
+	p.mu.Unlock()
+	m.Lock()
+	defer m.Unlock()
+
+	file := filepath.Join(p.cacheDir, name)
+	if _, err := os.Stat(file); err == nil {
+		return file, nil
+	}
+	if _, err := saveChart(ctx, p.src, cv.Name, cv.Version, cv.Digest, p.cacheDir); err != nil {
+		return "", err
+	}
+	if pv, ok := p.src.(interface {
+		fetchProvenance(ctx context.Context, chart, version string) ([]byte, error)
+	}); ok {
+		if prov, err := pv.fetchProvenance(ctx, cv.Name, cv.Version); err == nil && prov != nil {
+			os.WriteFile(file+".prov", prov, 0644)
+		}
+	}
+	if p.dst != nil {
+		go p.store(context.WithoutCancel(ctx), file, cv)
+	}
+	return file, nil
+}
+
+// store pushes a freshly cached chart to the destination.
+func (p *chartProxy) store(ctx context.Context, file string, cv ChartVersion) {
+	p.mu.Lock()
+	done := p.stored[file]
+	p.stored[file] = true
+	p.mu.Unlock()
+	if done {
+		return
+	}
+	data, err := p.dst.listCharts(ctx)
+	if err == nil && hasVersion(data, cv.Name, cv.Version) {
+		return
+	}
+	if err == nil {
+		err = uploadFile(ctx, p.dst, data, file, false, false)
+	}
+	if f, ok := p.dst.(interface{ flush(context.Context) error }); ok && err == nil {
+		err = f.flush(ctx)
+	}
+	if err != nil {
+		logf("Failed to store %s-%s in %s %v\n", cv.Name, cv.Version, p.dst, err)
+	}
+}
+
+func (p *chartProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	switch {
+	case r.URL.Path == "/health":
+		fmt.Fprintln(w, "ok")
+	case r.URL.Path == "/index.yaml" || r.URL.Path == "/":
+		if err := p.refreshIndex(ctx); err != nil {
+			logf("Failed to fetch charts of %s %v\n", p.src, err)
+			http.Error(w, redact(err.Error()), http.StatusBadGateway)
+			return
+		}
+		p.mu.Lock()
+		index := p.index
+		p.mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-yaml")
+		w.Write(index)
+	case strings.HasPrefix(r.URL.Path, "/charts/"):
+		name := path.Base(r.URL.Path)
+		prov := strings.HasSuffix(name, ".prov")
+		name = strings.TrimSuffix(name, ".prov")
+		if err := p.refreshIndex(ctx); err != nil {
+			http.Error(w, redact(err.Error()), http.StatusBadGateway)
+			return
+		}
+		cv, ok := p.lookup(name)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		file, err := p.pull(ctx, name, cv)
+		if err != nil {
+			logf("Failed to fetch %s-%s from %s %v\n", cv.Name, cv.Version, p.src, err)
+			http.Error(w, redact(err.Error()), http.StatusBadGateway)
+			return
+		}
+		if prov {
+			file += ".prov"
+		} else {
+			w.Header().Set("Content-Type", "application/gzip")
+		}
+		http.ServeFile(w, r, file)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// runProxy serves a read-through caching helm repository of the source,
+// e.g. close to build farms far from the primary chartmuseum.
+func runProxy(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("proxy", flag.ExitOnError)
+	cf := addSourceFlags(fs)
+	listen := fs.String("listen", ":8080", "address to serve the helm repository on")
+	cacheDir := fs.String("cache-dir", "cm_sync-cache", "directory charts are cached in")
+	ttl := fs.Duration("index-ttl", time.Minute, "how long the source's index is served before it is fetched again")
+	destination := fs.String("d", "", "destination the fetched charts are also pushed to, none if empty")
+	destType := fs.String("dest-type", "", "destination server type, detected if empty")
+	fs.Parse(args)
+	cfg := cf.config(fs)
+	if *destType != "" {
+		cfg.DestinationType = *destType
+	}
+	cfg.Destination = *destination
+
+	if err := os.MkdirAll(*cacheDir, 0755); err != nil {
+		logln("Error creating -cache-dir:", err)
+		os.Exit(1)
+	}
+	src, _ := openSource(ctx, cfg, "")
+	p := &chartProxy{
+		src:      src,
+		opts:     cfg.syncOptions,
+		cacheDir: *cacheDir,
+		ttl:      *ttl,
+		stored:   make(map[string]bool),
+		pulls:    make(map[string]*sync.Mutex),
+	}
+	if cfg.Destination != "" {
+		p.dst, _ = openDestination(ctx, cfg, "")
+	}
+
+	logln("Serving", src, "on", *listen)
+	srv := &http.Server{Addr: *listen, Handler: p}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logln("Error serving", *listen, "\n", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,118 @@
+package chartsync
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// pruneItems lists the destination versions a sync with opts wouldn't
+// transfer: versions the source doesn't have and those beyond -retention.
+// Charts left out by -include and -exclude aren't touched.
+func pruneItems(sourceData, destData ChartData, opts syncOptions) []syncItem {
+	charts := opts
+	charts.Retention = 0
+	return sortedItems(compareCharts(applyPolicy(destData, charts), applyPolicy(sourceData, opts)))
+}
+
+type pruneResult struct {
+	Destination string `json:"destination"`
+	Chart       string `json:"chart"`
+	Version     string `json:"version"`
+	Deleted     bool   `json:"deleted"`
+	Error       string `json:"error,omitempty"`
+}
+
+// runPrune deletes what the destination has beyond the source, on its own
+// rather than as part of a sync.
+func runPrune(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("prune", flag.ExitOnError)
+	cf := newCommandFlags(fs, true, true)
+	tenants := fs.String("tenants", "", "comma separated org/repo paths to prune on a multitenant chartmuseum")
+	tenantMapping := fs.String("tenant-map", "", "comma separated source=destination tenant paths, e.g. team-a/stable=platform/charts (/ is the root)")
+	dryRun := fs.Bool("dry-run", false, "only list the versions that would be deleted")
+	yes := fs.Bool("yes", false, "don't ask for confirmation")
+	report := fs.String("report", "", "file to write a json report of the pruned versions to")
+	fs.Parse(args)
+	cfg := cf.config(fs)
+
+	cfg.detectServerTypes(ctx)
+	tenantMap, err := parseTenantMap(*tenantMapping)
+	if err != nil {
+		logln("Error parsing -tenant-map:", err)
+		os.Exit(1)
+	}
+	jobs, err := cfg.jobs(tenantList(*tenants, tenantMap), tenantMap)
+	if err != nil {
+		logln("Error setting up prune:", err)
+		os.Exit(1)
+	}
+
+	var results []pruneResult
+	var targets []chartDestination
+	for _, job := range jobs {
+		if _, _, err := deleteURL(job.destination, "", ""); err != nil {
+			logln("Error deleting charts:", err)
+			os.Exit(1)
+		}
+		sourceData, err := job.source.listCharts(ctx)
+		if err == nil && len(sourceData) == 0 {
+			err = fmt.Errorf("the source has no charts, refusing to prune everything")
+		}
+		var destData ChartData
+		if err == nil {
+			destData, err = job.destination.listCharts(ctx)
+		}
+		if err != nil {
+			logln("Error fetching charts of", job.source, "or", job.destination, "\n", withCode(err))
+			continue
+		}
+		for _, item := range pruneItems(sourceData, destData, job.options) {
+			outf("Would delete %s-%s from %s\n", item.Chart, item.Version, job.destination)
+			results = append(results, pruneResult{Destination: fmt.Sprint(job.destination), Chart: item.Chart, Version: item.Version})
+			targets = append(targets, job.destination)
+		}
+	}
+
+	deleted, failed := 0, 0
+	switch {
+	case len(results) == 0:
+		logln("Nothing to prune")
+	case *dryRun:
+	// The versions are listed above already.
+	case !confirmChanges(*yes, fmt.Sprintf("Delete %d versions?", len(results)), nil):
+		logln("Nothing deleted")
+		os.Exit(1)
+	default:
+		for i, r := range results {
+			if err := deleteChart(ctx, targets[i], r.Chart, r.Version); err != nil {
+				logf("Failed to delete %s-%s from %s %v\n", r.Chart, r.Version, r.Destination, err)
+				results[i].Error = redact(err.Error())
+				failed++
+				continue
+			}
+			results[i].Deleted = true
+			deleted++
+		}
+		logf("Pruned %d versions, %d failed\n", deleted, failed)
+	}
+
+	if *report != "" {
+		if results == nil {
+			results = []pruneResult{}
+		}
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err == nil {
+			err = os.WriteFile(*report, append(data, '\n'), 0644)
+		}
+		if err != nil {
+			logln("Error writing report:", *report, "\n", err)
+			os.Exit(1)
+		}
+	}
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,219 @@
+package chartsync
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// quarantine counts the consecutive runs each chart version failed in,
+// kept in quarantine.json of -index-cache. Versions that failed after runs
+// in a row are skipped until they are cleared or the destination has them.
+type quarantine struct {
+	mu      sync.Mutex
+	path    string
+	after   int
+	entries map[string]*quarantineEntry
+}
+
+type quarantineEntry struct {
+	Destination string    `json:"destination"`
+	Chart       string    `json:"chart"`
+	Version     string    `json:"version"`
+	Failures    int       `json:"failures"`
+	LastError   string    `json:"last_error"`
+	LastFailed  time.Time `json:"last_failed"`
+}
+
+func quarantineKey(destination string, item syncItem) string {
+	return destination + " " + item.Chart + "-" + item.Version
+}
+
+func loadQuarantine(dir string, after int) (*quarantine, error) {
+	q := &quarantine{path: filepath.Join(dir, "quarantine.json"), after: after, entries: make(map[string]*quarantineEntry)}
+	data, err := os.ReadFile(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*quarantineEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", q.path, err)
+	}
+	for _, e := range entries {
+		q.entries[quarantineKey(e.Destination, syncItem{Chart: e.Chart, Version: e.Version})] = e
+	}
+	return q, nil
+}
+
+func (q *quarantine) save() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries := q.sorted()
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(q.path, append(data, '\n'), 0o644)
+}
+
+func (q *quarantine) sorted() []*quarantineEntry {
+	entries := make([]*quarantineEntry, 0, len(q.entries))
+	for _, e := range q.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Destination != b.Destination {
+			return a.Destination < b.Destination
+		}
+		if a.Chart != b.Chart {
+			return a.Chart < b.Chart
+		}
+		return newerVersion(a.Version, b.Version)
+	})
+	return entries
+}
+
+// filter drops the quarantined versions from the queue of a destination,
+// with a warning each. Versions no longer queued are forgotten, the
+// destination got them some other way.
+func (q *quarantine) filter(destination string, w nameWindow, queue []syncItem) ([]syncItem, []syncItem) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	queued := make(map[string]bool, len(queue))
+	var keep, skipped []syncItem
+	for _, item := range queue {
+		key := quarantineKey(destination, item)
+		queued[key] = true
+		if e, ok := q.entries[key]; ok && q.after > 0 && e.Failures >= q.after {
+			logf("Skipping quarantined %s-%s, it failed %d runs in a row: %s\n", item.Chart, item.Version, e.Failures, e.LastError)
+			skipped = append(skipped, item)
+			continue
+		}
+		keep = append(keep, item)
+	}
+	for key, e := range q.entries {
+		if e.Destination == destination && w.contains(e.Chart) && !queued[key] {
+			delete(q.entries, key)
+		}
+	}
+	return keep, skipped
+}
+
+// reporter counts the outcome of the versions synced to destination.
+func (q *quarantine) reporter(destination string) Reporter {
+	return quarantineReporter{q, destination}
+}
+
+type quarantineReporter struct {
+	q           *quarantine
+	destination string
+}
+
+func (r quarantineReporter) Synced(v Version, size int64) {
+	r.q.mu.Lock()
+	defer r.q.mu.Unlock()
+	delete(r.q.entries, quarantineKey(r.destination, syncItem{Chart: v.Chart, Version: v.Version}))
+}
+
+func (r quarantineReporter) Skipped(v Version) {}
+
+func (r quarantineReporter) Failed(v Version, err error) {
+	r.q.mu.Lock()
+	defer r.q.mu.Unlock()
+	key := quarantineKey(r.destination, syncItem{Chart: v.Chart, Version: v.Version})
+	e, ok := r.q.entries[key]
+	if !ok {
+		e = &quarantineEntry{Destination: r.destination, Chart: v.Chart, Version: v.Version}
+		r.q.entries[key] = e
+	}
+	e.Failures++
+	e.LastError = redact(err.Error())
+	e.LastFailed = time.Now().UTC()
+	if e.Failures == r.q.after {
+		logf("Quarantined %s-%s after %d failed runs, it is skipped until `cm_sync quarantine clear`\n", v.Chart, v.Version, e.Failures)
+	}
+}
+
+// runQuarantine lists or clears the quarantined versions.
+func runQuarantine(args []string) {
+	fs := flag.NewFlagSet("quarantine", flag.ExitOnError)
+	cf := newCommandFlags(fs, false, false)
+	dir := fs.String("index-cache", "", "the -index-cache directory of the syncs")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cm_sync quarantine list [flags], or quarantine clear [flags] [CHART[@VERSION]...]")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 || args[0] != "list" && args[0] != "clear" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	action := args[0]
+	fs.Parse(args[1:])
+	cfg := cf.config(fs)
+	if *dir != "" {
+		cfg.IndexCache = *dir
+	}
+	if cfg.IndexCache == "" {
+		logln("Error: the quarantine is kept in -index-cache, pass it or -config")
+		os.Exit(1)
+	}
+	q, err := loadQuarantine(cfg.IndexCache, cfg.QuarantineAfter)
+	if err != nil {
+		logln("Error loading quarantine:", err)
+		os.Exit(1)
+	}
+
+	if action == "list" {
+		if len(q.entries) == 0 {
+			logln("Nothing failed")
+			return
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "DESTINATION\tCHART\tVERSION\tFAILED RUNS\tLAST FAILED\tLAST ERROR")
+		for _, e := range q.sorted() {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n", e.Destination, e.Chart, e.Version, e.Failures, e.LastFailed.Format("2006-01-02 15:04"), e.LastError)
+		}
+		tw.Flush()
+		return
+	}
+
+	cleared := 0
+	for key, e := range q.entries {
+		if matchesRefs(fs.Args(), e.Chart, e.Version) {
+			delete(q.entries, key)
+			cleared++
+		}
+	}
+	if err := q.save(); err != nil {
+		logln("Error saving quarantine:", err)
+		os.Exit(1)
+	}
+	logf("Cleared %d versions\n", cleared)
+}
+
+// matchesRefs reports whether CHART or CHART@VERSION refs name a version,
+// no refs match everything.
+func matchesRefs(refs []string, chart, version string) bool {
+	if len(refs) == 0 {
+		return true
+	}
+	for _, ref := range refs {
+		name, v, ok := strings.Cut(ref, "@")
+		if name == chart && (!ok || v == version) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package chartsync
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Messages and reports are scrubbed of the credentials configured for the
+// run, the passwords of urls, secret query parameters like SAS and
+// presigned signatures, and auth headers, before they are printed or
+// written, so a failed request can't echo a secret.
+var (
+	userinfoPattern = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^/\s:@]+):[^/\s@]+@`)
+	queryPattern    = regexp.MustCompile(`(?i)([?&](?:[a-z0-9_-]*token|sig|signature|x-amz-signature|password|secret|api_?key|client_secret|x-amz-credential)=)[^&\s"']+`)
+	headerPattern   = regexp.MustCompile(`(?i)\b(authorization|proxy-authorization|private-token|x-jfrog-art-api)(["']?\s*[:=]\s*["']?)[^\r\n"',]+`)
+	bearerPattern   = regexp.MustCompile(`(?i)\b(bearer|basic)\s+[A-Za-z0-9._~+/=-]{8,}`)
+)
+
+const redacted = "xxxxx"
+
+// secrets are the credential values redact replaces wherever they show up.
+var (
+	secretsMu sync.Mutex
+	secrets   []string
+	scrubber  *strings.Replacer
+)
+
+// addSecret makes redact replace s, values too short to be secrets are
+// left alone so messages stay readable.
+func addSecret(s string) {
+	if len(s) < 4 {
+		return
+	}
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, known := range secrets {
+		if known == s {
+			return
+		}
+	}
+	secrets = append(secrets, s)
+	// Longest first, so a secret containing another is replaced whole.
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	pairs := make([]string, 0, 2*len(secrets))
+	for _, known := range secrets {
+		pairs = append(pairs, known, redacted)
+	}
+	scrubber = strings.NewReplacer(pairs...)
+}
+
+// addSecrets registers the secrets of auth.
+func addSecrets(auth credentials) {
+	for _, s := range []string{auth.Password, auth.Token, auth.APIKey, auth.OIDCClientSecret} {
+		addSecret(s)
+	}
+	for _, v := range auth.Headers {
+		addSecret(v)
+	}
+}
+
+// registerSecrets registers the secrets of both sides and every tenant.
+func (c *config) registerSecrets() {
+	addSecrets(c.SourceAuth)
+	addSecrets(c.DestinationAuth)
+	for _, s := range c.Stages {
+		addSecrets(s.Auth)
+	}
+	overrides := append([]tenantConfig(nil), c.Tenants...)
+	for _, s := range c.Spokes {
+		overrides = append(overrides, s.tenantConfig)
+	}
+	for _, t := range overrides {
+		if t.SourceAuth != nil {
+			addSecrets(*t.SourceAuth)
+		}
+		if t.DestinationAuth != nil {
+			addSecrets(*t.DestinationAuth)
+		}
+	}
+}
+
+// redact scrubs the secrets out of s.
+func redact(s string) string {
+	secretsMu.Lock()
+	r := scrubber
+	secretsMu.Unlock()
+	if r != nil {
+		s = r.Replace(s)
+	}
+	s = userinfoPattern.ReplaceAllString(s, "$1:"+redacted+"@")
+	s = queryPattern.ReplaceAllString(s, "${1}"+redacted)
+	s = headerPattern.ReplaceAllString(s, "${1}${2}"+redacted)
+	return bearerPattern.ReplaceAllString(s, "${1} "+redacted)
+}
+
+// logf and logln print progress and diagnostics like fmt.Printf and
+// fmt.Println on stderr, redacted.
+func logf(format string, a ...any) {
+	os.Stderr.WriteString(paintStatus(os.Stderr, redact(fmt.Sprintf(format, a...))))
+}
+
+func logln(a ...any) {
+	os.Stderr.WriteString(paintStatus(os.Stderr, redact(fmt.Sprintln(a...))))
+}
+
+// outf and outln print the output of a command, like diffs and dry runs, on
+// stdout so it can be piped, redacted.
+func outf(format string, a ...any) {
+	os.Stdout.WriteString(paintStatus(os.Stdout, redact(fmt.Sprintf(format, a...))))
+}
+
+func outln(a ...any) {
+	os.Stdout.WriteString(paintStatus(os.Stdout, redact(fmt.Sprintln(a...))))
+}
@@ -0,0 +1,132 @@
+package chartsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Repository is a chart repository backend. The built in backends are
+// returned by Client.Repository, others are synced like them once wrapped
+// with NewRepositoryClient, without changes to the sync engine.
+type Repository interface {
+	ListCharts(ctx context.Context) (ChartData, error)
+	Fetch(ctx context.Context, chart, version string) ([]byte, error)
+	Push(ctx context.Context, chart, version string, data []byte) error
+	Delete(ctx context.Context, chart, version string) error
+	Capabilities() Capabilities
+}
+
+// Capabilities are what a Repository supports beyond listing and fetching.
+type Capabilities struct {
+	Push       bool
+	Delete     bool
+	Provenance bool
+	Stream     bool
+}
+
+var errUnsupported = errors.New("not supported")
+
+// ChartMuseum returns the chartmuseum api at url as a Repository, tenant
+// is the org/repo path on a multitenant server.
+func ChartMuseum(url, tenant string, auth Auth) Repository {
+	r := repo{server: url, tenant: normalizeTenant(tenant), auth: credentials(auth)}
+	return builtinRepository{src: r, dst: r}
+}
+
+// builtinRepository is a backend of this package as a Repository, dst is
+// nil for those that can only be a source.
+type builtinRepository struct {
+	src chartSource
+	dst chartDestination
+}
+
+// Repository returns the backend of the client's url.
+func (c *Client) Repository(ctx context.Context) (Repository, error) {
+	if c.plugged != nil {
+		return c.plugged.Repository, nil
+	}
+	src, err := c.source(ctx, Options{})
+	if err != nil {
+		return nil, err
+	}
+	dst, _ := c.destination(ctx, Options{})
+	return builtinRepository{src: src, dst: dst}, nil
+}
+
+func (b builtinRepository) ListCharts(ctx context.Context) (ChartData, error) {
+	return b.src.listCharts(ctx)
+}
+
+func (b builtinRepository) Fetch(ctx context.Context, chart, version string) ([]byte, error) {
+	return b.src.fetchChart(ctx, chart, version)
+}
+
+func (b builtinRepository) Push(ctx context.Context, chart, version string, data []byte) error {
+	if b.dst == nil {
+		return fmt.Errorf("pushing charts to %s: %w", b.src, errUnsupported)
+	}
+	return b.dst.pushChart(ctx, chart, version, data)
+}
+
+func (b builtinRepository) Delete(ctx context.Context, chart, version string) error {
+	if b.dst == nil {
+		return fmt.Errorf("deleting charts from %s: %w", b.src, errUnsupported)
+	}
+	return deleteChart(ctx, b.dst, chart, version)
+}
+
+func (b builtinRepository) Capabilities() Capabilities {
+	_, prov := b.src.(interface {
+		fetchProvenance(ctx context.Context, chart, version string) ([]byte, error)
+	})
+	_, stream := b.src.(chartStreamer)
+	caps := Capabilities{Provenance: prov, Stream: stream}
+	if _, ok := b.dst.(*snapshotRepo); b.dst != nil && !ok {
+		caps.Push = true
+		_, _, err := deleteURL(b.dst, "", "")
+		caps.Delete = err == nil
+	}
+	return caps
+}
+
+// pluggedRepository runs a Repository through the sync engine.
+type pluggedRepository struct {
+	Repository
+	name string
+}
+
+// NewRepositoryClient returns a client syncing from or to r, name is how
+// it shows up in messages.
+func NewRepositoryClient(r Repository, name string) *Client {
+	if b, ok := r.(builtinRepository); ok {
+		return &Client{cfg: &config{Source: name}, builtin: &b}
+	}
+	return &Client{cfg: &config{Source: name}, plugged: &pluggedRepository{r, name}}
+}
+
+func (p pluggedRepository) String() string {
+	return p.name
+}
+
+func (p pluggedRepository) ping(ctx context.Context) error {
+	if pinger, ok := p.Repository.(interface{ Ping(context.Context) error }); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (p pluggedRepository) listCharts(ctx context.Context) (ChartData, error) {
+	return p.ListCharts(ctx)
+}
+
+func (p pluggedRepository) fetchChart(ctx context.Context, chart, version string) ([]byte, error) {
+	return p.Fetch(ctx, chart, version)
+}
+
+func (p pluggedRepository) pushChart(ctx context.Context, chart, version string, data []byte) error {
+	if !p.Capabilities().Push {
+		return fmt.Errorf("pushing charts to %s: %w", p.name, errUnsupported)
+	}
+	return p.Push(ctx, chart, version, data)
+}
@@ -0,0 +1,131 @@
+package chartsync
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const resumeAttempts = 5
+
+// resumableBody is a download that picks up where it broke off: after a
+// failed read it asks for the rest with a Range request, pinned to the
+// same file with If-Range. A download that had to be resumed is checked
+// against the digest from the index once it is complete.
+type resumableBody struct {
+	ctx       context.Context
+	r         repo
+	u         string
+	body      io.ReadCloser
+	read      int64
+	size      int64
+	validator string
+
+	h       hash.Hash
+	digest  string
+	resumed bool
+	retries int
+}
+
+func newResumableBody(ctx context.Context, r repo, u string, resp *http.Response) *resumableBody {
+	b := &resumableBody{ctx: ctx, r: r, u: u, body: resp.Body, size: resp.ContentLength, h: sha256.New()}
+	if etag := resp.Header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		b.validator = etag
+	} else {
+		b.validator = resp.Header.Get("Last-Modified")
+	}
+	return b
+}
+
+// expectDigest sets the sha256 the chart has according to the index.
+func (b *resumableBody) expectDigest(digest string) {
+	b.digest = digest
+}
+
+func (b *resumableBody) Read(p []byte) (int, error) {
+	for {
+		n, err := b.body.Read(p)
+		b.read += int64(n)
+		b.h.Write(p[:n])
+		if err == io.EOF && b.size >= 0 && b.read < b.size {
+			err = io.ErrUnexpectedEOF
+		}
+		switch {
+		case err == nil:
+			return n, nil
+		case err == io.EOF:
+			if b.resumed && b.digest != "" && hex.EncodeToString(b.h.Sum(nil)) != b.digest {
+				return n, corruptf("checksum mismatch after resuming %s", b.u)
+			}
+			return n, io.EOF
+		case b.retries >= resumeAttempts || b.ctx.Err() != nil:
+			return n, err
+		}
+		if rerr := b.resume(b.ctx); rerr != nil {
+			return n, fmt.Errorf("%w, resuming failed: %v", err, rerr)
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (b *resumableBody) resume(ctx context.Context) error {
+	b.body.Close()
+	var err error
+	for b.retries < resumeAttempts {
+		b.retries++
+		if !sleep(ctx, time.Duration(b.retries)*time.Second) {
+			return ctx.Err()
+		}
+		if err = b.reopen(ctx); err == nil {
+			b.resumed = true
+			return nil
+		}
+	}
+	return err
+}
+
+// reopen requests the rest of the file, servers without range support
+// send all of it and the part already read is skipped.
+func (b *resumableBody) reopen(ctx context.Context) error {
+	req, err := b.r.newRequest(ctx, "GET", b.u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", b.read))
+	if b.validator != "" {
+		req.Header.Set("If-Range", b.validator)
+	}
+	resp, err := b.r.do(req)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", b.read)) {
+			resp.Body.Close()
+			return fmt.Errorf("unexpected Content-Range %q", resp.Header.Get("Content-Range"))
+		}
+	case http.StatusOK:
+		if _, err := io.CopyN(io.Discard, resp.Body, b.read); err != nil {
+			resp.Body.Close()
+			return err
+		}
+	default:
+		resp.Body.Close()
+		return unexpectedStatus(resp.StatusCode)
+	}
+	b.body = resp.Body
+	return nil
+}
+
+func (b *resumableBody) Close() error {
+	return b.body.Close()
+}
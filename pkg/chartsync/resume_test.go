@@ -0,0 +1,113 @@
+package chartsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// flakyServer serves data, breaking off the first response half way.
+// Ranged requests get 206 when ranges is set and If-Range matches the
+// ETag, the whole file otherwise. tail is sent instead of the rest of data
+// on a 206.
+type flakyServer struct {
+	data   []byte
+	ranges bool
+	tail   []byte
+
+	mu       sync.Mutex
+	requests []http.Header
+}
+
+func (s *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.Header.Clone())
+	first := len(s.requests) == 1
+	s.mu.Unlock()
+
+	w.Header().Set("ETag", `"v1"`)
+	var start int
+	if rng := r.Header.Get("Range"); s.ranges && rng != "" && r.Header.Get("If-Range") == `"v1"` {
+		if _, err := fmt.Sscanf(rng, "bytes=%d-", &start); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rest := s.data[start:]
+		if s.tail != nil {
+			rest = s.tail
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(s.data)-1, len(s.data)))
+		w.Header().Set("Content-Length", strconv.Itoa(len(rest)))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(rest)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(s.data)))
+	if first {
+		w.Write(s.data[:len(s.data)/2])
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.Write(s.data)
+}
+
+func TestResumableBody(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	tests := []struct {
+		name    string
+		server  *flakyServer
+		corrupt bool
+	}{
+		{"range", &flakyServer{data: data, ranges: true}, false},
+		{"no range support", &flakyServer{data: data}, false},
+		{"changed while resuming", &flakyServer{data: data, ranges: true, tail: bytes.Repeat([]byte("x"), len(data)-len(data)/2)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(tt.server)
+			defer srv.Close()
+			body, size, err := openURL(context.Background(), repo{}, srv.URL+"/web-1.0.0.tgz")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer body.Close()
+			if size != int64(len(data)) {
+				t.Errorf("size = %d, want %d", size, len(data))
+			}
+			body.(*resumableBody).expectDigest(chartDigest(data))
+			got, err := io.ReadAll(body)
+			if tt.corrupt {
+				if !errors.Is(err, ErrCorrupt) {
+					t.Fatalf("reading a chart that changed while resuming = %v, want a corrupt error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, data) {
+				t.Fatalf("read %d bytes that differ from the %d served", len(got), len(data))
+			}
+
+			tt.server.mu.Lock()
+			defer tt.server.mu.Unlock()
+			if len(tt.server.requests) != 2 {
+				t.Fatalf("%d requests, want 2", len(tt.server.requests))
+			}
+			resumed := tt.server.requests[1]
+			if want := fmt.Sprintf("bytes=%d-", len(data)/2); resumed.Get("Range") != want {
+				t.Errorf("Range = %q, want %q", resumed.Get("Range"), want)
+			}
+			if resumed.Get("If-Range") != `"v1"` {
+				t.Errorf("If-Range = %q, want the ETag", resumed.Get("If-Range"))
+			}
+		})
+	}
+}
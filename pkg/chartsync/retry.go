@@ -0,0 +1,165 @@
+package chartsync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxRetryBackoff caps the backoff of versions that keep failing.
+const maxRetryBackoff = 24 * time.Hour
+
+// retryQueue keeps the versions that failed in retry.json of -index-cache.
+// Later runs transfer them before anything else once their backoff, which
+// doubles with every attempt, is over.
+type retryQueue struct {
+	mu      sync.Mutex
+	path    string
+	backoff time.Duration
+	entries map[string]*retryEntry
+}
+
+type retryEntry struct {
+	Destination string    `json:"destination"`
+	Chart       string    `json:"chart"`
+	Version     string    `json:"version"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error"`
+}
+
+func loadRetryQueue(dir string, backoff time.Duration) (*retryQueue, error) {
+	q := &retryQueue{path: filepath.Join(dir, "retry.json"), backoff: backoff, entries: make(map[string]*retryEntry)}
+	data, err := os.ReadFile(q.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*retryEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", q.path, err)
+	}
+	for _, e := range entries {
+		q.entries[quarantineKey(e.Destination, syncItem{Chart: e.Chart, Version: e.Version})] = e
+	}
+	return q, nil
+}
+
+func (q *retryQueue) save() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	entries := make([]*retryEntry, 0, len(q.entries))
+	for _, e := range q.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].NextAttempt.Equal(entries[j].NextAttempt) {
+			return entries[i].NextAttempt.Before(entries[j].NextAttempt)
+		}
+		return quarantineKey(entries[i].Destination, syncItem{Chart: entries[i].Chart, Version: entries[i].Version}) <
+			quarantineKey(entries[j].Destination, syncItem{Chart: entries[j].Chart, Version: entries[j].Version})
+	})
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(q.path, append(data, '\n'), 0o644)
+}
+
+// order moves the due retries of a destination to the front of its queue
+// and holds back those still backing off. Entries no longer queued are
+// dropped, the destination got them some other way.
+func (q *retryQueue) order(destination string, w nameWindow, queue []syncItem) ([]syncItem, []syncItem) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	queued := make(map[string]bool, len(queue))
+	var retries, rest, held []syncItem
+	for _, item := range queue {
+		key := quarantineKey(destination, item)
+		queued[key] = true
+		e, ok := q.entries[key]
+		switch {
+		case !ok:
+			rest = append(rest, item)
+		case now.Before(e.NextAttempt):
+			logf("Retrying %s-%s in %s, attempt %d failed: %s\n", item.Chart, item.Version, e.NextAttempt.Sub(now).Round(time.Second), e.Attempts, e.LastError)
+			held = append(held, item)
+		default:
+			retries = append(retries, item)
+		}
+	}
+	for key, e := range q.entries {
+		if e.Destination == destination && w.contains(e.Chart) && !queued[key] {
+			delete(q.entries, key)
+		}
+	}
+	sort.SliceStable(retries, func(i, j int) bool {
+		return q.entries[quarantineKey(destination, retries[i])].NextAttempt.Before(q.entries[quarantineKey(destination, retries[j])].NextAttempt)
+	})
+	return append(retries, rest...), held
+}
+
+// reporter records the outcome of the versions synced to destination.
+func (q *retryQueue) reporter(destination string) Reporter {
+	return retryReporter{q, destination}
+}
+
+type retryReporter struct {
+	q           *retryQueue
+	destination string
+}
+
+func (r retryReporter) Synced(v Version, size int64) {
+	r.q.mu.Lock()
+	defer r.q.mu.Unlock()
+	delete(r.q.entries, quarantineKey(r.destination, syncItem{Chart: v.Chart, Version: v.Version}))
+}
+
+func (r retryReporter) Skipped(v Version) {}
+
+func (r retryReporter) Failed(v Version, err error) {
+	r.q.mu.Lock()
+	defer r.q.mu.Unlock()
+	key := quarantineKey(r.destination, syncItem{Chart: v.Chart, Version: v.Version})
+	e, ok := r.q.entries[key]
+	if !ok {
+		e = &retryEntry{Destination: r.destination, Chart: v.Chart, Version: v.Version}
+		r.q.entries[key] = e
+	}
+	e.Attempts++
+	e.LastError = redact(err.Error())
+	backoff := r.q.backoff << min(e.Attempts-1, 16)
+	if backoff <= 0 || backoff > maxRetryBackoff {
+		backoff = maxRetryBackoff
+	}
+	e.NextAttempt = time.Now().Add(backoff).UTC()
+}
+
+// reporters hands the outcomes to each of them.
+type reporters []Reporter
+
+func (rs reporters) Synced(v Version, size int64) {
+	for _, r := range rs {
+		r.Synced(v, size)
+	}
+}
+
+func (rs reporters) Skipped(v Version) {
+	for _, r := range rs {
+		r.Skipped(v)
+	}
+}
+
+func (rs reporters) Failed(v Version, err error) {
+	for _, r := range rs {
+		r.Failed(v, err)
+	}
+}
@@ -0,0 +1,125 @@
+package chartsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// s3Store keeps the repository below prefix in an S3 bucket, the region
+// and an S3 compatible endpoint (e.g. minio) can be set with the region
+// and endpoint query parameters: s3://bucket/prefix?endpoint=http://minio:9000
+type s3Store struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func newS3Store(ref, tenant string, auth credentials, client *http.Client) (*s3Store, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	prefix := strings.Trim(u.Path, "/")
+	if tenant != "" {
+		prefix = strings.Trim(prefix+"/"+tenant, "/")
+	}
+
+	opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithHTTPClient(client)}
+	if region := u.Query().Get("region"); region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+	if auth.AWSProfile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(auth.AWSProfile))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error loading aws config: %w", err)
+	}
+	endpoint := u.Query().Get("endpoint")
+	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.HTTPClient = client
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return &s3Store{client: s3Client, bucket: u.Host, prefix: prefix}, nil
+}
+
+func (s *s3Store) String() string {
+	return "s3://" + s.bucket + "/" + s.prefix
+}
+
+func (s *s3Store) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+func (s *s3Store) ping(ctx context.Context) error {
+	_, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.bucket)})
+	return err
+}
+
+func (s *s3Store) list(ctx context.Context) ([]string, error) {
+	prefix := s.key("")
+	var names []string
+	p := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket:    aws.String(s.bucket),
+		Prefix:    aws.String(prefix),
+		Delimiter: aws.String("/"),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			names = append(names, strings.TrimPrefix(aws.ToString(obj.Key), prefix))
+		}
+	}
+	return names, nil
+}
+
+func (s *s3Store) read(ctx context.Context, name string) ([]byte, error) {
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchKey" {
+			return nil, errNotExist
+		}
+		return nil, err
+	}
+	defer out.Body.Close()
+	return io.ReadAll(out.Body)
+}
+
+// write needs a seekable reader to sign the request.
+func (s *s3Store) write(ctx context.Context, name string, r io.Reader, size int64) error {
+	contentType := "application/gzip"
+	if strings.HasSuffix(name, ".yaml") {
+		contentType = "application/x-yaml"
+	}
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(s.key(name)),
+		Body:          r,
+		ContentLength: aws.Int64(size),
+		ContentType:   aws.String(contentType),
+	})
+	return err
+}
@@ -0,0 +1,114 @@
+package chartsync
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+type searchResult struct {
+	Source       string `json:"source" yaml:"source"`
+	ChartVersion `yaml:",inline"`
+}
+
+// matchChart reports whether term occurs in the name, description or one
+// of the keywords of cv, ignoring case.
+func matchChart(cv ChartVersion, term string) bool {
+	term = strings.ToLower(term)
+	if strings.Contains(strings.ToLower(cv.Name), term) || strings.Contains(strings.ToLower(cv.Description), term) {
+		return true
+	}
+	for _, k := range cv.Keywords {
+		if strings.Contains(strings.ToLower(k), term) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchCharts returns the versions of data matching term, only the newest
+// one of each chart unless all is set.
+func searchCharts(data ChartData, term string, all bool) []ChartVersion {
+	var found []ChartVersion
+	for _, versions := range data {
+		versions = append([]ChartVersion(nil), versions...)
+		sortVersions(versions)
+		for _, cv := range versions {
+			if matchChart(cv, term) {
+				found = append(found, cv)
+				if !all {
+					break
+				}
+			}
+		}
+	}
+	sortChartVersions(found, "name")
+	return found
+}
+
+// runSearch looks for charts by name, description and keywords in one or
+// more sources, e.g. all of our mirrors at once.
+func runSearch(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+	sf := addSourceFlags(fs)
+	all := fs.Bool("versions", false, "list every matching version, not only the newest of each chart")
+	format := fs.String("o", "table", "output format, table, json or yaml")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cm_sync search [flags] TERM, -s takes a comma separated list of sources")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	term := strings.Join(fs.Args(), " ")
+	if term == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *format != "table" && *format != "json" && *format != "yaml" {
+		logln("Error parsing -o: unknown output format", *format+", use table, json or yaml")
+		os.Exit(1)
+	}
+	cfg := sf.config(fs)
+
+	results := []searchResult{}
+	for _, source := range splitList(cfg.Source) {
+		c := *cfg
+		c.Source = source
+		src, data := openSource(ctx, &c, "")
+		for _, cv := range searchCharts(data, term, *all) {
+			results = append(results, searchResult{Source: fmt.Sprint(src), ChartVersion: cv})
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+
+	var err error
+	switch *format {
+	case "table":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "CHART\tVERSION\tAPP VERSION\tSOURCE\tDESCRIPTION")
+		for _, r := range results {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", r.Name, r.Version, r.AppVersion, r.Source, r.Description)
+		}
+		err = tw.Flush()
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(results)
+	case "yaml":
+		enc := yaml.NewEncoder(os.Stdout)
+		enc.SetIndent(2)
+		if err = enc.Encode(results); err == nil {
+			err = enc.Close()
+		}
+	}
+	if err != nil {
+		logln("Error writing results:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,227 @@
+package chartsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpStore keeps the repository in a directory on an ssh host. It logs in
+// with the ssh agent, the key in ?key= or the default keys in ~/.ssh, or a
+// password, and checks the host against ~/.ssh/known_hosts (or ?known_hosts=).
+type sftpStore struct {
+	addr   string
+	dir    string
+	config *ssh.ClientConfig
+
+	mu     sync.Mutex
+	client *sftp.Client
+}
+
+func newSFTPStore(ref, tenant string, auth credentials) (*sftpStore, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, err
+	}
+	user := u.User.Username()
+	if user == "" {
+		user = auth.Username
+	}
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	home, _ := os.UserHomeDir()
+
+	knownHostsFile := u.Query().Get("known_hosts")
+	if knownHostsFile == "" {
+		knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	hostKeys, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading known hosts: %w", err)
+	}
+
+	var methods []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	keyFiles := []string{u.Query().Get("key")}
+	if keyFiles[0] == "" {
+		keyFiles = []string{filepath.Join(home, ".ssh", "id_ed25519"), filepath.Join(home, ".ssh", "id_ecdsa"), filepath.Join(home, ".ssh", "id_rsa")}
+	}
+	var signers []ssh.Signer
+	for _, f := range keyFiles {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("error reading key %s: %w", f, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	password, ok := u.User.Password()
+	if !ok {
+		password = auth.Password
+	}
+	if password != "" {
+		methods = append(methods, ssh.Password(password))
+	}
+
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+	return &sftpStore{
+		addr: addr,
+		dir:  path.Join("/", u.Path, tenant),
+		config: &ssh.ClientConfig{
+			User:            user,
+			Auth:            methods,
+			HostKeyCallback: hostKeys,
+		},
+	}, nil
+}
+
+func (s *sftpStore) String() string {
+	return "sftp://" + s.config.User + "@" + s.addr + s.dir
+}
+
+// sftp returns the open session, reconnecting after the connection broke.
+func (s *sftpStore) sftp(ctx context.Context) (*sftp.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		if _, err := s.client.Getwd(); err == nil {
+			return s.client, nil
+		}
+		s.client.Close()
+		s.client = nil
+	}
+	nc, err := (&net.Dialer{Timeout: s.config.Timeout}).DialContext(ctx, "tcp", s.addr)
+	if err != nil {
+		return nil, err
+	}
+	// The handshake is bounded by ctx too, closing the connection makes
+	// it fail.
+	stop := context.AfterFunc(ctx, func() { nc.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(nc, s.addr, s.config)
+	if !stop() {
+		if err == nil {
+			c.Close()
+		}
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	conn := ssh.NewClient(c, chans, reqs)
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	s.client = client
+	return client, nil
+}
+
+func (s *sftpStore) ping(ctx context.Context) error {
+	c, err := s.sftp(ctx)
+	if err != nil {
+		return err
+	}
+	if err := c.MkdirAll(s.dir); err != nil {
+		return err
+	}
+	info, err := c.Stat(s.dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", s.dir)
+	}
+	return nil
+}
+
+func (s *sftpStore) list(ctx context.Context) ([]string, error) {
+	c, err := s.sftp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := c.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.Mode().IsRegular() {
+			names = append(names, e.Name())
+		}
+	}
+	return names, nil
+}
+
+func (s *sftpStore) read(ctx context.Context, name string) ([]byte, error) {
+	c, err := s.sftp(ctx)
+	if err != nil {
+		return nil, err
+	}
+	f, err := c.Open(path.Join(s.dir, name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, errNotExist
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(throttle(ctx, f, downLimit))
+}
+
+// write uploads to a temporary name and renames it into place, so readers
+// of the directory never see partial files.
+func (s *sftpStore) write(ctx context.Context, name string, r io.Reader, size int64) error {
+	c, err := s.sftp(ctx)
+	if err != nil {
+		return err
+	}
+	target := path.Join(s.dir, name)
+	tmp := path.Join(path.Dir(target), "."+path.Base(target)+".tmp")
+	f, err := c.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, throttle(ctx, r, upLimit)); err != nil {
+		f.Close()
+		c.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		c.Remove(tmp)
+		return err
+	}
+	if err := c.PosixRename(tmp, target); err != nil {
+		c.Remove(target)
+		return c.Rename(tmp, target)
+	}
+	return nil
+}
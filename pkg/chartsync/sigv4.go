@@ -0,0 +1,131 @@
+package chartsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+)
+
+// executeAPIHostPattern matches API Gateway hosts, the region of servers
+// behind one is taken from their host.
+var executeAPIHostPattern = regexp.MustCompile(`\.execute-api\.([a-z0-9-]+)\.amazonaws\.com$`)
+
+// sigv4Configs are the aws configs of the regions, profiles and http
+// clients requests are signed for, the sdk caches and refreshes their
+// credentials.
+var (
+	sigv4Mu      sync.Mutex
+	sigv4Configs = map[sigv4Key]aws.Config{}
+	sigv4Signer  = v4.NewSigner()
+)
+
+type sigv4Key struct {
+	region, profile string
+	client          *http.Client
+}
+
+// spoolBody is a request body spooled by payloadHash, the spool is
+// removed when the transport closes the body.
+type spoolBody struct {
+	*io.SectionReader
+	sp *spool
+}
+
+func (b spoolBody) Close() error {
+	return b.sp.close()
+}
+
+// payloadHash returns the sha256 of body, which SigV4 signs, and a body
+// that still reads from the start. Seekable bodies are rewound, others
+// are spooled like charts, past -max-memory into a file the returned
+// body removes when closed.
+func payloadHash(body io.Reader) (io.Reader, string, error) {
+	h := sha256.New()
+	if body == nil {
+		return nil, hex.EncodeToString(h.Sum(nil)), nil
+	}
+	if s, ok := body.(io.ReadSeeker); ok {
+		start, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := io.Copy(h, s); err != nil {
+			return nil, "", err
+		}
+		if _, err := s.Seek(start, io.SeekStart); err != nil {
+			return nil, "", err
+		}
+		return body, hex.EncodeToString(h.Sum(nil)), nil
+	}
+	sp, err := newSpool(io.TeeReader(body, h))
+	if err != nil {
+		return nil, "", err
+	}
+	if sp.f == nil {
+		return bytes.NewReader(sp.data), hex.EncodeToString(h.Sum(nil)), nil
+	}
+	return spoolBody{io.NewSectionReader(sp.f, 0, sp.size), sp}, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// signV4 signs req for auth.AWSService, execute-api by default, with the
+// default AWS credential chain or that of auth.AWSProfile. The credentials
+// are fetched through client.
+func signV4(ctx context.Context, req *http.Request, hash string, auth credentials, client *http.Client) error {
+	region := auth.AWSRegion
+	if m := executeAPIHostPattern.FindStringSubmatch(req.URL.Hostname()); region == "" && m != nil {
+		region = m[1]
+	}
+	client = clientOr(client)
+	key := sigv4Key{region, auth.AWSProfile, client}
+	sigv4Mu.Lock()
+	cfg, ok := sigv4Configs[key]
+	if !ok {
+		opts := []func(*awsconfig.LoadOptions) error{awsconfig.WithRegion(region), awsconfig.WithHTTPClient(client)}
+		if auth.AWSProfile != "" {
+			opts = append(opts, awsconfig.WithSharedConfigProfile(auth.AWSProfile))
+		}
+		var err error
+		if cfg, err = awsconfig.LoadDefaultConfig(ctx, opts...); err != nil {
+			sigv4Mu.Unlock()
+			return fmt.Errorf("error loading aws config: %w", err)
+		}
+		sigv4Configs[key] = cfg
+	}
+	sigv4Mu.Unlock()
+	if cfg.Region == "" {
+		return fmt.Errorf("no aws region to sign requests to %s for, set -aws-region", req.URL.Host)
+	}
+	creds, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return fmt.Errorf("error getting aws credentials: %w", err)
+	}
+	service := auth.AWSService
+	if service == "" {
+		service = "execute-api"
+	}
+	return sigv4Signer.SignHTTP(ctx, creds, req, hash, service, cfg.Region, time.Now())
+}
+
+// applyAWSFlags sets -aws-region and -aws-service over the sigv4 settings
+// of both sides.
+func applyAWSFlags(cfg *config, set map[string]bool, region, service string) {
+	for _, auth := range []*credentials{&cfg.SourceAuth, &cfg.DestinationAuth} {
+		if set["aws-region"] {
+			auth.AWSRegion = region
+		}
+		if set["aws-service"] {
+			auth.AWSService = service
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package chartsync
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPayloadHashSpools(t *testing.T) {
+	spoolLimit, spoolDir = 4, t.TempDir()
+	t.Cleanup(func() { spoolLimit, spoolDir = 0, "" })
+
+	payload := "a chart bigger than the spool limit"
+	sum := sha256.Sum256([]byte(payload))
+	// io.MultiReader hides the Seeker of strings.Reader.
+	body, hash, err := payloadHash(io.MultiReader(strings.NewReader(payload)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hash != hex.EncodeToString(sum[:]) {
+		t.Errorf("hash = %s, want %x", hash, sum)
+	}
+	b, ok := body.(spoolBody)
+	if !ok {
+		t.Fatalf("body is a %T, want it spooled to a file", body)
+	}
+	data, err := io.ReadAll(b)
+	if err != nil || string(data) != payload {
+		t.Errorf("body reads %q, %v, want the payload", data, err)
+	}
+	b.Close()
+	if _, err := os.Stat(b.sp.f.Name()); !os.IsNotExist(err) {
+		t.Errorf("spool file is still there after Close: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package chartsync
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// snapshotRepo is a chart list saved earlier, a /api/charts response, an
+// index.yaml or the json or yaml of `cm_sync list`. It lets -dry-run and
+// diff plan without network access, charts can't be transferred.
+type snapshotRepo struct {
+	path string
+	data ChartData
+}
+
+func newSnapshotRepo(path string) (*snapshotRepo, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := parseSnapshot(raw)
+	if err != nil {
+		return nil, fmt.Errorf("error reading index snapshot %s: %w", path, err)
+	}
+	return &snapshotRepo{path: path, data: data}, nil
+}
+
+func parseSnapshot(raw []byte) (ChartData, error) {
+	trimmed := strings.TrimSpace(string(raw))
+	var list []ChartVersion
+	switch {
+	case strings.HasPrefix(trimmed, "{"):
+		var data ChartData
+		return data, json.Unmarshal(raw, &data)
+	case strings.HasPrefix(trimmed, "["):
+		if err := json.Unmarshal(raw, &list); err != nil {
+			return nil, err
+		}
+	case strings.HasPrefix(trimmed, "-"):
+		if err := yaml.Unmarshal(raw, &list); err != nil {
+			return nil, err
+		}
+	default:
+		var idx indexFile
+		if err := yaml.Unmarshal(raw, &idx); err != nil {
+			return nil, err
+		}
+		if idx.Entries == nil {
+			return nil, fmt.Errorf("no entries")
+		}
+		return idx.Entries, nil
+	}
+	data := make(ChartData)
+	for _, cv := range list {
+		data[cv.Name] = append(data[cv.Name], cv)
+	}
+	return data, nil
+}
+
+func (s *snapshotRepo) String() string {
+	return s.path
+}
+
+func (s *snapshotRepo) ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *snapshotRepo) listCharts(ctx context.Context) (ChartData, error) {
+	return s.data, nil
+}
+
+func (s *snapshotRepo) fetchChart(ctx context.Context, chart, version string) ([]byte, error) {
+	return nil, fmt.Errorf("%s is an index snapshot, charts can't be fetched from it", s.path)
+}
+
+func (s *snapshotRepo) pushChart(ctx context.Context, chart, version string, data []byte) error {
+	return fmt.Errorf("%s is an index snapshot, charts can't be pushed to it", s.path)
+}
@@ -0,0 +1,76 @@
+package chartsync
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// snsPublisher publishes events to an SNS topic, with the event name as
+// message attribute for subscription filters.
+type snsPublisher struct {
+	client *sns.Client
+	topic  string
+}
+
+// newSNSPublisher takes sns://arn:aws:sns:region:account:topic, the
+// region comes from the arn. ?endpoint= sets a custom endpoint.
+func newSNSPublisher(ctx context.Context, target string) (*snsPublisher, error) {
+	arn, query, _ := strings.Cut(strings.TrimPrefix(target, "sns://"), "?")
+	parts := strings.Split(arn, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "sns" {
+		return nil, fmt.Errorf("%s isn't an sns topic arn", arn)
+	}
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(parts[3]))
+	if err != nil {
+		return nil, fmt.Errorf("error loading aws config: %w", err)
+	}
+	client := sns.NewFromConfig(cfg, func(o *sns.Options) {
+		o.HTTPClient = httpClient
+		if endpoint := q.Get("endpoint"); endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+	return &snsPublisher{client, arn}, nil
+}
+
+func (p *snsPublisher) publish(ctx context.Context, events []syncEvent) error {
+	// PublishBatch takes up to 10 messages.
+	for len(events) > 0 {
+		n := min(len(events), 10)
+		entries := make([]snstypes.PublishBatchRequestEntry, n)
+		for i, e := range events[:n] {
+			entries[i] = snstypes.PublishBatchRequestEntry{
+				Id:      aws.String(strconv.Itoa(i)),
+				Message: aws.String(string(e.json())),
+				MessageAttributes: map[string]snstypes.MessageAttributeValue{
+					"event": {DataType: aws.String("String"), StringValue: aws.String(e.Event)},
+				},
+			}
+		}
+		out, err := p.client.PublishBatch(ctx, &sns.PublishBatchInput{TopicArn: aws.String(p.topic), PublishBatchRequestEntries: entries})
+		if err != nil {
+			return err
+		}
+		if len(out.Failed) > 0 {
+			return fmt.Errorf("%d of %d events failed: %s", len(out.Failed), n, aws.ToString(out.Failed[0].Message))
+		}
+		events = events[n:]
+	}
+	return nil
+}
+
+func (p *snsPublisher) close() error {
+	return nil
+}
@@ -0,0 +1,230 @@
+package chartsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// spoolLimit is the largest chart held in memory, bigger ones are written
+// to a temporary file in spoolDir. 0 keeps everything in memory.
+var (
+	spoolLimit int64
+	spoolDir   string
+)
+
+// maxChartSize is the largest chart transferred, 0 is unlimited.
+var maxChartSize int64
+
+var errChartTooLarge = errors.New("chart is larger than -max-chart-size")
+
+// limitChart refuses a chart whose announced size, -1 if unknown, is over
+// maxChartSize and fails reading once more than that was read.
+func limitChart(r io.Reader, size int64) (io.Reader, error) {
+	if maxChartSize <= 0 {
+		return r, nil
+	}
+	if size > maxChartSize {
+		return nil, fmt.Errorf("%w (%d bytes)", errChartTooLarge, size)
+	}
+	return &sizeLimitReader{r: r, left: maxChartSize}, nil
+}
+
+type sizeLimitReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *sizeLimitReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	if l.left -= int64(n); l.left < 0 {
+		return n, errChartTooLarge
+	}
+	return n, err
+}
+
+// spool is a chart payload that can be read any number of times.
+type spool struct {
+	data []byte
+	f    *os.File
+	size int64
+	// cached is a chart read from -chart-cache, its file is kept.
+	cached bool
+}
+
+// fetchSpool fetches a chart from src into a spool, streaming it when src
+// can, or reads it from -chart-cache. digest is the chart's sha256 from the
+// index, if known. Registries list no digests, the cache asks them for the chart's layer.
+// A download that doesn't match digest fails as corrupt instead of passing on.
+func fetchSpool(ctx context.Context, src chartSource, chart, version, digest string) (*spool, error) {
+	if d, ok := src.(interface {
+		chartDigest(ctx context.Context, chart, version string) (string, error)
+	}); ok && digest == "" && charts != nil {
+		// A manifest that can't be read fails the download below.
+		digest, _ = d.chartDigest(ctx, chart, version)
+	}
+	if sp := charts.get(digest); sp != nil {
+		return sp, nil
+	}
+	sp, err := downloadSpool(ctx, src, chart, version, digest)
+	if err != nil {
+		return nil, err
+	}
+	if err := charts.put(digest, sp); errors.Is(err, ErrCorrupt) {
+		sp.close()
+		return nil, err
+	} else if err != nil {
+		logf("Failed to cache %s-%s %v\n", chart, version, err)
+	}
+	return sp, nil
+}
+
+func downloadSpool(ctx context.Context, src chartSource, chart, version, digest string) (*spool, error) {
+	if s, ok := src.(chartStreamer); ok {
+		body, size, err := s.openChart(ctx, chart, version)
+		if err != nil {
+			return nil, err
+		}
+		defer body.Close()
+		if d, ok := body.(interface{ expectDigest(string) }); ok {
+			d.expectDigest(digest)
+		}
+		r, err := limitChart(body, size)
+		if err != nil {
+			return nil, err
+		}
+		return newSpool(r)
+	}
+	data, err := src.fetchChart(ctx, chart, version)
+	if err != nil {
+		return nil, err
+	}
+	if maxChartSize > 0 && int64(len(data)) > maxChartSize {
+		return nil, fmt.Errorf("%w (%d bytes)", errChartTooLarge, len(data))
+	}
+	return spoolBytes(data), nil
+}
+
+func spoolBytes(data []byte) *spool {
+	return &spool{data: data, size: int64(len(data))}
+}
+
+// newSpool reads r to the end, in memory up to spoolLimit and into a
+// temporary file past it.
+func newSpool(r io.Reader) (*spool, error) {
+	if spoolLimit <= 0 {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return spoolBytes(data), nil
+	}
+	var buf bytes.Buffer
+	_, err := io.CopyN(&buf, r, spoolLimit+1)
+	if err == io.EOF {
+		return spoolBytes(buf.Bytes()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.CreateTemp(spoolDir, "cm_sync-chart-")
+	if err != nil {
+		return nil, err
+	}
+	s := &spool{f: f}
+	rest, err := io.Copy(f, io.MultiReader(&buf, r))
+	if err != nil {
+		s.close()
+		return nil, err
+	}
+	s.size = rest
+	return s, nil
+}
+
+// reader reads the payload from the start.
+func (s *spool) reader() io.ReadSeeker {
+	if s.f == nil {
+		return bytes.NewReader(s.data)
+	}
+	return io.NewSectionReader(s.f, 0, s.size)
+}
+
+// bytes loads the payload for destinations that only take a byte slice.
+func (s *spool) bytes() ([]byte, error) {
+	if s.f == nil {
+		return s.data, nil
+	}
+	return io.ReadAll(s.reader())
+}
+
+func (s *spool) digest() (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, s.reader()); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (s *spool) close() error {
+	if s.f == nil {
+		return nil
+	}
+	s.f.Close()
+	if s.cached {
+		return nil
+	}
+	return os.Remove(s.f.Name())
+}
+
+// setSpool applies -max-memory and -spool-dir.
+func setSpool(maxMemory, dir string) error {
+	spoolDir = dir
+	if maxMemory == "" {
+		return nil
+	}
+	var err error
+	spoolLimit, err = parseSize(maxMemory)
+	return err
+}
+
+// setMaxChartSize applies -max-chart-size.
+func setMaxChartSize(size string) error {
+	if size == "" {
+		return nil
+	}
+	var err error
+	maxChartSize, err = parseSize(size)
+	return err
+}
+
+// parseSize reads sizes like 512K, 64M or 1G, plain numbers are bytes.
+func parseSize(size string) (int64, error) {
+	s := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(size)), "B")
+	s = strings.TrimSuffix(s, "I")
+	mult := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		}
+		if mult > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", size)
+	}
+	return n * mult, nil
+}
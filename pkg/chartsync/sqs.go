@@ -0,0 +1,166 @@
+package chartsync
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+var sqsHostPattern = regexp.MustCompile(`^sqs\.([a-z0-9-]+)\.amazonaws\.com(\.cn)?$`)
+
+// newSQSClient takes sqs://sqs.region.amazonaws.com/account/queue and
+// returns the client and https url of the queue. ?region= and ?endpoint=
+// are for queues elsewhere, like localstack.
+func newSQSClient(ctx context.Context, target string) (*sqs.Client, string, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, "", err
+	}
+	region := u.Query().Get("region")
+	if m := sqsHostPattern.FindStringSubmatch(u.Host); m != nil && region == "" {
+		region = m[1]
+	}
+	scheme := "https"
+	endpoint := u.Query().Get("endpoint")
+	if strings.HasPrefix(endpoint, "http://") {
+		scheme = "http"
+	}
+	queueURL := scheme + "://" + u.Host + u.Path
+
+	var opts []func(*awsconfig.LoadOptions) error
+	if region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, "", fmt.Errorf("error loading aws config: %w", err)
+	}
+	client := sqs.NewFromConfig(cfg, func(o *sqs.Options) {
+		o.HTTPClient = httpClient
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	})
+	return client, queueURL, nil
+}
+
+// sqsPublisher sends events to an SQS queue.
+type sqsPublisher struct {
+	client *sqs.Client
+	queue  string
+}
+
+func newSQSPublisher(ctx context.Context, target string) (*sqsPublisher, error) {
+	client, queue, err := newSQSClient(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	return &sqsPublisher{client, queue}, nil
+}
+
+func (p *sqsPublisher) publish(ctx context.Context, events []syncEvent) error {
+	// SendMessageBatch takes up to 10 messages.
+	for len(events) > 0 {
+		n := min(len(events), 10)
+		entries := make([]sqstypes.SendMessageBatchRequestEntry, n)
+		for i, e := range events[:n] {
+			entries[i] = sqstypes.SendMessageBatchRequestEntry{
+				Id:          aws.String(strconv.Itoa(i)),
+				MessageBody: aws.String(string(e.json())),
+				MessageAttributes: map[string]sqstypes.MessageAttributeValue{
+					"event": {DataType: aws.String("String"), StringValue: aws.String(e.Event)},
+				},
+			}
+		}
+		out, err := p.client.SendMessageBatch(ctx, &sqs.SendMessageBatchInput{QueueUrl: aws.String(p.queue), Entries: entries})
+		if err != nil {
+			return err
+		}
+		if len(out.Failed) > 0 {
+			return fmt.Errorf("%d of %d events failed: %s", len(out.Failed), n, aws.ToString(out.Failed[0].Message))
+		}
+		events = events[n:]
+	}
+	return nil
+}
+
+func (p *sqsPublisher) close() error {
+	return nil
+}
+
+// sqsTrigger starts daemon runs on the messages sent to an SQS queue, they
+// are deleted once the run they started is over.
+type sqsTrigger struct {
+	client   *sqs.Client
+	queue    string
+	requests chan []string
+}
+
+func newSQSTrigger(ctx context.Context, target string) (*sqsTrigger, error) {
+	if !strings.HasPrefix(target, "sqs://") {
+		return nil, fmt.Errorf("unsupported trigger %s, expected sqs://", target)
+	}
+	client, queue, err := newSQSClient(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	return &sqsTrigger{client, queue, make(chan []string)}, nil
+}
+
+func (t *sqsTrigger) String() string {
+	return t.queue
+}
+
+// listen long polls the queue until ctx is done, handing the receipt
+// handles of the messages it gets to requests.
+func (t *sqsTrigger) listen(ctx context.Context) {
+	for ctx.Err() == nil {
+		out, err := t.client.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(t.queue),
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     20,
+		})
+		if err != nil {
+			if ctx.Err() == nil {
+				logln("Failed to receive from", t.queue, err)
+				sleep(ctx, 30*time.Second)
+			}
+			continue
+		}
+		if len(out.Messages) == 0 {
+			continue
+		}
+		handles := make([]string, len(out.Messages))
+		for i, m := range out.Messages {
+			handles[i] = aws.ToString(m.ReceiptHandle)
+		}
+		select {
+		case t.requests <- handles:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// ack deletes the messages of a run that is over.
+func (t *sqsTrigger) ack(ctx context.Context, handles []string) {
+	for len(handles) > 0 {
+		n := min(len(handles), 10)
+		entries := make([]sqstypes.DeleteMessageBatchRequestEntry, n)
+		for i, h := range handles[:n] {
+			entries[i] = sqstypes.DeleteMessageBatchRequestEntry{Id: aws.String(strconv.Itoa(i)), ReceiptHandle: aws.String(h)}
+		}
+		if _, err := t.client.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{QueueUrl: aws.String(t.queue), Entries: entries}); err != nil {
+			logln("Failed to delete sync requests from", t.queue, err)
+		}
+		handles = handles[n:]
+	}
+}
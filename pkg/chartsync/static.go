@@ -0,0 +1,71 @@
+package chartsync
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// staticRepo is a plain helm repository that only serves index.yaml and
+// the tarballs it links to, e.g. GitHub Pages. It can only be a source.
+type staticRepo struct {
+	repo
+
+	mu    sync.Mutex
+	index ChartData
+}
+
+func newStaticRepo(server, tenant string, auth credentials, client *http.Client) *staticRepo {
+	return &staticRepo{repo: repo{server: server, tenant: tenant, auth: auth, client: client}}
+}
+
+func (s *staticRepo) ping(ctx context.Context) error {
+	_, err := getIndex(ctx, s.repo, s.url()+"/index.yaml")
+	return err
+}
+
+func (s *staticRepo) listCharts(ctx context.Context) (ChartData, error) {
+	data, err := fetchIndex(ctx, s.repo, s.url())
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.index = data
+	s.mu.Unlock()
+	return data, nil
+}
+
+func (s *staticRepo) indexVersion() string {
+	return indexes.digest(s.url() + "/index.yaml")
+}
+
+func (s *staticRepo) fetchChart(ctx context.Context, chart, version string) ([]byte, error) {
+	s.mu.Lock()
+	u, err := indexChartURL(s.index, s.url(), chart, version)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return downloadChart(ctx, s.repo, u)
+}
+
+func (s *staticRepo) openChart(ctx context.Context, chart, version string) (io.ReadCloser, int64, error) {
+	s.mu.Lock()
+	u, err := indexChartURL(s.index, s.url(), chart, version)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, 0, err
+	}
+	return openURL(ctx, s.repo, u)
+}
+
+func (s *staticRepo) fetchProvenance(ctx context.Context, chart, version string) ([]byte, error) {
+	s.mu.Lock()
+	u, err := indexChartURL(s.index, s.url(), chart, version)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return downloadProvenance(ctx, s.repo, u)
+}
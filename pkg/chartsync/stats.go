@@ -0,0 +1,156 @@
+package chartsync
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"text/tabwriter"
+)
+
+// repoStats are the totals of one repository.
+type repoStats struct {
+	charts   int
+	versions int
+	size     int64
+	unsized  int
+	largest  []chartTotal
+	versList []ChartVersion
+}
+
+type chartTotal struct {
+	name     string
+	versions int
+	size     int64
+}
+
+// collectStats sums up data, sizes come from the source's Content-Length
+// when it can tell them, up to parallel requests at a time.
+func collectStats(ctx context.Context, src chartSource, data ChartData, parallel int) repoStats {
+	st := repoStats{charts: len(data)}
+	totals := make(map[string]*chartTotal)
+	for chart, versions := range data {
+		totals[chart] = &chartTotal{name: chart, versions: len(versions)}
+		st.versions += len(versions)
+		st.versList = append(st.versList, versions...)
+	}
+
+	sizer, ok := src.(chartSizer)
+	if !ok {
+		st.unsized = st.versions
+	} else {
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		sem := make(chan struct{}, max(parallel, 1))
+		for _, cv := range st.versList {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sem <- struct{}{}
+				defer func() { <-sem }()
+				size, err := sizer.chartSize(ctx, cv.Name, cv.Version)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					st.unsized++
+					return
+				}
+				st.size += size
+				totals[cv.Name].size += size
+			}()
+		}
+		wg.Wait()
+	}
+
+	for _, t := range totals {
+		st.largest = append(st.largest, *t)
+	}
+	sort.Slice(st.largest, func(i, j int) bool {
+		if st.largest[i].size != st.largest[j].size {
+			return st.largest[i].size > st.largest[j].size
+		}
+		return st.largest[i].name < st.largest[j].name
+	})
+	sort.Slice(st.versList, func(i, j int) bool {
+		a, b := st.versList[i], st.versList[j]
+		if !a.Created.Equal(b.Created) {
+			return a.Created.After(b.Created)
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return newerVersion(a.Version, b.Version)
+	})
+	return st
+}
+
+func (st repoStats) print(name string, top int) {
+	outln(name)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  Charts\t", st.charts)
+	fmt.Fprintln(tw, "  Versions\t", st.versions)
+	size := formatSize(st.size)
+	if st.unsized > 0 {
+		size += fmt.Sprintf(" (%d versions of unknown size)", st.unsized)
+	}
+	fmt.Fprintln(tw, "  Total size\t", size)
+	tw.Flush()
+
+	if st.versions == 0 {
+		return
+	}
+	outln("  Largest charts:")
+	tw = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, t := range st.largest[:min(top, len(st.largest))] {
+		fmt.Fprintf(tw, "    %s\t%s\t%d versions\n", t.name, formatSize(t.size), t.versions)
+	}
+	tw.Flush()
+
+	n := min(top, len(st.versList))
+	outln("  Newest versions:")
+	printVersions(st.versList[:n])
+	outln("  Oldest versions:")
+	oldest := make([]ChartVersion, n)
+	for i := range oldest {
+		oldest[i] = st.versList[len(st.versList)-1-i]
+	}
+	printVersions(oldest)
+}
+
+func printVersions(versions []ChartVersion) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, cv := range versions {
+		created := "unknown"
+		if !cv.Created.IsZero() {
+			created = cv.Created.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(tw, "    %s-%s\t%s\n", cv.Name, cv.Version, created)
+	}
+	tw.Flush()
+}
+
+// runStats reports chart and version counts, sizes and the largest, newest
+// and oldest charts of a repository, e.g. before planning a migration.
+func runStats(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	sf := addSourceFlags(fs)
+	tenants := fs.String("tenants", "", "comma separated org/repo paths of a multitenant chartmuseum, reported one by one")
+	top := fs.Int("top", 5, "number of largest, newest and oldest charts listed")
+	concurrency := fs.Int("j", 8, "number of size requests sent in parallel")
+	fs.Parse(args)
+	cfg := sf.config(fs)
+
+	list := tenantList(*tenants, nil)
+	if len(list) == 0 {
+		list = []string{""}
+	}
+	for i, tenant := range list {
+		src, data := openSource(ctx, cfg, tenant)
+		if i > 0 {
+			outln()
+		}
+		collectStats(ctx, src, data, *concurrency).print(fmt.Sprint(src), *top)
+	}
+}
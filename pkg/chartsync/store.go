@@ -0,0 +1,266 @@
+package chartsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var errNotExist = errors.New("object does not exist")
+
+// blobStore is flat storage holding a helm repository, chart tarballs next
+// to an index.yaml. Names are relative to the repository root and read
+// returns errNotExist for missing objects.
+type blobStore interface {
+	ping(ctx context.Context) error
+	list(ctx context.Context) ([]string, error)
+	read(ctx context.Context, name string) ([]byte, error)
+	write(ctx context.Context, name string, r io.Reader, size int64) error
+	String() string
+}
+
+type indexFile struct {
+	APIVersion string    `yaml:"apiVersion"`
+	Entries    ChartData `yaml:"entries"`
+	Generated  time.Time `yaml:"generated"`
+}
+
+// storeRepo serves a blobStore as source and destination. The index is
+// read from index.yaml, or built from the tarballs when there is none, and
+// written back by flush after charts were pushed.
+type storeRepo struct {
+	store   blobStore
+	baseURL string
+	// client downloads the charts the index links to elsewhere.
+	client *http.Client
+
+	mu      sync.Mutex
+	index   ChartData
+	version string
+	dirty   bool
+	pushed  []string
+}
+
+func newStoreRepo(store blobStore, client *http.Client) *storeRepo {
+	return &storeRepo{store: store, client: client}
+}
+
+func (s *storeRepo) String() string {
+	return s.store.String()
+}
+
+func (s *storeRepo) ping(ctx context.Context) error {
+	return s.store.ping(ctx)
+}
+
+func (s *storeRepo) listCharts(ctx context.Context) (ChartData, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index != nil {
+		return s.index, nil
+	}
+
+	data, err := s.store.read(ctx, "index.yaml")
+	if err == nil {
+		var idx indexFile
+		if err := yaml.Unmarshal(data, &idx); err != nil {
+			return nil, fmt.Errorf("error decoding index.yaml: %w", err)
+		}
+		if idx.Entries == nil {
+			idx.Entries = make(ChartData)
+		}
+		s.index = idx.Entries
+		s.version = chartDigest(data)
+		return s.index, nil
+	}
+	if !errors.Is(err, errNotExist) {
+		return nil, err
+	}
+
+	s.index, err = s.scan(ctx)
+	return s.index, err
+}
+
+// scan indexes every tarball in the store.
+func (s *storeRepo) scan(ctx context.Context) (ChartData, error) {
+	names, err := s.store.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	index := make(ChartData)
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".tgz") {
+			continue
+		}
+		data, err := s.store.read(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		cv, err := chartMetadata(data)
+		if err != nil {
+			logf("Skipping %s %v\n", name, err)
+			continue
+		}
+		cv.URLs = []string{name}
+		index[cv.Name] = append(index[cv.Name], cv)
+	}
+	return index, nil
+}
+
+func (s *storeRepo) fetchChart(ctx context.Context, chart, version string) ([]byte, error) {
+	name := chart + "-" + version + ".tgz"
+	s.mu.Lock()
+	for _, cv := range s.index[chart] {
+		if cv.Version == version && len(cv.URLs) > 0 {
+			name = cv.URLs[0]
+		}
+	}
+	s.mu.Unlock()
+	if strings.Contains(name, "://") {
+		base := path.Base(name)
+		if unescaped, err := url.PathUnescape(base); err == nil {
+			base = unescaped
+		}
+		if strings.Contains(base, "/") || base == ".." {
+			return nil, fmt.Errorf("invalid chart url %s", name)
+		}
+		data, err := s.store.read(ctx, base)
+		if err == nil || !errors.Is(err, errNotExist) {
+			return data, err
+		}
+		return downloadChart(ctx, repo{client: s.client}, name)
+	}
+	// The urls come from the index, those that would leave the store are
+	// refused.
+	clean := path.Clean(strings.TrimPrefix(name, "/"))
+	if clean == ".." || strings.HasPrefix(clean, "../") || path.IsAbs(clean) {
+		return nil, fmt.Errorf("invalid chart url %s", name)
+	}
+	return s.store.read(ctx, clean)
+}
+
+// fetchProvenance reads the .prov file stored next to a tarball.
+func (s *storeRepo) fetchProvenance(ctx context.Context, chart, version string) ([]byte, error) {
+	data, err := s.store.read(ctx, chart+"-"+version+".tgz.prov")
+	if errors.Is(err, errNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *storeRepo) pushChart(ctx context.Context, chart, version string, data []byte) error {
+	return s.pushChartStream(ctx, chart, version, bytes.NewReader(data), int64(len(data)))
+}
+
+// pushChartStream reads the chart twice, for its metadata and for the
+// upload, charts that can't be rewound are spooled first.
+func (s *storeRepo) pushChartStream(ctx context.Context, chart, version string, body io.Reader, size int64) error {
+	rs, ok := body.(io.ReadSeeker)
+	if !ok || size < 0 {
+		sp, err := newSpool(body)
+		if err != nil {
+			return err
+		}
+		defer sp.close()
+		rs, size = sp.reader(), sp.size
+	}
+	cv, err := readChartMetadata(rs)
+	if err != nil {
+		return err
+	}
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if strings.ContainsAny(cv.Name+cv.Version, "/\\") || strings.HasPrefix(cv.Name, ".") {
+		return fmt.Errorf("invalid chart file name %s-%s.tgz", cv.Name, cv.Version)
+	}
+	name := cv.Name + "-" + cv.Version + ".tgz"
+	if err := s.store.write(ctx, name, rs, size); err != nil {
+		return err
+	}
+	cv.URLs = []string{name}
+	if s.baseURL != "" {
+		cv.URLs = []string{s.baseURL + "/" + escapePath(name)}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.index == nil {
+		s.index = make(ChartData)
+	}
+	versions := s.index[cv.Name][:0:0]
+	for _, v := range s.index[cv.Name] {
+		if v.Version != cv.Version {
+			versions = append(versions, v)
+		}
+	}
+	s.index[cv.Name] = append(versions, cv)
+	s.dirty = true
+	s.version = ""
+	s.pushed = append(s.pushed, cv.Name+"-"+cv.Version)
+	return nil
+}
+
+// indexVersion is the sha256 of the index.yaml read, empty when the index
+// was built from the tarballs or changed since.
+func (s *storeRepo) indexVersion() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.version
+}
+
+// refresh drops the cached index, so it is read again by the next
+// listCharts. An index with unflushed changes is kept.
+func (s *storeRepo) refresh() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		s.index = nil
+	}
+}
+
+// flush writes index.yaml if charts were pushed since the last flush.
+// Stores that can commit (git) get the pushed chart versions, stores
+// holding local state are closed.
+func (s *storeRepo) flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.store.(interface{ close() error }); ok {
+		defer c.close()
+	}
+	if !s.dirty {
+		return nil
+	}
+	for _, versions := range s.index {
+		sortVersions(versions)
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(indexFile{APIVersion: "v1", Entries: s.index, Generated: time.Now().UTC()}); err != nil {
+		return err
+	}
+	if err := s.store.write(ctx, "index.yaml", &buf, int64(buf.Len())); err != nil {
+		return err
+	}
+	if c, ok := s.store.(interface {
+		commit(ctx context.Context, pushed []string) error
+	}); ok {
+		if err := c.commit(ctx, s.pushed); err != nil {
+			return err
+		}
+	}
+	s.dirty = false
+	s.pushed = nil
+	return nil
+}
@@ -0,0 +1,46 @@
+package chartsync
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStoreRepoFetchChartStaysInStore(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "secret.tgz"), []byte("outside"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	repoDir := filepath.Join(dir, "repo")
+	if err := os.MkdirAll(filepath.Join(repoDir, "charts"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repoDir, "charts", "web-1.0.0.tgz"), []byte("chart"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		url string
+		ok  bool
+	}{
+		{"charts/web-1.0.0.tgz", true},
+		{"/charts/web-1.0.0.tgz", true},
+		{"charts/../charts/web-1.0.0.tgz", true},
+		{"../secret.tgz", false},
+		{"/../secret.tgz", false},
+		{"charts/../../secret.tgz", false},
+		{"//" + filepath.ToSlash(filepath.Join(dir, "secret.tgz")), false},
+		{"https://charts.example.com/..%2Fsecret.tgz", false},
+	} {
+		s := newStoreRepo(&dirStore{dir: repoDir}, nil)
+		s.index = ChartData{"web": {{Name: "web", Version: "1.0.0", URLs: []string{tt.url}}}}
+		data, err := s.fetchChart(context.Background(), "web", "1.0.0")
+		if tt.ok && (err != nil || string(data) != "chart") {
+			t.Errorf("fetchChart(%q) = %q, %v, want the chart", tt.url, data, err)
+		}
+		if !tt.ok && err == nil {
+			t.Errorf("fetchChart(%q) = %q, want an error", tt.url, data)
+		}
+	}
+}
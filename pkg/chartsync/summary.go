@@ -0,0 +1,337 @@
+package chartsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// runSummary collects what a run did across all of its jobs, it is printed
+// as a table once the run is over.
+type runSummary struct {
+	mu    sync.Mutex
+	start time.Time
+	// requestID is the id sent with the requests of the run.
+	requestID string
+	examined  int
+	// jobsFailed are the jobs whose charts couldn't be fetched.
+	jobsFailed int
+	synced     int
+	skipped    int
+	bytes      int64
+	// cached and cachedBytes are the charts read from -chart-cache
+	// instead of the source.
+	cached      int
+	cachedBytes int64
+	failures    []syncFailure
+	conflicts   []conflict
+	// denials are the versions -policy or the approval webhook kept out
+	// of their destination, they aren't failures.
+	denials []denial
+	// notes are the release notes of the synced versions, with
+	// -release-notes.
+	notes []releaseNote
+	// spokes are the counts by spoke of a hub and spoke config.
+	spokes   *topologyReport
+	reporter Reporter
+}
+
+type syncFailure struct {
+	item syncItem
+	err  error
+}
+
+type denial struct {
+	Chart       string `json:"chart"`
+	Version     string `json:"version"`
+	Destination string `json:"destination"`
+	Reason      string `json:"reason"`
+}
+
+func newRunSummary() *runSummary {
+	return &runSummary{start: time.Now(), requestID: currentRequestID()}
+}
+
+func (s *runSummary) examine(charts int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.examined += charts
+}
+
+func (s *runSummary) failJobs(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobsFailed += n
+}
+
+// conflict adds the conflicts found against destination.
+func (s *runSummary) conflict(destination string, conflicts []conflict) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range conflicts {
+		c.Destination = destination
+		s.conflicts = append(s.conflicts, c)
+	}
+}
+
+func (s *runSummary) sync(item syncItem, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.synced++
+	s.bytes += size
+	if s.reporter != nil {
+		s.reporter.Synced(Version{item.Chart, item.Version}, size)
+	}
+}
+
+// cacheHit counts a chart of size read from -chart-cache.
+func (s *runSummary) cacheHit(size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cached++
+	s.cachedBytes += size
+}
+
+func (s *runSummary) skip(item syncItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipped++
+	if s.reporter != nil {
+		s.reporter.Skipped(Version{item.Chart, item.Version})
+	}
+}
+
+func (s *runSummary) fail(item syncItem, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = append(s.failures, syncFailure{item, err})
+	if s.reporter != nil {
+		s.reporter.Failed(Version{item.Chart, item.Version}, err)
+	}
+}
+
+// deny records that item was denied on destination for reason.
+func (s *runSummary) deny(item syncItem, destination, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.denials = append(s.denials, denial{item.Chart, item.Version, destination, reason})
+}
+
+// note adds the release notes of a version, once for all destinations.
+func (s *runSummary) note(n releaseNote) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.notes {
+		if other.Chart == n.Chart && other.Version == n.Version {
+			return
+		}
+	}
+	s.notes = append(s.notes, n)
+}
+
+func (s *runSummary) print(w io.Writer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elapsed := time.Since(s.start)
+	throughput := int64(0)
+	if elapsed > 0 {
+		throughput = int64(float64(s.bytes) / elapsed.Seconds())
+	}
+
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	if s.requestID != "" {
+		fmt.Fprintln(tw, "Request ID\t", s.requestID)
+	}
+	fmt.Fprintln(tw, "Charts examined\t", s.examined)
+	fmt.Fprintln(tw, "Versions synced\t", paintCount(green, s.synced))
+	fmt.Fprintln(tw, "Versions skipped\t", paintCount(yellow, s.skipped))
+	fmt.Fprintln(tw, "Versions failed\t", paintCount(red, len(s.failures)))
+	if len(s.denials) > 0 {
+		fmt.Fprintln(tw, "Versions denied\t", paintCount(red, len(s.denials)))
+	}
+	if len(s.conflicts) > 0 {
+		fmt.Fprintln(tw, "Conflicts\t", len(s.conflicts))
+	}
+	if s.jobsFailed > 0 {
+		fmt.Fprintln(tw, "Jobs failed\t", paintCount(red, s.jobsFailed))
+	}
+	fmt.Fprintln(tw, "Transferred\t", formatSize(s.bytes))
+	if s.cached > 0 {
+		fmt.Fprintf(tw, "From chart cache\t %d (%s)\n", s.cached, formatSize(s.cachedBytes))
+	}
+	fmt.Fprintln(tw, "Elapsed\t", elapsed.Round(time.Millisecond))
+	fmt.Fprintln(tw, "Throughput\t", formatSize(throughput)+"/s")
+	tw.Flush()
+
+	s.spokes.print(w)
+	sortConflicts(s.conflicts)
+	printConflicts(w, s.conflicts)
+	if len(s.denials) > 0 {
+		sort.Slice(s.denials, func(i, j int) bool {
+			a, b := s.denials[i], s.denials[j]
+			if a.Chart != b.Chart {
+				return a.Chart < b.Chart
+			}
+			return newerVersion(a.Version, b.Version)
+		})
+		fmt.Fprintln(w, "\nDenied versions:")
+		tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		for _, d := range s.denials {
+			fmt.Fprintf(tw, "  %s\t%s\t%s\n", paint(red, d.Chart+"-"+d.Version), redact(d.Destination), d.Reason)
+		}
+		tw.Flush()
+	}
+	printNotes(w, s.notes)
+	if len(s.failures) == 0 {
+		return
+	}
+	sort.Slice(s.failures, func(i, j int) bool {
+		a, b := s.failures[i].item, s.failures[j].item
+		if a.Chart != b.Chart {
+			return a.Chart < b.Chart
+		}
+		return newerVersion(a.Version, b.Version)
+	})
+	fmt.Fprintln(w, "\nFailed versions:")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, f := range s.failures {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", paint(red, f.item.Chart+"-"+f.item.Version), ErrorCode(f.err), redact(f.err.Error()))
+	}
+	tw.Flush()
+}
+
+// failureRate is the share of the versions a run tried to transfer that
+// failed, 0 for the summary of no run.
+func (s *runSummary) failureRate() float64 {
+	if s == nil {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempted := s.synced + len(s.failures)
+	if attempted == 0 {
+		return 0
+	}
+	return float64(len(s.failures)) / float64(attempted)
+}
+
+// parseRate reads rates like 5% or 0.05.
+func parseRate(rate string) (float64, error) {
+	s := strings.TrimSpace(rate)
+	percent := strings.HasSuffix(s, "%")
+	r, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", rate)
+	}
+	if percent {
+		r /= 100
+	}
+	if r < 0 || r > 1 {
+		return 0, fmt.Errorf("rate %q isn't between 0 and 100%%", rate)
+	}
+	return r, nil
+}
+
+// result is the summary for library callers.
+func (s *runSummary) result() *Summary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := &Summary{
+		Examined: s.examined,
+		Synced:   s.synced,
+		Skipped:  s.skipped,
+		Bytes:    s.bytes,
+		Elapsed:  time.Since(s.start),
+	}
+	for _, f := range s.failures {
+		r.Errors = append(r.Errors, &Error{Version{f.item.Chart, f.item.Version}, ErrorKind(f.err), ErrorCode(f.err), f.err})
+	}
+	return r
+}
+
+type summaryJSON struct {
+	RequestID      string        `json:"request_id,omitempty"`
+	Examined       int           `json:"examined"`
+	JobsFailed     int           `json:"jobs_failed,omitempty"`
+	Synced         int           `json:"synced"`
+	Skipped        int           `json:"skipped"`
+	Failed         int           `json:"failed"`
+	Bytes          int64         `json:"bytes"`
+	Cached         int           `json:"cached,omitempty"`
+	CachedBytes    int64         `json:"cached_bytes,omitempty"`
+	ElapsedSeconds float64       `json:"elapsed_seconds"`
+	Errors         []failureJSON `json:"errors"`
+	Conflicts      []conflict    `json:"conflicts,omitempty"`
+	Denied         []denial      `json:"denied,omitempty"`
+	ReleaseNotes   []releaseNote `json:"release_notes,omitempty"`
+	Spokes         []spokeReport `json:"spokes,omitempty"`
+}
+
+type failureJSON struct {
+	Chart   string `json:"chart"`
+	Version string `json:"version"`
+	Kind    string `json:"kind,omitempty"`
+	Code    string `json:"code"`
+	Error   string `json:"error"`
+}
+
+// writeJSON writes the summary to path, for -summary-json.
+func (s *runSummary) writeJSON(path string) error {
+	data, err := json.MarshalIndent(s.json(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+func (s *runSummary) json() summaryJSON {
+	r := s.result()
+	out := summaryJSON{
+		RequestID:      s.requestID,
+		Examined:       r.Examined,
+		JobsFailed:     s.jobsFailed,
+		Synced:         r.Synced,
+		Skipped:        r.Skipped,
+		Failed:         len(r.Errors),
+		Bytes:          r.Bytes,
+		ElapsedSeconds: r.Elapsed.Seconds(),
+		Errors:         []failureJSON{},
+		Spokes:         s.spokes.json(),
+	}
+	for _, e := range r.Errors {
+		out.Errors = append(out.Errors, failureJSON{e.Chart, e.Version.Version, e.Kind, e.Code, redact(e.Err.Error())})
+	}
+	s.mu.Lock()
+	out.Cached, out.CachedBytes = s.cached, s.cachedBytes
+	for _, c := range s.conflicts {
+		c.Destination = redact(c.Destination)
+		out.Conflicts = append(out.Conflicts, c)
+	}
+	for _, d := range s.denials {
+		d.Destination = redact(d.Destination)
+		out.Denied = append(out.Denied, d)
+	}
+	out.ReleaseNotes = s.notes
+	s.mu.Unlock()
+	return out
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,781 @@
+package chartsync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// syncItem is a chart version to transfer, URL is only set for charts
+// that live outside the source, such as dependencies from other repos.
+type syncItem struct {
+	Chart   string
+	Version string
+	URL     string
+}
+
+type chartSource interface {
+	ping(ctx context.Context) error
+	listCharts(ctx context.Context) (ChartData, error)
+	fetchChart(ctx context.Context, chart, version string) ([]byte, error)
+	String() string
+}
+
+type chartDestination interface {
+	ping(ctx context.Context) error
+	listCharts(ctx context.Context) (ChartData, error)
+	pushChart(ctx context.Context, chart, version string, data []byte) error
+	String() string
+}
+
+// chartStreamer is a source that hands out the download body, so charts
+// can be piped into the upload without holding them in memory.
+type chartStreamer interface {
+	openChart(ctx context.Context, chart, version string) (io.ReadCloser, int64, error)
+}
+
+// chartStreamPusher is a destination uploading straight from a reader,
+// size is -1 when the length isn't known up front.
+type chartStreamPusher interface {
+	pushChartStream(ctx context.Context, chart, version string, body io.Reader, size int64) error
+}
+
+// chartChecker is a destination that can tell whether it has a version,
+// charts that appeared there since the diff aren't transferred again.
+type chartChecker interface {
+	hasChart(ctx context.Context, chart, version string) (bool, error)
+}
+
+type ChartData map[string][]ChartVersion
+
+type repo struct {
+	server  string
+	tenant  string
+	auth    credentials
+	headers http.Header
+	// force overwrites existing versions on upload, for -force.
+	force bool
+	// client sends the requests, httpClient when nil.
+	client *http.Client
+}
+
+func (r repo) url() string {
+	if r.tenant == "" {
+		return r.server
+	}
+	return r.server + "/" + r.tenant
+}
+
+func (r repo) apiURL() string {
+	if r.tenant == "" {
+		return r.server + "/api/charts"
+	}
+	return r.server + "/api/" + r.tenant + "/charts"
+}
+
+func (r repo) String() string {
+	return r.url()
+}
+
+// base gives the plain repo of the http backends that embed it.
+func (r repo) base() repo {
+	return r
+}
+
+// do sends req through the client of the repo.
+func (r repo) do(req *http.Request) (*http.Response, error) {
+	return clientOr(r.client).Do(req)
+}
+
+// newRequest builds a request carrying the repo's credentials, as long as
+// the target url lives on the repo's server. With sigv4 auth it is signed,
+// headers set afterwards aren't.
+func (r repo) newRequest(ctx context.Context, method, u string, body io.Reader) (req *http.Request, err error) {
+	onServer := sameServer(u, r.server)
+	var hash string
+	switch {
+	case !onServer || r.auth.Type == "":
+	case r.auth.Type == "sigv4":
+		if body, hash, err = payloadHash(body); err != nil {
+			return nil, err
+		}
+		if b, ok := body.(spoolBody); ok {
+			defer func() {
+				if err != nil {
+					b.Close()
+				}
+			}()
+		}
+	default:
+		return nil, fmt.Errorf("unsupported auth type %q", r.auth.Type)
+	}
+	req, err = http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if b, ok := body.(spoolBody); ok {
+		req.ContentLength = b.Size()
+	}
+	if !onServer {
+		return req, nil
+	}
+	if err := useTLS(req.URL.Host, r.auth); err != nil {
+		return nil, err
+	}
+	if r.auth.Username != "" {
+		req.SetBasicAuth(r.auth.Username, r.auth.Password)
+	} else if r.auth.OIDCTokenURL != "" {
+		token, err := oidcToken(r.auth, r.client)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if r.auth.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.auth.Token)
+	}
+	for k, v := range r.headers {
+		req.Header[k] = v
+	}
+	for k, v := range r.auth.Headers {
+		req.Header.Set(k, v)
+	}
+	if r.auth.Type == "sigv4" {
+		if err := signV4(ctx, req, hash, r.auth, r.client); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
+
+// sameServer tells if u lives on server: the same scheme, host and port,
+// below its path. A prefix of the url isn't enough, https://host.evil.net
+// or https://host@evil would get the credentials of https://host.
+func sameServer(u, server string) bool {
+	a, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	b, err := url.Parse(server)
+	if err != nil {
+		return false
+	}
+	if !strings.EqualFold(a.Scheme, b.Scheme) || !strings.EqualFold(a.Hostname(), b.Hostname()) || urlPort(a) != urlPort(b) {
+		return false
+	}
+	base := strings.TrimSuffix(b.Path, "/")
+	return a.Path == base || strings.HasPrefix(a.Path, base+"/")
+}
+
+// urlPort is the port of u, the default one of its scheme if it has none.
+func urlPort(u *url.URL) string {
+	if p := u.Port(); p != "" {
+		return p
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http":
+		return "80"
+	case "https":
+		return "443"
+	}
+	return ""
+}
+
+func (r repo) get(ctx context.Context, u string) (*http.Response, error) {
+	req, err := r.newRequest(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	return r.do(req)
+}
+
+func (r repo) ping(ctx context.Context) error {
+	return checkInfoEndpoint(ctx, r)
+}
+
+func (r repo) listCharts(ctx context.Context) (ChartData, error) {
+	return fetchCharts(ctx, r)
+}
+
+func (r repo) fetchChart(ctx context.Context, chart, version string) ([]byte, error) {
+	return downloadChart(ctx, r, downloadURL(r.url(), chart, version))
+}
+
+func (r repo) openChart(ctx context.Context, chart, version string) (io.ReadCloser, int64, error) {
+	return openURL(ctx, r, downloadURL(r.url(), chart, version))
+}
+
+func (r repo) fetchProvenance(ctx context.Context, chart, version string) ([]byte, error) {
+	return downloadProvenance(ctx, r, downloadURL(r.url(), chart, version))
+}
+
+func (r repo) pushChart(ctx context.Context, chart, version string, data []byte) error {
+	return uploadChart(ctx, r, bytes.NewReader(data), int64(len(data)))
+}
+
+func (r repo) pushChartStream(ctx context.Context, chart, version string, body io.Reader, size int64) error {
+	return uploadChart(ctx, r, body, size)
+}
+
+func (r repo) hasChart(ctx context.Context, chart, version string) (bool, error) {
+	return headExists(ctx, r, r.apiURL()+"/"+escapePath(chart)+"/"+escapePath(version))
+}
+
+// headExists asks the server whether u exists with a HEAD request.
+func headExists(ctx context.Context, r repo, u string) (bool, error) {
+	req, err := r.newRequest(ctx, "HEAD", u, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := r.do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, unexpectedStatus(resp.StatusCode)
+}
+
+func fetchCharts(ctx context.Context, r repo) (ChartData, error) {
+	data, err := streamIndex(ctx, r, r.apiURL())
+	if err != nil {
+		return nil, err
+	}
+	rememberListing(r.url(), data)
+	return data, nil
+}
+
+// indexVersion identifies the chart list last fetched, empty if there is
+// none to compare.
+func (r repo) indexVersion() string {
+	return indexes.digest(r.apiURL())
+}
+
+func compareCharts(data1, data2 ChartData) map[string][]string {
+	diff := make(map[string][]string)
+	for chart, versions1 := range data1 {
+		versionSet2 := make(map[string]struct{})
+		if versions2, exists := data2[chart]; exists {
+			for _, v := range versions2 {
+				versionSet2[v.Version] = struct{}{}
+			}
+		}
+		for _, v := range versions1 {
+			if _, found := versionSet2[v.Version]; !found {
+				diff[chart] = append(diff[chart], v.Version)
+			}
+		}
+	}
+	return diff
+}
+
+// changedCharts returns the versions both sides have with different
+// digests, versions without a digest on either side count as changed.
+func changedCharts(data1, data2 ChartData) map[string][]string {
+	changed := make(map[string][]string)
+	for chart, versions1 := range data1 {
+		digests := make(map[string]string)
+		for _, v := range data2[chart] {
+			digests[v.Version] = strings.TrimPrefix(v.Digest, "sha256:")
+		}
+		for _, v := range versions1 {
+			d2, found := digests[v.Version]
+			if found && (d2 == "" || d2 != strings.TrimPrefix(v.Digest, "sha256:")) {
+				changed[chart] = append(changed[chart], v.Version)
+			}
+		}
+	}
+	return changed
+}
+
+func chartURL(server, chart, version string) string {
+	return server + "/charts/" + escapePath(chart+"-"+version+".tgz")
+}
+
+func downloadChart(ctx context.Context, r repo, u string) ([]byte, error) {
+	body, _, err := openURL(ctx, r, u)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return io.ReadAll(body)
+}
+
+// openURL starts a download and returns the body with its length, -1 if
+// the server didn't send one. Broken downloads are resumed.
+func openURL(ctx context.Context, r repo, u string) (io.ReadCloser, int64, error) {
+	resp, err := r.get(ctx, u)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		return nil, 0, unexpectedStatus(resp.StatusCode)
+	}
+	return newResumableBody(ctx, r, u, resp), resp.ContentLength, nil
+}
+
+// downloadProvenance fetches the .prov file next to a chart, unsigned
+// charts give nil.
+func downloadProvenance(ctx context.Context, r repo, chartURL string) ([]byte, error) {
+	resp, err := r.get(ctx, chartURL+".prov")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != 200 {
+		return nil, unexpectedStatus(resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func uploadChart(ctx context.Context, r repo, body io.Reader, size int64) error {
+	u := r.apiURL()
+	if r.force {
+		u += "?force"
+	}
+	req, err := r.newRequest(ctx, "POST", u, body)
+	if err != nil {
+		return err
+	}
+	if size > 0 {
+		req.ContentLength = size
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	resp, err := r.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 201 {
+		return unexpectedStatus(resp.StatusCode)
+	}
+	return nil
+}
+
+// multipartBody streams r as the single file of a multipart form. The
+// form's length is known whenever the file's size is, -1 otherwise.
+func multipartBody(field, filename string, r io.Reader, size int64) (io.Reader, string, int64) {
+	var head bytes.Buffer
+	w := multipart.NewWriter(&head)
+	w.CreateFormFile(field, filename)
+	tail := "\r\n--" + w.Boundary() + "--\r\n"
+	length := int64(-1)
+	if size >= 0 {
+		length = int64(head.Len()) + size + int64(len(tail))
+	}
+	return io.MultiReader(&head, r, strings.NewReader(tail)), w.FormDataContentType(), length
+}
+
+// syncPlan is the diff of a source against a destination, the charts in
+// queue are transferred by run.
+type syncPlan struct {
+	source      chartSource
+	destination chartDestination
+	opts        syncOptions
+	sourceData  ChartData
+	destData    ChartData
+	diff        map[string][]string
+	queue       []syncItem
+	// conflicts are the versions both have with different digests.
+	conflicts []conflict
+	// unannotated are the versions left out for missing the required
+	// annotations.
+	unannotated []syncItem
+	progress    Progress
+	// hold stops transfers that haven't started yet when it returns true,
+	// their items are collected in held.
+	hold func() bool
+	mu   sync.Mutex
+	held []syncItem
+}
+
+func planSync(ctx context.Context, server1 chartSource, server2 chartDestination, opts syncOptions) (*syncPlan, error) {
+	data1, err1 := server1.listCharts(ctx)
+	data2, err2 := server2.listCharts(ctx)
+	if err1 != nil {
+		err1 = sourceError(err1)
+	}
+	if err2 != nil {
+		err2 = destError(err2)
+	}
+	if err1 != nil || err2 != nil {
+		return nil, errors.Join(err1, err2)
+	}
+	conflicts := findConflicts(applyPolicy(data1, opts), data2)
+	held := heldBack(data1, data2, opts)
+	if len(held) > 0 {
+		logf("Holding back %d versions of %s without the annotations %s\n", len(held), server1, formatAnnotations(opts.RequireAnnotations))
+	}
+
+	// Indexes that didn't change since the last sync that found nothing
+	// to transfer aren't diffed again.
+	var state, key string
+	v1, ok1 := server1.(interface{ indexVersion() string })
+	v2, ok2 := server2.(interface{ indexVersion() string })
+	if ok1 && ok2 && v1.indexVersion() != "" && v2.indexVersion() != "" {
+		state = v1.indexVersion() + " " + v2.indexVersion()
+		key = cacheKey(fmt.Sprint(server1, server2, opts))
+		if indexes.synced(key) == state {
+			return &syncPlan{source: server1, destination: server2, opts: opts, sourceData: data1, destData: data2, conflicts: conflicts, unannotated: held}, nil
+		}
+	}
+
+	diff := compareCharts(applyPolicy(data1, opts), data2)
+	if opts.Force {
+		for chart, versions := range changedCharts(applyPolicy(data1, opts), data2) {
+			diff[chart] = append(diff[chart], versions...)
+		}
+	}
+	if len(diff) == 0 && state != "" {
+		indexes.markSynced(key, state)
+	}
+
+	queue := sortedItems(diff)
+	return &syncPlan{
+		source:      server1,
+		destination: server2,
+		opts:        opts,
+		sourceData:  data1,
+		destData:    data2,
+		diff:        diff,
+		queue:       queue,
+		conflicts:   conflicts,
+		unannotated: held,
+	}, nil
+}
+
+func (p *syncPlan) run(ctx context.Context, summary *runSummary) {
+	server1, server2, opts := p.source, p.destination, p.opts
+	data1, data2, diff, queue := p.sourceData, p.destData, p.diff, p.queue
+
+	var deps *depResolver
+	if opts.Deps {
+		deps = newDepResolver(server1, annotated(data1, opts.RequireAnnotations), data2, diff)
+	}
+
+	workers := opts.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	adaptive := newAdaptiveLimit(workers, opts.MaxConcurrency)
+	if adaptive != nil {
+		workers = opts.MaxConcurrency
+	}
+
+	// Without dependency resolution, a policy on their contents or a
+	// -chart-cache to keep them in charts don't need to be looked at, they
+	// are piped from the download into the upload when both ends can.
+	policy := opts.policy
+	contents := policy != nil && policy.contents
+	src, canOpen := server1.(chartStreamer)
+	dst, canStream := server2.(chartStreamPusher)
+	stream := canOpen && canStream && deps == nil && !contents && charts == nil
+	checker, _ := server2.(chartChecker)
+
+	var sizes []int64
+	if sizer, ok := server1.(chartSizer); ok {
+		sizes = chartSizes(ctx, sizer, queue, workers)
+	}
+	_, toStore := server2.(*storeRepo)
+	if err := checkDiskSpace(server2, queue, sizes, workers, !stream || toStore); err != nil {
+		logln("Error checking free disk space:", err)
+		return
+	}
+
+	progressSizes := sizes
+	if progressSizes == nil {
+		progressSizes = make([]int64, len(queue))
+		for i := range progressSizes {
+			progressSizes[i] = -1
+		}
+	}
+	progress := newSyncProgress("Syncing Charts", progressSizes, p.progress)
+	queuedSize := make(map[syncItem]int64, len(queue))
+	for i, item := range queue {
+		queuedSize[item] = progressSizes[i]
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, workers)
+	var process func(item syncItem)
+	// transfer syncs item within ctx, which is done after -chart-timeout.
+	transfer := func(ctx context.Context, item syncItem, c *chartProgress) {
+		defer c.finish()
+		started := time.Now()
+		done := func(size int64) {
+			adaptive.observe(started, size, nil)
+			summary.sync(item, size)
+		}
+		// notes adds the release notes of item to the summary, sp is the
+		// chart unless it was streamed.
+		notes := func(sp *spool) {
+			if !opts.ReleaseNotes {
+				return
+			}
+			note, err := releaseNotes(ctx, server1, data1, item, sp)
+			if err != nil {
+				logf("Failed to read the release notes of %s-%s %v\n", item.Chart, item.Version, err)
+			}
+			if note != nil {
+				summary.note(*note)
+			}
+		}
+		failed := func(err error) {
+			if cause := context.Cause(ctx); cause != nil && ErrorCode(cause) == "E_CHART_TIMEOUT" {
+				err = cause
+			}
+			adaptive.observe(started, 0, err)
+			summary.fail(item, err)
+		}
+		// allowed asks the policy about item, with the files of the chart
+		// when it decides on its contents.
+		allowed := func(files map[string]string) bool {
+			input := newPolicyInput(server1, server2, data1, item)
+			input.Files = files
+			reason, err := policy.decide(ctx, input)
+			if err != nil {
+				failed(codeError{"E_POLICY_FAILED", fmt.Errorf("evaluating policy: %w", err)})
+				return false
+			}
+			if reason != "" {
+				logf("Denied %s-%s to %s: %s\n", item.Chart, item.Version, server2, reason)
+				summary.deny(item, server2.String(), reason)
+			}
+			return reason == ""
+		}
+
+		// Long runs can overlap with other uploads to the destination.
+		if checker != nil && !hasVersion(data2, item.Chart, item.Version) {
+			if exists, err := checker.hasChart(ctx, item.Chart, item.Version); err == nil && exists {
+				summary.skip(item)
+				return
+			}
+		}
+		if policy != nil && !contents && !allowed(nil) {
+			return
+		}
+
+		if stream {
+			body, size, err := src.openChart(ctx, item.Chart, item.Version)
+			if err != nil {
+				failed(sourceError(fmt.Errorf("fetching from %s: %w", server1, err)))
+				return
+			}
+			if d, ok := body.(interface{ expectDigest(string) }); ok {
+				d.expectDigest(indexDigest(data1, item.Chart, item.Version))
+			}
+			var r io.Reader
+			counter := &countingReader{}
+			if r, err = limitChart(body, size); err == nil {
+				counter.r = c.reader(r)
+				err = dst.pushChartStream(ctx, item.Chart, item.Version, counter, size)
+			}
+			body.Close()
+			if err != nil {
+				failed(destError(fmt.Errorf("pushing to %s: %w", server2, err)))
+				return
+			}
+			done(counter.n)
+			notes(nil)
+			return
+		}
+
+		// The chart is needed more than once, it is spooled to disk when
+		// it is bigger than -max-memory.
+		var sp *spool
+		var err error
+		from := item.URL
+		if item.URL == "" {
+			sp, err = fetchSpool(ctx, server1, item.Chart, item.Version, indexDigest(data1, item.Chart, item.Version))
+			from = server1.String()
+		} else {
+			var body io.ReadCloser
+			var size int64
+			if body, size, err = deps.open(ctx, item.URL); err == nil {
+				var r io.Reader
+				if r, err = limitChart(body, size); err == nil {
+					sp, err = newSpool(r)
+				}
+				body.Close()
+			}
+		}
+		if err != nil {
+			failed(sourceError(fmt.Errorf("fetching from %s: %w", from, err)))
+			return
+		}
+		defer sp.close()
+		if sp.cached {
+			summary.cacheHit(sp.size)
+		}
+
+		if contents {
+			files, err := chartContents(sp.reader())
+			if err != nil {
+				failed(fmt.Errorf("reading %s-%s for the policy: %w", item.Chart, item.Version, err))
+				return
+			}
+			if !allowed(files) {
+				return
+			}
+		}
+
+		if deps != nil {
+			chartDeps, err := chartDependencies(sp.reader())
+			if err != nil {
+				logf("Failed to read dependencies of %s-%s %v\n", item.Chart, item.Version, err)
+			}
+			for _, dep := range chartDeps {
+				depItem, err := deps.resolve(ctx, dep)
+				if err != nil {
+					logf("Skipping dependency %s of %s-%s %v\n", dep.Name, item.Chart, item.Version, err)
+					continue
+				}
+				if depItem != nil {
+					wg.Add(1)
+					go func() {
+						sem <- struct{}{}
+						adaptive.acquire()
+						process(*depItem)
+					}()
+				}
+			}
+		}
+
+		if canStream {
+			err = dst.pushChartStream(ctx, item.Chart, item.Version, c.reader(sp.reader()), sp.size)
+		} else {
+			var data []byte
+			if data, err = sp.bytes(); err == nil {
+				if err = server2.pushChart(ctx, item.Chart, item.Version, data); err == nil {
+					c.count(sp.size)
+				}
+			}
+		}
+		if err != nil {
+			failed(destError(fmt.Errorf("pushing to %s: %w", server2, err)))
+			return
+		}
+		//logf("Successfully synced %s-%s to %s\n", item.Chart, item.Version, server2)
+		done(sp.size)
+		notes(sp)
+	}
+	// process transfers item in the worker slot and adaptive slot it was
+	// given.
+	process = func(item syncItem) {
+		defer wg.Done()
+		defer func() { <-sem }()
+		defer adaptive.release()
+		size, queued := queuedSize[item]
+		c := progress.chart(item, size, queued)
+		if ctx.Err() != nil {
+			c.finish()
+			return
+		}
+		if p.hold != nil && p.hold() {
+			p.mu.Lock()
+			p.held = append(p.held, item)
+			p.mu.Unlock()
+			c.finish()
+			return
+		}
+		if opts.ChartTimeout <= 0 {
+			transfer(ctx, item, c)
+			return
+		}
+		timeout := codeError{"E_CHART_TIMEOUT", kindError{fmt.Sprintf("timed out after %s", opts.ChartTimeout), ErrNetwork}}
+		tctx, cancel := context.WithTimeoutCause(ctx, opts.ChartTimeout, timeout)
+		defer cancel()
+		transfer(tctx, item, c)
+	}
+
+	// The slots are taken here rather than in the goroutines, so charts
+	// start in the order of the queue.
+	for _, item := range queue {
+		sem <- struct{}{}
+		adaptive.acquire()
+		wg.Add(1)
+		go process(item)
+	}
+	wg.Wait()
+	if adaptive != nil && len(queue) > 0 {
+		peak, current := adaptive.levels()
+		logf("Transferred up to %d charts in parallel, %d at the end\n", peak, current)
+	}
+
+	if f, ok := server2.(interface{ flush(context.Context) error }); ok {
+		if err := f.flush(ctx); err != nil {
+			logf("Failed to write index of %s %v\n", server2, err)
+		}
+	}
+}
+
+func checkInfoEndpoint(ctx context.Context, r repo) error {
+	resp, err := r.get(ctx, r.server+"/info")
+	if err != nil {
+		return fmt.Errorf("error making request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return unexpectedStatus(resp.StatusCode)
+	}
+
+	var data map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return fmt.Errorf("error decoding JSON: %w", err)
+	}
+
+	if _, ok := data["version"]; !ok {
+		return fmt.Errorf("missing 'version' key in JSON")
+	}
+
+	return nil
+}
+
+// detectServerType recognizes Harbor by its systeminfo endpoint, a server
+// without chartmuseum's /info but with an index.yaml is a static repo.
+func detectServerType(ctx context.Context, server string, auth credentials, client *http.Client) string {
+	auth = withStoredLogin(server, auth)
+	if u, err := url.Parse(server); err == nil {
+		u.Path = ""
+		r := repo{server: u.String(), auth: auth, client: client}
+		if resp, err := r.get(ctx, r.server+"/api/v2.0/systeminfo"); err == nil {
+			var info struct {
+				HarborVersion string `json:"harbor_version"`
+			}
+			ok := resp.StatusCode == http.StatusOK && json.NewDecoder(resp.Body).Decode(&info) == nil && info.HarborVersion != ""
+			resp.Body.Close()
+			if ok {
+				return "harbor"
+			}
+		}
+	}
+
+	r := repo{server: server, auth: auth, client: client}
+	if checkInfoEndpoint(ctx, r) != nil {
+		if resp, err := r.get(ctx, server+"/index.yaml"); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return "static"
+			}
+		}
+	}
+	return "chartmuseum"
+}
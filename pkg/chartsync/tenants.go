@@ -0,0 +1,48 @@
+package chartsync
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+func normalizeTenant(t string) string {
+	return strings.Trim(strings.TrimSpace(t), "/")
+}
+
+func parseTenantMap(s string) (map[string]string, error) {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid mapping %q, expected source=destination", pair)
+		}
+		m[normalizeTenant(from)] = normalizeTenant(to)
+	}
+	return m, nil
+}
+
+// tenantList returns the source tenants to sync: the -tenants list if given,
+// otherwise every mapped tenant, otherwise just the root repository.
+func tenantList(tenants string, tenantMap map[string]string) []string {
+	var list []string
+	for _, t := range strings.Split(tenants, ",") {
+		if t = normalizeTenant(t); t != "" {
+			list = append(list, t)
+		}
+	}
+	if len(list) > 0 {
+		return list
+	}
+	for t := range tenantMap {
+		list = append(list, t)
+	}
+	sort.Strings(list)
+	if len(list) == 0 {
+		list = []string{""}
+	}
+	return list
+}
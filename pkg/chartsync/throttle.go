@@ -0,0 +1,169 @@
+package chartsync
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttleChunk bounds a single read, so a throttled transfer sleeps in
+// small steps instead of bursting a large buffer at once.
+const throttleChunk = 32 << 10
+
+// hostLimits are the -source-rps and -dest-rps limiters by server host.
+var hostLimits = make(map[string][]*limiter)
+
+// downLimit and upLimit are the limiters from -max-bandwidth that received
+// and sent bytes are paced by, a global limit is in both.
+var downLimit, upLimit []*limiter
+
+// limiter is a token bucket that paces events, bytes or requests, to rate
+// per second across all callers.
+type limiter struct {
+	mu   sync.Mutex
+	rate float64
+	next time.Time
+}
+
+func newLimiter(rate float64) *limiter {
+	return &limiter{rate: rate}
+}
+
+// wait takes n events from the bucket, blocking until the ones taken
+// before are paid off or ctx is done.
+func (l *limiter) wait(ctx context.Context, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	at := l.next
+	if now := time.Now(); at.Before(now) {
+		at = now
+	}
+	l.next = at.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	l.mu.Unlock()
+	t := time.NewTimer(time.Until(at))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type throttledReader struct {
+	ctx    context.Context
+	r      io.Reader
+	limits []*limiter
+}
+
+func (t throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := t.r.Read(p)
+	for _, l := range t.limits {
+		if werr := l.wait(t.ctx, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// throttle paces reads from r by limits until ctx is done, r is returned
+// as is without any.
+func throttle(ctx context.Context, r io.Reader, limits []*limiter) io.Reader {
+	if len(limits) == 0 {
+		return r
+	}
+	return throttledReader{ctx: ctx, r: r, limits: limits}
+}
+
+// throttledTransport paces requests by hostLimits, request bodies by
+// upLimit and response bodies by downLimit. It also identifies them.
+type throttledTransport struct {
+	http.RoundTripper
+}
+
+func (t throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = identify(req)
+	for _, l := range hostLimits[req.URL.Host] {
+		if err := l.wait(req.Context(), 1); err != nil {
+			return nil, err
+		}
+	}
+	if len(upLimit) > 0 && req.Body != nil && req.Body != http.NoBody {
+		body := req.Body
+		req = req.Clone(req.Context())
+		req.Body = readCloser{Reader: throttle(req.Context(), body, upLimit), close: body.Close}
+	}
+	resp, err := t.RoundTripper.RoundTrip(req)
+	if err == nil && len(downLimit) > 0 {
+		body := resp.Body
+		resp.Body = readCloser{Reader: throttle(req.Context(), body, downLimit), close: body.Close}
+	}
+	return resp, err
+}
+
+// setBandwidth applies -max-bandwidth, a rate like 10MB/s for all traffic
+// and down=RATE or up=RATE for received and sent bytes, comma separated.
+func setBandwidth(spec string) error {
+	downLimit, upLimit = nil, nil
+	for _, part := range splitList(spec) {
+		dir, rate, ok := strings.Cut(part, "=")
+		if !ok {
+			dir, rate = "", part
+		}
+		n, err := parseSize(strings.TrimSuffix(strings.TrimSuffix(rate, "/s"), "ps"))
+		if err != nil || n == 0 {
+			return fmt.Errorf("invalid bandwidth %q", part)
+		}
+		l := newLimiter(float64(n))
+		switch dir {
+		case "":
+			downLimit = append(downLimit, l)
+			upLimit = append(upLimit, l)
+		case "down":
+			downLimit = append(downLimit, l)
+		case "up":
+			upLimit = append(upLimit, l)
+		default:
+			return fmt.Errorf("invalid bandwidth %q, the direction is down or up", part)
+		}
+	}
+	return nil
+}
+
+// bandwidth is the rate transfers are throttled to by -max-bandwidth, the
+// lower one of both directions, or 0 without a limit.
+func bandwidth() float64 {
+	rate := 0.0
+	for _, l := range append(append([]*limiter(nil), downLimit...), upLimit...) {
+		if rate == 0 || l.rate < rate {
+			rate = l.rate
+		}
+	}
+	return rate
+}
+
+// limitRequests caps the requests per second sent to the server of ref,
+// only http and oci servers are limited.
+func limitRequests(rps float64, ref string) {
+	if rps <= 0 {
+		return
+	}
+	u, err := url.Parse(ref)
+	if err != nil || u.Host == "" {
+		return
+	}
+	switch u.Scheme {
+	case "http", "https", "oci":
+		hostLimits[u.Host] = append(hostLimits[u.Host], newLimiter(rps))
+	}
+}
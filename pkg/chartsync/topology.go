@@ -0,0 +1,150 @@
+package chartsync
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+)
+
+// topologyReport breaks the summary of a hub and spoke run down by spoke.
+type topologyReport struct {
+	mu     sync.Mutex
+	spokes []*spokeReport
+}
+
+type spokeReport struct {
+	Name      string `json:"name"`
+	Synced    int    `json:"synced"`
+	Skipped   int    `json:"skipped"`
+	Failed    int    `json:"failed"`
+	Bytes     int64  `json:"bytes"`
+	Conflicts int    `json:"conflicts"`
+	// Unreachable is set when the charts of the spoke couldn't be
+	// fetched.
+	Unreachable bool `json:"unreachable,omitempty"`
+}
+
+// newTopologyReport reports the spokes of jobs in their order, nil when
+// they aren't spokes of a hub.
+func newTopologyReport(jobs []syncJob) *topologyReport {
+	t := &topologyReport{}
+	for _, job := range jobs {
+		if job.spoke != "" && t.spoke(job.spoke) == nil {
+			t.spokes = append(t.spokes, &spokeReport{Name: job.spoke})
+		}
+	}
+	if len(t.spokes) == 0 {
+		return nil
+	}
+	return t
+}
+
+func (t *topologyReport) spoke(name string) *spokeReport {
+	for _, s := range t.spokes {
+		if s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// unreachable marks the spokes of jobs that aren't in planned.
+func (t *topologyReport) unreachable(jobs []syncJob, planned []plannedJob) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	reached := make(map[string]bool)
+	for _, job := range planned {
+		reached[job.spoke] = true
+	}
+	for _, job := range jobs {
+		if s := t.spoke(job.spoke); s != nil && !reached[job.spoke] {
+			s.Unreachable = true
+		}
+	}
+}
+
+// conflicts counts n conflicts found on spoke.
+func (t *topologyReport) conflicts(spoke string, n int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s := t.spoke(spoke); s != nil {
+		s.Conflicts += n
+	}
+}
+
+// reporter counts the outcomes of the transfers to spoke.
+func (t *topologyReport) reporter(spoke string) Reporter {
+	return spokeReporter{t, spoke}
+}
+
+type spokeReporter struct {
+	report *topologyReport
+	spoke  string
+}
+
+func (r spokeReporter) count(f func(s *spokeReport)) {
+	r.report.mu.Lock()
+	defer r.report.mu.Unlock()
+	if s := r.report.spoke(r.spoke); s != nil {
+		f(s)
+	}
+}
+
+func (r spokeReporter) Synced(_ Version, size int64) {
+	r.count(func(s *spokeReport) { s.Synced++; s.Bytes += size })
+}
+
+func (r spokeReporter) Skipped(Version) {
+	r.count(func(s *spokeReport) { s.Skipped++ })
+}
+
+func (r spokeReporter) Failed(Version, error) {
+	r.count(func(s *spokeReport) { s.Failed++ })
+}
+
+// print writes the table of the spokes below the summary.
+func (t *topologyReport) print(w io.Writer) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintln(w, "\nSpokes:")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  SPOKE\tSTATUS\tSYNCED\tSKIPPED\tFAILED\tTRANSFERRED\tCONFLICTS")
+	for _, s := range t.spokes {
+		status := "ok"
+		switch {
+		case s.Unreachable:
+			status = "unreachable"
+		case s.Failed > 0:
+			status = "failing"
+		case s.Conflicts > 0:
+			status = "diverged"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%d\t%d\t%d\t%s\t%d\n", redact(s.Name), status, s.Synced, s.Skipped, s.Failed, formatSize(s.Bytes), s.Conflicts)
+	}
+	tw.Flush()
+}
+
+// json are the spokes for -summary-json.
+func (t *topologyReport) json() []spokeReport {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]spokeReport, len(t.spokes))
+	for i, s := range t.spokes {
+		out[i] = *s
+		out[i].Name = redact(s.Name)
+	}
+	return out
+}
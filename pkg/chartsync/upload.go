@@ -0,0 +1,138 @@
+package chartsync
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// validateChart reads the metadata of a packaged chart and checks it is
+// something helm would install: a named chart with a semver version.
+func validateChart(f io.ReadSeeker) (ChartVersion, error) {
+	cv, err := readChartMetadata(f)
+	if err != nil {
+		return cv, fmt.Errorf("not a packaged chart: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return cv, err
+	}
+	if strings.ContainsAny(cv.Name, "/\\ ") || strings.HasPrefix(cv.Name, ".") {
+		return cv, fmt.Errorf("invalid chart name %q", cv.Name)
+	}
+	if _, err := semver.StrictNewVersion(cv.Version); err != nil {
+		return cv, fmt.Errorf("version %q of %s is not semver", cv.Version, cv.Name)
+	}
+	return cv, nil
+}
+
+// runUpload validates and pushes local chart tarballs, so CI can publish
+// with the binary it mirrors with.
+func runUpload(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("upload", flag.ExitOnError)
+	cf := addDestinationFlags(fs)
+	tenant := fs.String("tenant", "", "org/repo path to upload to on a multitenant chartmuseum")
+	force := fs.Bool("force", false, "overwrite versions the destination already has")
+	yes := fs.Bool("yes", false, "don't ask for confirmation before -force overwrites versions")
+	withProv := fs.Bool("prov", false, "also upload the .prov file next to each tarball, failing when it is missing")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cm_sync upload [flags] CHART.tgz...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	cfg := cf.config(fs)
+	cfg.Force = cfg.Force || *force
+	dst, destData := openDestination(ctx, cfg, normalizeTenant(*tenant))
+	if cfg.Force {
+		var changes []string
+		for _, file := range fs.Args() {
+			if cv, err := chartFileMetadata(file); err == nil && hasVersion(destData, cv.Name, cv.Version) {
+				changes = append(changes, fmt.Sprintf("%s-%s from %s", cv.Name, cv.Version, file))
+			}
+		}
+		if len(changes) > 0 && !confirmChanges(*yes, fmt.Sprintf("Overwrite %d versions %s already has?", len(changes), dst), changes) {
+			logln("Nothing uploaded")
+			os.Exit(1)
+		}
+	}
+
+	failed := false
+	for _, file := range fs.Args() {
+		if err := uploadFile(ctx, dst, destData, file, cfg.Force, *withProv); err != nil {
+			logf("Failed to upload %s to %s %v\n", file, dst, err)
+			failed = true
+		}
+	}
+	if f, ok := dst.(interface{ flush(context.Context) error }); ok {
+		if err := f.flush(ctx); err != nil {
+			logf("Failed to write index of %s %v\n", dst, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// chartFileMetadata is the metadata of the packaged chart in file.
+func chartFileMetadata(file string) (ChartVersion, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return ChartVersion{}, err
+	}
+	defer f.Close()
+	return readChartMetadata(f)
+}
+
+func uploadFile(ctx context.Context, dst chartDestination, destData ChartData, file string, force, withProv bool) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	cv, err := validateChart(f)
+	if err != nil {
+		return err
+	}
+	var prov []byte
+	if withProv {
+		if prov, err = os.ReadFile(file + ".prov"); err != nil {
+			return err
+		}
+	}
+	if hasVersion(destData, cv.Name, cv.Version) && !force {
+		logf("Skipping %s-%s, %s already has it (-force overwrites)\n", cv.Name, cv.Version, dst)
+		return nil
+	}
+
+	if s, ok := dst.(chartStreamPusher); ok {
+		err = s.pushChartStream(ctx, cv.Name, cv.Version, f, info.Size())
+	} else {
+		var data []byte
+		if data, err = io.ReadAll(f); err == nil {
+			err = dst.pushChart(ctx, cv.Name, cv.Version, data)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if prov != nil {
+		if err := pushProvenance(ctx, dst, cv.Name, cv.Version, prov); err != nil {
+			return fmt.Errorf("uploading provenance: %w", err)
+		}
+	}
+	logf("Uploaded %s-%s to %s\n", cv.Name, cv.Version, dst)
+	return nil
+}
@@ -0,0 +1,113 @@
+package chartsync
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// runWatch uploads the chart tarballs dropped into a directory as they
+// appear. A file is uploaded once it wasn't written to for -settle, so
+// charts still being copied in aren't picked up half done.
+func runWatch(ctx context.Context, args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	cf := addDestinationFlags(fs)
+	tenant := fs.String("tenant", "", "org/repo path to upload to on a multitenant chartmuseum")
+	force := fs.Bool("force", false, "overwrite versions the destination already has, needs -yes")
+	yes := fs.Bool("yes", false, "confirm that -force overwrites versions without asking, watch can't ask")
+	withProv := fs.Bool("prov", false, "also upload the .prov file next to each tarball, waiting for it to appear")
+	settle := fs.Duration("settle", 2*time.Second, "time a file has to stay unchanged before it is uploaded")
+	existing := fs.Bool("existing", false, "also upload the tarballs already in the directory at startup")
+	remove := fs.Bool("remove", false, "delete tarballs from the directory once they are uploaded")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: cm_sync watch [flags] DIR")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	dir := fs.Arg(0)
+	cfg := cf.config(fs)
+	cfg.Force = cfg.Force || *force
+	if cfg.Force && !*yes {
+		logln("-force needs -yes, watch overwrites versions without asking")
+		os.Exit(1)
+	}
+	dst, _ := openDestination(ctx, cfg, normalizeTenant(*tenant))
+
+	w, err := fsnotify.NewWatcher()
+	if err == nil {
+		err = w.Add(dir)
+	}
+	if err != nil {
+		logln("Error watching", dir, "\n", err)
+		os.Exit(1)
+	}
+	defer w.Close()
+
+	ready := make(chan string)
+	timers := make(map[string]*time.Timer)
+	schedule := func(name string) {
+		if t, ok := timers[name]; ok {
+			t.Reset(*settle)
+			return
+		}
+		timers[name] = time.AfterFunc(*settle, func() { ready <- name })
+	}
+	if *existing {
+		files, _ := filepath.Glob(filepath.Join(dir, "*.tgz"))
+		for _, name := range files {
+			schedule(name)
+		}
+	}
+	logln("Watching", dir, "for charts to upload to", dst)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-w.Events:
+			if !ev.Has(fsnotify.Create) && !ev.Has(fsnotify.Write) {
+				continue
+			}
+			switch {
+			case strings.HasSuffix(ev.Name, ".tgz"):
+				schedule(ev.Name)
+			case *withProv && strings.HasSuffix(ev.Name, ".tgz.prov"):
+				schedule(strings.TrimSuffix(ev.Name, ".prov"))
+			}
+		case err := <-w.Errors:
+			logln("Failed to watch", dir, err)
+		case name := <-ready:
+			delete(timers, name)
+			if _, err := os.Stat(name); err != nil {
+				continue
+			}
+			destData, err := dst.listCharts(ctx)
+			if err == nil {
+				err = uploadFile(ctx, dst, destData, name, cfg.Force, *withProv)
+			}
+			if f, ok := dst.(interface{ flush(context.Context) error }); ok && err == nil {
+				err = f.flush(ctx)
+			}
+			if err != nil {
+				logf("Failed to upload %s to %s %v\n", name, dst, err)
+				continue
+			}
+			if *remove {
+				os.Remove(name)
+				if *withProv {
+					os.Remove(name + ".prov")
+				}
+			}
+		}
+	}
+}
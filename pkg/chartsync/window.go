@@ -0,0 +1,138 @@
+package chartsync
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	_ "time/tzdata"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// transferWindow is when charts may be transferred, e.g.
+// "22:00-06:00 Mon-Fri, 00:00-24:00 Sat-Sun, tz Europe/Berlin".
+type transferWindow struct {
+	spans []windowSpan
+	loc   *time.Location
+}
+
+// windowSpan runs from start to end, in minutes after midnight, on days.
+// A span ending before it starts runs into the next day.
+type windowSpan struct {
+	start, end int
+	days       [7]bool
+}
+
+func parseWindow(s string) (*transferWindow, error) {
+	s = strings.NewReplacer("–", "-", "—", "-").Replace(s)
+	w := &transferWindow{loc: time.Local}
+	for _, part := range splitList(s) {
+		fields := strings.Fields(part)
+		if strings.EqualFold(fields[0], "tz") {
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("invalid time zone %q", part)
+			}
+			loc, err := time.LoadLocation(fields[1])
+			if err != nil {
+				return nil, err
+			}
+			w.loc = loc
+			continue
+		}
+		span, err := parseSpan(fields)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", part, err)
+		}
+		w.spans = append(w.spans, span)
+	}
+	if len(w.spans) == 0 {
+		return nil, fmt.Errorf("window %q has no time range", s)
+	}
+	return w, nil
+}
+
+func parseSpan(fields []string) (windowSpan, error) {
+	var span windowSpan
+	from, to, ok := strings.Cut(fields[0], "-")
+	if !ok {
+		return span, fmt.Errorf("%q is not a time range like 22:00-06:00", fields[0])
+	}
+	var err error
+	if span.start, err = parseClock(from); err != nil {
+		return span, err
+	}
+	if span.end, err = parseClock(to); err != nil {
+		return span, err
+	}
+	if len(fields) == 1 {
+		span.days = [7]bool{true, true, true, true, true, true, true}
+	}
+	for _, days := range fields[1:] {
+		first, last, isRange := strings.Cut(days, "-")
+		if !isRange {
+			last = first
+		}
+		d1, ok1 := weekdays[strings.ToLower(first)[:min(3, len(first))]]
+		d2, ok2 := weekdays[strings.ToLower(last)[:min(3, len(last))]]
+		if !ok1 || !ok2 {
+			return span, fmt.Errorf("unknown day %q", days)
+		}
+		for d := d1; ; d = (d + 1) % 7 {
+			span.days[d] = true
+			if d == d2 {
+				break
+			}
+		}
+	}
+	return span, nil
+}
+
+// parseClock reads HH:MM as minutes after midnight, 24:00 is the end of
+// the day.
+func parseClock(s string) (int, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hour, err1 := strconv.Atoi(h)
+	minute, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hour < 0 || minute < 0 || minute > 59 || hour*60+minute > 24*60 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return hour*60 + minute, nil
+}
+
+func (w *transferWindow) contains(t time.Time) bool {
+	t = t.In(w.loc)
+	m := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	yesterday := (day + 6) % 7
+	for _, s := range w.spans {
+		if s.start < s.end {
+			if s.days[day] && m >= s.start && m < s.end {
+				return true
+			}
+			continue
+		}
+		if s.days[day] && m >= s.start || s.days[yesterday] && m < s.end {
+			return true
+		}
+	}
+	return false
+}
+
+// opens returns when the window opens next, t itself if it is open.
+func (w *transferWindow) opens(t time.Time) time.Time {
+	if w.contains(t) {
+		return t
+	}
+	next := t.Truncate(time.Minute)
+	for i := 0; i < 8*24*60; i++ {
+		next = next.Add(time.Minute)
+		if w.contains(next) {
+			return next
+		}
+	}
+	return t
+}